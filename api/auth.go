@@ -0,0 +1,154 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wirsal/fcm-gateway/internal/auth"
+)
+
+// APIKeyHeader carries an API key for clients that cannot send an
+// Authorization: Bearer header.
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyKey is the gin context key AuthMiddleware stores the caller's key
+// under.
+const apiKeyKey = "api_key"
+
+// conditionTermPattern matches the topic term at the start of a condition
+// such as "'news' in topics && !('sports' in topics)".
+var conditionTermPattern = regexp.MustCompile(`^'([^']*)'\s+in\s+topics`)
+
+// AuthMiddleware rejects requests without a valid API key or JWT with 401.
+// The credential is taken from the Authorization: Bearer header, else from
+// X-API-Key. Bearer tokens shaped like a JWT are checked by jwts, everything
+// else against keys. Either of keys and jwts may be nil.
+func AuthMiddleware(keys *auth.Keyring, jwts *auth.JWTVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		secret := c.GetHeader(APIKeyHeader)
+		bearer := false
+		if scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+			secret = strings.TrimSpace(token)
+			bearer = true
+		}
+		if secret == "" {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing API key: send it as Authorization: Bearer <key> or in the " + APIKeyHeader + " header"})
+			return
+		}
+
+		if bearer && jwts != nil && auth.LooksLikeJWT(secret) {
+			key, err := jwts.Verify(c.Request.Context(), secret)
+			if err != nil {
+				c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token: " + err.Error()})
+				return
+			}
+			c.Set(apiKeyKey, key)
+			c.Next()
+			return
+		}
+
+		var key *auth.Key
+		ok := false
+		if keys != nil {
+			key, ok = keys.Authenticate(secret)
+		}
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+			return
+		}
+
+		c.Set(apiKeyKey, key)
+		c.Next()
+	}
+}
+
+// RequireScope rejects with 403 requests whose API key has none of scopes.
+// Requests without a key, i.e. when authentication is disabled, pass.
+func RequireScope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := apiKeyOf(c)
+		if key == nil {
+			c.Next()
+			return
+		}
+		for _, s := range scopes {
+			if key.HasScope(s) {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error":           "API key " + key.Name + " is not allowed to do this",
+			"required_scopes": scopes,
+		})
+	}
+}
+
+// apiKeyOf returns the API key of c, or nil when authentication is disabled.
+func apiKeyOf(c *gin.Context) *auth.Key {
+	if k, ok := c.Get(apiKeyKey); ok {
+		return k.(*auth.Key)
+	}
+	return nil
+}
+
+// allowTopics answers 403 and returns false unless the API key of c may use
+// every one of topics.
+func allowTopics(c *gin.Context, topics ...string) bool {
+	key := apiKeyOf(c)
+	if key == nil {
+		return true
+	}
+	for _, topic := range topics {
+		if !key.AllowsTopic(topic) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key " + key.Name + " may not use topic " + topic})
+			return false
+		}
+	}
+	return true
+}
+
+// allowCondition answers 403 and returns false unless the API key of c may
+// use every topic of condition. A key limited to some topics may only send
+// conditions conditionTopics can read, so none of their topics goes unchecked.
+func allowCondition(c *gin.Context, condition string) bool {
+	key := apiKeyOf(c)
+	if key == nil || key.Topics == nil {
+		return true
+	}
+	topics, ok := conditionTopics(condition)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key " + key.Name + " may only use conditions made of 'topic' in topics, &&, ||, ! and parentheses"})
+		return false
+	}
+	return allowTopics(c, topics...)
+}
+
+// conditionTopics returns the topics named in a topic condition. ok is false
+// when the condition holds anything besides 'topic' in topics terms, &&, ||,
+// ! and parentheses.
+func conditionTopics(condition string) (topics []string, ok bool) {
+	rest := strings.TrimSpace(condition)
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "&&"), strings.HasPrefix(rest, "||"):
+			rest = rest[2:]
+		case rest[0] == '!', rest[0] == '(', rest[0] == ')':
+			rest = rest[1:]
+		default:
+			m := conditionTermPattern.FindStringSubmatch(rest)
+			if m == nil {
+				return nil, false
+			}
+			topics = append(topics, m[1])
+			rest = rest[len(m[0]):]
+		}
+		rest = strings.TrimSpace(rest)
+	}
+	return topics, true
+}
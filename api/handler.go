@@ -1,19 +1,76 @@
 package api
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wirsal/fcm-gateway/fcm"
+	"github.com/wirsal/fcm-gateway/internal/fanout"
+	"github.com/wirsal/fcm-gateway/internal/job"
+	"github.com/wirsal/fcm-gateway/internal/logging"
+	"github.com/wirsal/fcm-gateway/internal/registry"
+	"github.com/wirsal/fcm-gateway/internal/schedule"
+	"github.com/wirsal/fcm-gateway/internal/webhook"
 )
 
 type Handler struct {
-	fcmService *fcm.Service
+	fcmService     *fcm.Service
+	maxConcurrency int
+	jobs           *job.Manager
+	schedules      *schedule.Scheduler
+	webhooks       *webhook.Notifier
+	registry       *registry.Registry
 }
 
-func NewHandler(fcmService *fcm.Service) *Handler {
-	return &Handler{fcmService: fcmService}
+// NewHandler returns a Handler that sends through fcmService, unless
+// ProjectMiddleware picked another project for the request. It sends to at
+// most maxConcurrency device tokens at the same time, hands asynchronous
+// sends to jobs and holds sends with a send_at time in schedules. Results are
+// reported through webhooks, which may be nil when callbacks are not
+// configured. reg resolves user IDs for /sendToUsers and may be nil when the
+// token registry is disabled.
+func NewHandler(fcmService *fcm.Service, maxConcurrency int, jobs *job.Manager, schedules *schedule.Scheduler, webhooks *webhook.Notifier, reg *registry.Registry) *Handler {
+	return &Handler{
+		fcmService:     fcmService,
+		maxConcurrency: maxConcurrency,
+		jobs:           jobs,
+		schedules:      schedules,
+		webhooks:       webhooks,
+		registry:       reg,
+	}
+}
+
+// service returns the FCM service of the request's project, as picked by
+// ProjectMiddleware, and the handler's own service for requests that did not
+// pass through it.
+func (h *Handler) service(c *gin.Context) *fcm.Service {
+	if s, ok := c.Get(projectServiceKey); ok {
+		return s.(*fcm.Service)
+	}
+	return h.fcmService
+}
+
+// validate checks p and that its callback URL can be honoured.
+func (h *Handler) validate(p *MessagePayload) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	if p.CallbackURL == "" {
+		return nil
+	}
+	if h.webhooks == nil {
+		return errors.New("callback_url requires webhooks.secret to be configured")
+	}
+	if err := h.webhooks.CheckURL(p.CallbackURL); err != nil {
+		return fmt.Errorf("callback_url: %w", err)
+	}
+	return nil
 }
 
 func (h *Handler) Welcome(c *gin.Context) {
@@ -34,23 +91,52 @@ func (h *Handler) SendNotification(c *gin.Context) {
 		return
 	}
 
-	successCount := 0
-	failureCount := 0
-	var failedTokens []map[string]string
+	if err := h.validate(&payload.MessagePayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body: " + err.Error()})
+		return
+	}
 
-	for _, token := range payload.Tokens {
-		_, err := h.fcmService.SendNotification(token, payload.Notification, payload.Android.Priority, payload.Apns.Headers, payload.Apns.Payload)
-		if err != nil {
-			log.Printf("Gagal kirim ke token %s: %v", token, err)
-			failureCount++
-			failedTokens = append(failedTokens, map[string]string{"token": token, "error": err.Error()})
-		} else {
-			successCount++
-		}
+	sendAt, scheduled, err := payload.sendAt()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body: " + err.Error()})
+		return
+	}
+	if scheduled {
+		h.addSchedule(c, schedule.Schedule{
+			Tokens:      payload.Tokens,
+			Message:     payload.message(),
+			DryRun:      payload.DryRun,
+			SendAt:      sendAt,
+			CallbackURL: payload.CallbackURL,
+		})
+		return
 	}
+
+	async, err := strconv.ParseBool(c.DefaultQuery("async", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid async query parameter: " + err.Error()})
+		return
+	}
+	if async {
+		h.submitJob(c, payload)
+		return
+	}
+
+	service := h.service(c)
+	results := h.sendEach(c.Request.Context(), service, payload.Tokens, payload.message(), payload.DryRun)
+
+	dryRun := payload.DryRun || service.DryRun()
+	h.webhooks.Notify(payload.CallbackURL, webhook.TokensEvent(webhook.TypeSendCompleted, results, dryRun))
+
+	failedTokens := newFailedTokens(results)
+	failureCount := len(failedTokens)
+	successCount := len(results) - failureCount
+
 	response := gin.H{
 		"success_count": successCount,
 		"failure_count": failureCount,
+		"dry_run":       dryRun,
+		"results":       results,
 	}
 	if failureCount > 0 {
 		response["failed_tokens"] = failedTokens
@@ -59,6 +145,180 @@ func (h *Handler) SendNotification(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// SendToUsers sends to every registered token of each user in user_ids and
+// reports the results per user.
+func (h *Handler) SendToUsers(c *gin.Context) {
+	if h.registry == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Token registry is not enabled"})
+		return
+	}
+
+	var payload UsersPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body: " + err.Error()})
+		return
+	}
+	var userIDs []string
+	for _, id := range payload.UserIDs {
+		if id == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "user_ids cannot contain empty IDs"})
+			return
+		}
+		if !slices.Contains(userIDs, id) {
+			userIDs = append(userIDs, id)
+		}
+	}
+	if len(userIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_ids list cannot be empty"})
+		return
+	}
+	if err := h.validate(&payload.MessagePayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body: " + err.Error()})
+		return
+	}
+
+	// owners[i] is the index in users of the user tokens[i] belongs to.
+	users := make([]UserResult, len(userIDs))
+	var tokens []string
+	var owners []int
+	for i, userID := range userIDs {
+		users[i] = UserResult{UserID: userID, Results: []fcm.TokenResult{}}
+		devices, err := h.registry.List(registry.Filter{UserID: userID, Project: projectOf(c)})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up tokens", "details": err.Error()})
+			return
+		}
+		for _, d := range devices {
+			tokens = append(tokens, d.Token)
+			owners = append(owners, i)
+		}
+	}
+
+	service := h.service(c)
+	results := h.sendEach(c.Request.Context(), service, tokens, payload.message(), payload.DryRun)
+
+	for i, res := range results {
+		u := &users[owners[i]]
+		u.Results = append(u.Results, res)
+		if res.Error != "" {
+			u.FailureCount++
+		} else {
+			u.SuccessCount++
+			u.Delivered = true
+		}
+	}
+	delivered := 0
+	for _, u := range users {
+		if u.Delivered {
+			delivered++
+		}
+	}
+
+	dryRun := payload.DryRun || service.DryRun()
+	h.webhooks.Notify(payload.CallbackURL, webhook.TokensEvent(webhook.TypeUsersCompleted, results, dryRun))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success_count": delivered,
+		"failure_count": len(users) - delivered,
+		"dry_run":       dryRun,
+		"users":         users,
+	})
+}
+
+// submitJob queues payload on the job manager and answers 202 with the ID
+// to poll on GET /jobs/:id.
+func (h *Handler) submitJob(c *gin.Context, payload RequestPayload) {
+	j, err := h.jobs.Submit(projectOf(c), payload.Tokens, payload.message(), payload.DryRun, payload.CallbackURL)
+	if errors.Is(err, job.ErrQueueFull) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Job queue is full, try again later"})
+		return
+	}
+	if errors.Is(err, job.ErrShuttingDown) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is shutting down, try again later"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job", "details": err.Error()})
+		return
+	}
+
+	c.Header("Location", "/jobs/"+j.ID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id": j.ID,
+		"status": j.Status,
+		"total":  j.Total,
+	})
+}
+
+// sendEach sends msg to every token. Once ctx is cancelled, because the
+// client went away or the gateway is shutting down, the remaining tokens
+// are reported as not attempted instead of being sent.
+func (h *Handler) sendEach(ctx context.Context, service *fcm.Service, tokens []string, msg fcm.Message, dryRun bool) []fcm.TokenResult {
+	return fanout.Each(tokens, h.maxConcurrency, func(token string) fcm.TokenResult {
+		if err := ctx.Err(); err != nil {
+			return fcm.NotAttempted(token, err, dryRun || service.DryRun())
+		}
+		res, err := service.SendNotification(ctx, token, msg, dryRun)
+		if err != nil {
+			slog.WarnContext(ctx, "Send to token failed", "token", logging.Token(token), "attempts", res.Attempts, "error_code", fcm.ErrorCode(err), "error", err)
+		}
+		return fcm.NewTokenResult(token, res, err)
+	})
+}
+
+// addSchedule holds sch until its send time and answers 202 with the
+// stored schedule.
+func (h *Handler) addSchedule(c *gin.Context, sch schedule.Schedule) {
+	sch.Project = projectOf(c)
+	sch, err := h.schedules.Add(sch)
+	if errors.Is(err, schedule.ErrNotInFuture) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body: " + err.Error()})
+		return
+	}
+	if errors.Is(err, schedule.ErrShuttingDown) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is shutting down, try again later"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule message", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, sch)
+}
+
+// ListSchedules lists the pending schedules of the projects the caller's API
+// key may use.
+func (h *Handler) ListSchedules(c *gin.Context) {
+	schedules := slices.DeleteFunc(h.schedules.List(), func(sch schedule.Schedule) bool {
+		return !allowsProject(c, sch.Project)
+	})
+	c.JSON(http.StatusOK, gin.H{"schedules": schedules})
+}
+
+// CancelSchedule answers 404 for schedules of projects the caller's API key
+// may not use, as if they did not exist.
+func (h *Handler) CancelSchedule(c *gin.Context) {
+	id := c.Param("id")
+	if sch, ok := h.schedules.Get(id); !ok || !allowsProject(c, sch.Project) || !h.schedules.Cancel(id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule cancelled", "id": id})
+}
+
+// GetJob answers 404 for jobs of projects the caller's API key may not use.
+func (h *Handler) GetJob(c *gin.Context) {
+	j, ok := h.jobs.Get(c.Param("id"))
+	if !ok || !allowsProject(c, j.Project) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, jobResponse{Job: j, FailedTokens: newFailedTokens(j.Results)})
+}
+
 func (h *Handler) SendBroadcast(c *gin.Context) {
 	var payload BroadcastPayload
 
@@ -67,21 +327,153 @@ func (h *Handler) SendBroadcast(c *gin.Context) {
 		return
 	}
 
-	_, err := h.fcmService.BroadcastNotification(
-		payload.Condition,
-		payload.Notification,
-		payload.Data,
-		payload.Android.Priority,
-		payload.Apns.Headers,
-		payload.Apns.Payload,
-	)
+	if err := h.validate(&payload.MessagePayload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body: " + err.Error()})
+		return
+	}
+	if !allowCondition(c, payload.Condition) {
+		return
+	}
+
+	sendAt, scheduled, err := payload.sendAt()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body: " + err.Error()})
+		return
+	}
+	if scheduled {
+		h.addSchedule(c, schedule.Schedule{
+			Condition:   payload.Condition,
+			Message:     payload.message(),
+			DryRun:      payload.DryRun,
+			SendAt:      sendAt,
+			CallbackURL: payload.CallbackURL,
+		})
+		return
+	}
+
+	res, err := h.service(c).BroadcastNotification(c.Request.Context(), payload.Condition, payload.message(), payload.DryRun)
+	event := webhook.TargetEvent(webhook.TypeBroadcastCompleted, res, err)
+	event.Condition = payload.Condition
+	h.webhooks.Notify(payload.CallbackURL, event)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Broadcast to condition failed", "condition", payload.Condition, "attempts", res.Attempts, "error_code", fcm.ErrorCode(err), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to send broadcast",
+			"details":    err.Error(),
+			"error_code": fcm.ErrorCode(err),
+			"attempts":   res.Attempts,
+		})
+		return
+	}
+
+	message := "Broadcast message successfully sent to FCM for topic condition."
+	if res.ValidateOnly {
+		message = "Broadcast message validated by FCM (dry run); nothing was delivered."
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":       message,
+		"message_name":  res.Name,
+		"attempts":      res.Attempts,
+		"validate_only": res.ValidateOnly,
+	})
+}
+
+func (h *Handler) SendTopic(c *gin.Context) {
+	var payload TopicPayload
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body: " + err.Error()})
+		return
+	}
+
+	topic, err := fcm.NormalizeTopic(payload.Topic)
+	if err == nil {
+		err = h.validate(&payload.MessagePayload)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body: " + err.Error()})
+		return
+	}
+	if !allowTopics(c, topic) {
+		return
+	}
+
+	res, err := h.service(c).SendToTopic(c.Request.Context(), topic, payload.message(), payload.DryRun)
+	event := webhook.TargetEvent(webhook.TypeTopicCompleted, res, err)
+	event.Topic = topic
+	h.webhooks.Notify(payload.CallbackURL, event)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Send to topic failed", "topic", topic, "attempts", res.Attempts, "error_code", fcm.ErrorCode(err), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to send to topic",
+			"details":    err.Error(),
+			"error_code": fcm.ErrorCode(err),
+			"attempts":   res.Attempts,
+		})
+		return
+	}
+
+	message := "Message successfully sent to FCM for topic " + topic + "."
+	if res.ValidateOnly {
+		message = "Message for topic " + topic + " validated by FCM (dry run); nothing was delivered."
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":       message,
+		"message_name":  res.Name,
+		"attempts":      res.Attempts,
+		"validate_only": res.ValidateOnly,
+	})
+}
+
+// SubscribeTopic subscribes the given tokens to the topic in the path.
+func (h *Handler) SubscribeTopic(c *gin.Context) {
+	h.manageTopic(c, "subscribe", h.service(c).SubscribeToTopic)
+}
+
+// UnsubscribeTopic removes the given tokens from the topic in the path.
+func (h *Handler) UnsubscribeTopic(c *gin.Context) {
+	h.manageTopic(c, "unsubscribe", h.service(c).UnsubscribeFromTopic)
+}
+
+func (h *Handler) manageTopic(c *gin.Context, action string, apply func(context.Context, string, []string) ([]fcm.TopicResult, error)) {
+	var payload SubscriptionPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body: " + err.Error()})
+		return
+	}
+	if slices.Contains(payload.Tokens, "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tokens cannot contain empty tokens"})
+		return
+	}
+
+	topic, err := fcm.NormalizeTopic(c.Param("topic"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !allowTopics(c, topic) {
+		return
+	}
+	results, err := apply(c.Request.Context(), topic, payload.Tokens)
 	if err != nil {
-		log.Printf("Gagal broadcast ke condition %s: %v", payload.Condition, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send broadcast", "details": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body: " + err.Error()})
 		return
 	}
 
+	failureCount := 0
+	for _, res := range results {
+		if res.Error != "" {
+			failureCount++
+		}
+	}
+	if failureCount > 0 {
+		slog.WarnContext(c.Request.Context(), "Topic subscription change failed for some tokens", "action", action, "topic", topic, "failed", failureCount, "total", len(results))
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Broadcast message successfully sent to FCM for topic condition.",
+		"topic":         topic,
+		"success_count": len(results) - failureCount,
+		"failure_count": failureCount,
+		"results":       results,
 	})
 }
@@ -1 +1,961 @@
 package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wirsal/fcm-gateway/fcm"
+	"github.com/wirsal/fcm-gateway/internal/auth"
+	"github.com/wirsal/fcm-gateway/internal/job"
+	"github.com/wirsal/fcm-gateway/internal/schedule"
+	"github.com/wirsal/fcm-gateway/internal/webhook"
+)
+
+// newTestService starts fake OAuth2 and FCM servers and returns a real
+// fcm.Service wired to them. fcmHandler receives every messages:send call.
+func newTestService(t *testing.T, fcmHandler http.HandlerFunc, opts ...fcm.Option) *fcm.Service {
+	t.Helper()
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(tokenServer.Close)
+
+	fcmServer := httptest.NewServer(fcmHandler)
+	t.Cleanup(fcmServer.Close)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	creds, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "test-project",
+		"private_key_id": "test-key-id",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   "test@test-project.iam.gserviceaccount.com",
+		"token_uri":      tokenServer.URL,
+	})
+	require.NoError(t, err)
+
+	credsFile := filepath.Join(t.TempDir(), "service-account.json")
+	require.NoError(t, os.WriteFile(credsFile, creds, 0600))
+
+	service, err := fcm.NewService(context.Background(), credsFile,
+		[]string{"https://www.googleapis.com/auth/firebase.messaging"},
+		fcmServer.URL+"/v1/projects/%s/messages:send", opts...)
+	require.NoError(t, err)
+
+	return service
+}
+
+// readMessage decodes the FCM request body sent by the gateway.
+func readMessage(t *testing.T, r *http.Request) map[string]any {
+	t.Helper()
+	var body struct {
+		Message map[string]any `json:"message"`
+	}
+	require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+	return body.Message
+}
+
+func performRequest(t *testing.T, handler gin.HandlerFunc, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	raw, err := json.Marshal(body)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Handle(method, path, handler)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestHandler_SendNotification(t *testing.T) {
+	t.Run("success - partial failure reports error codes in token order", func(t *testing.T) {
+		// --- Setup ---
+		service := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+			token := readMessage(t, r)["token"]
+			if token == "good-1" || token == "good-2" {
+				_, _ = fmt.Fprintf(w, `{"name":"projects/test-project/messages/%s"}`, token)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"code":404,"message":"Requested entity was not found.","status":"NOT_FOUND",
+				"details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`))
+		})
+		h := NewHandler(service, 4, nil, nil, nil, nil)
+
+		// --- Execute ---
+		rec := performRequest(t, h.SendNotification, http.MethodPost, "/send", gin.H{
+			"tokens":       []string{"bad-1", "good-1", "bad-2", "good-2", "bad-3"},
+			"notification": gin.H{"title": "Hello", "body": "World"},
+		})
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var resp struct {
+			SuccessCount int               `json:"success_count"`
+			FailureCount int               `json:"failure_count"`
+			FailedTokens []FailedToken     `json:"failed_tokens"`
+			Results      []fcm.TokenResult `json:"results"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, 2, resp.SuccessCount)
+		assert.Equal(t, 3, resp.FailureCount)
+		require.Len(t, resp.FailedTokens, 3)
+		for i, token := range []string{"bad-1", "bad-2", "bad-3"} {
+			assert.Equal(t, token, resp.FailedTokens[i].Token)
+			assert.Equal(t, fcm.ErrorCodeUnregistered, resp.FailedTokens[i].ErrorCode)
+			assert.Equal(t, "FCM error 404: Requested entity was not found.", resp.FailedTokens[i].Error)
+			assert.Equal(t, 1, resp.FailedTokens[i].Attempts, "UNREGISTERED must not be retried")
+		}
+
+		require.Len(t, resp.Results, 5)
+		assert.Equal(t, "good-1", resp.Results[1].Token)
+		assert.Equal(t, "projects/test-project/messages/good-1", resp.Results[1].MessageName)
+		assert.Equal(t, 1, resp.Results[1].Attempts)
+		assert.Empty(t, resp.Results[1].Error)
+	})
+
+	t.Run("success - data-only message", func(t *testing.T) {
+		// --- Setup ---
+		var message map[string]any
+		service := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+			message = readMessage(t, r)
+			_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/1"}`))
+		})
+		h := NewHandler(service, 4, nil, nil, nil, nil)
+
+		// --- Execute ---
+		rec := performRequest(t, h.SendNotification, http.MethodPost, "/send", gin.H{
+			"tokens": []string{"token-1"},
+			"data":   gin.H{"sync": "inbox"},
+		})
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), `"success_count":1`)
+		assert.NotContains(t, message, "notification")
+		assert.Equal(t, map[string]any{"sync": "inbox"}, message["data"])
+	})
+
+	t.Run("error - neither notification nor data", func(t *testing.T) {
+		// --- Setup ---
+		h := NewHandler(nil, 4, nil, nil, nil, nil)
+
+		// --- Execute ---
+		rec := performRequest(t, h.SendNotification, http.MethodPost, "/send", gin.H{
+			"tokens": []string{"token-1"},
+		})
+
+		// --- Assert ---
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "either notification or data must be set")
+	})
+
+	t.Run("error - reserved data key", func(t *testing.T) {
+		// --- Setup ---
+		h := NewHandler(nil, 4, nil, nil, nil, nil)
+
+		// --- Execute ---
+		rec := performRequest(t, h.SendNotification, http.MethodPost, "/send", gin.H{
+			"tokens": []string{"token-1"},
+			"data":   gin.H{"from": "me"},
+		})
+
+		// --- Assert ---
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `data key \"from\" is reserved by FCM`)
+	})
+
+	t.Run("success - android config is forwarded", func(t *testing.T) {
+		// --- Setup ---
+		var message map[string]any
+		service := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+			message = readMessage(t, r)
+			_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/1"}`))
+		})
+		h := NewHandler(service, 4, nil, nil, nil, nil)
+
+		// --- Execute ---
+		rec := performRequest(t, h.SendNotification, http.MethodPost, "/send", gin.H{
+			"tokens":       []string{"token-1"},
+			"notification": gin.H{"title": "Hello"},
+			"android": gin.H{
+				"ttl":          "3600s",
+				"collapse_key": "inbox",
+				"notification": gin.H{"channel_id": "messages", "color": "#112233"},
+			},
+		})
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, map[string]any{
+			"ttl":          "3600s",
+			"collapse_key": "inbox",
+			"notification": map[string]any{"channel_id": "messages", "color": "#112233"},
+		}, message["android"])
+	})
+
+	t.Run("success - apns badge 0 and custom keys are forwarded", func(t *testing.T) {
+		// --- Setup ---
+		var message map[string]any
+		service := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+			message = readMessage(t, r)
+			_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/1"}`))
+		})
+		h := NewHandler(service, 4, nil, nil, nil, nil)
+
+		// --- Execute ---
+		rec := performRequest(t, h.SendNotification, http.MethodPost, "/send", gin.H{
+			"tokens":       []string{"token-1"},
+			"notification": gin.H{"title": "Hello"},
+			"apns": gin.H{
+				"headers": gin.H{"apns-priority": "10"},
+				"payload": gin.H{
+					"aps":       gin.H{"badge": 0, "thread-id": "chat-1", "alert": gin.H{"loc-key": "MSG", "loc-args": []string{"Budi"}}},
+					"deep_link": "app://chat/1",
+				},
+			},
+		})
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, map[string]any{
+			"headers": map[string]any{"apns-priority": "10"},
+			"payload": map[string]any{
+				"aps": map[string]any{
+					"badge":     float64(0),
+					"thread-id": "chat-1",
+					"alert":     map[string]any{"loc-key": "MSG", "loc-args": []any{"Budi"}},
+				},
+				"deep_link": "app://chat/1",
+			},
+		}, message["apns"])
+	})
+
+	t.Run("error - invalid android ttl is rejected before calling FCM", func(t *testing.T) {
+		// --- Setup ---
+		h := NewHandler(nil, 4, nil, nil, nil, nil)
+
+		// --- Execute ---
+		rec := performRequest(t, h.SendNotification, http.MethodPost, "/send", gin.H{
+			"tokens":       []string{"token-1"},
+			"notification": gin.H{"title": "Hello"},
+			"android":      gin.H{"ttl": "1 hour"},
+		})
+
+		// --- Assert ---
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "android.ttl")
+	})
+
+	t.Run("success - dry run marks every result as validate only", func(t *testing.T) {
+		// --- Setup ---
+		service := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+			var body map[string]any
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, true, body["validate_only"])
+			_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/fake_message_id"}`))
+		})
+		h := NewHandler(service, 4, nil, nil, nil, nil)
+
+		// --- Execute ---
+		rec := performRequest(t, h.SendNotification, http.MethodPost, "/send", gin.H{
+			"tokens":       []string{"token-1", "token-2"},
+			"notification": gin.H{"title": "Hello"},
+			"dry_run":      true,
+		})
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp struct {
+			DryRun  bool              `json:"dry_run"`
+			Results []fcm.TokenResult `json:"results"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.True(t, resp.DryRun)
+		require.Len(t, resp.Results, 2)
+		for _, res := range resp.Results {
+			assert.True(t, res.ValidateOnly)
+		}
+	})
+
+	t.Run("success - tokens left when the request is cancelled are not attempted", func(t *testing.T) {
+		// --- Setup ---
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var sent []any
+		service := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+			sent = append(sent, readMessage(t, r)["token"])
+			// Like a shutdown cancelling the requests still running.
+			cancel()
+			_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/1"}`))
+		})
+		h := NewHandler(service, 1, nil, nil, nil, nil)
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.POST("/send", h.SendNotification)
+
+		// --- Execute ---
+		req := httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(`{"tokens":["a","b","c"],"notification":{"title":"Hello"}}`)).WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp struct {
+			Results []fcm.TokenResult `json:"results"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Len(t, resp.Results, 3)
+		for _, res := range resp.Results[1:] {
+			assert.Equal(t, fcm.ErrorCodeNotAttempted, res.ErrorCode)
+			assert.Equal(t, "not sent: context canceled", res.Error)
+		}
+		assert.Equal(t, []any{"a"}, sent)
+	})
+
+	t.Run("error - empty token list", func(t *testing.T) {
+		// --- Setup ---
+		h := NewHandler(nil, 4, nil, nil, nil, nil)
+
+		// --- Execute ---
+		rec := performRequest(t, h.SendNotification, http.MethodPost, "/send", gin.H{
+			"tokens":       []string{},
+			"notification": gin.H{"title": "Hello"},
+		})
+
+		// --- Assert ---
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Tokens list cannot be empty")
+	})
+}
+
+func TestHandler_SendBroadcast(t *testing.T) {
+	t.Run("success - webpush config is forwarded", func(t *testing.T) {
+		// --- Setup ---
+		var message map[string]any
+		service := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+			message = readMessage(t, r)
+			_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/9"}`))
+		})
+		h := NewHandler(service, 4, nil, nil, nil, nil)
+		webpush := gin.H{
+			"headers":      gin.H{"Urgency": "high", "TTL": "600"},
+			"notification": gin.H{"requireInteraction": true, "icon": "/icon.png", "vibrate": []int{100, 50}},
+			"fcm_options":  gin.H{"link": "https://dashboard.example.com"},
+		}
+
+		// --- Execute ---
+		rec := performRequest(t, h.SendBroadcast, http.MethodPost, "/sendBroadcast", gin.H{
+			"condition":    "'ops' in topics",
+			"notification": gin.H{"title": "Deploy finished"},
+			"webpush":      webpush,
+		})
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "'ops' in topics", message["condition"])
+		raw, err := json.Marshal(message["webpush"])
+		require.NoError(t, err)
+		expected, err := json.Marshal(webpush)
+		require.NoError(t, err)
+		assert.JSONEq(t, string(expected), string(raw))
+	})
+
+	t.Run("error - webpush link must be https", func(t *testing.T) {
+		// --- Setup ---
+		h := NewHandler(nil, 4, nil, nil, nil, nil)
+
+		// --- Execute ---
+		rec := performRequest(t, h.SendBroadcast, http.MethodPost, "/sendBroadcast", gin.H{
+			"condition":    "'ops' in topics",
+			"notification": gin.H{"title": "Deploy finished"},
+			"webpush":      gin.H{"fcm_options": gin.H{"link": "http://dashboard.example.com"}},
+		})
+
+		// --- Assert ---
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "must be an absolute HTTPS URL")
+	})
+}
+
+func TestHandler_SendTopic(t *testing.T) {
+	t.Run("success - topic prefix is stripped", func(t *testing.T) {
+		// --- Setup ---
+		var message map[string]any
+		service := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+			message = readMessage(t, r)
+			_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/7"}`))
+		})
+		h := NewHandler(service, 4, nil, nil, nil, nil)
+
+		// --- Execute ---
+		rec := performRequest(t, h.SendTopic, http.MethodPost, "/sendTopic", gin.H{
+			"topic":        "/topics/news",
+			"notification": gin.H{"title": "Hello"},
+			"data":         gin.H{"article": "42"},
+		})
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), `"message_name":"projects/test-project/messages/7"`)
+		assert.Equal(t, "news", message["topic"])
+		assert.Equal(t, map[string]any{"article": "42"}, message["data"])
+	})
+
+	t.Run("error - invalid topic name", func(t *testing.T) {
+		// --- Setup ---
+		h := NewHandler(nil, 4, nil, nil, nil, nil)
+
+		// --- Execute ---
+		rec := performRequest(t, h.SendTopic, http.MethodPost, "/sendTopic", gin.H{
+			"topic":        "'news' in topics",
+			"notification": gin.H{"title": "Hello"},
+		})
+
+		// --- Assert ---
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid topic name")
+	})
+}
+
+func TestHandler_AsyncJobs(t *testing.T) {
+	t.Run("success - async send returns 202 and the job can be polled", func(t *testing.T) {
+		// --- Setup ---
+		gin.SetMode(gin.TestMode)
+		service := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+			if readMessage(t, r)["token"] == "bad" {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error":{"code":404,"status":"NOT_FOUND"}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/1"}`))
+		})
+		jobs := job.NewManager(service, job.Config{Workers: 1, QueueSize: 10, Concurrency: 2, Retention: time.Hour})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		jobs.Start(ctx)
+
+		h := NewHandler(service, 4, jobs, nil, nil, nil)
+		router := gin.New()
+		router.POST("/send", h.SendNotification)
+		router.GET("/jobs/:id", h.GetJob)
+
+		// --- Execute ---
+		body, err := json.Marshal(gin.H{
+			"tokens":       []string{"good", "bad", "good-2"},
+			"notification": gin.H{"title": "Hello"},
+		})
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/send?async=true", bytes.NewReader(body)))
+
+		// --- Assert ---
+		require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+		var accepted struct {
+			JobID  string `json:"job_id"`
+			Status string `json:"status"`
+			Total  int    `json:"total"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &accepted))
+		assert.NotEmpty(t, accepted.JobID)
+		assert.Equal(t, "queued", accepted.Status)
+		assert.Equal(t, 3, accepted.Total)
+		assert.Equal(t, "/jobs/"+accepted.JobID, rec.Header().Get("Location"))
+
+		var status struct {
+			Status       string            `json:"status"`
+			SuccessCount int               `json:"success_count"`
+			FailureCount int               `json:"failure_count"`
+			Results      []fcm.TokenResult `json:"results"`
+			FailedTokens []FailedToken     `json:"failed_tokens"`
+		}
+		require.Eventually(t, func() bool {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+accepted.JobID, nil))
+			require.Equal(t, http.StatusOK, rec.Code)
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+			return status.Status == "completed"
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, 2, status.SuccessCount)
+		assert.Equal(t, 1, status.FailureCount)
+		require.Len(t, status.Results, 3)
+		require.Len(t, status.FailedTokens, 1)
+		assert.Equal(t, "bad", status.FailedTokens[0].Token)
+		assert.Equal(t, fcm.ErrorCodeUnregistered, status.FailedTokens[0].ErrorCode)
+	})
+
+	t.Run("error - unknown job", func(t *testing.T) {
+		// --- Setup ---
+		h := NewHandler(nil, 4, job.NewManager(nil, job.Config{}), nil, nil, nil)
+
+		// --- Execute ---
+		rec := performRequest(t, h.GetJob, http.MethodGet, "/jobs/:id", nil)
+
+		// --- Assert ---
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("error - job of a project the API key may not use", func(t *testing.T) {
+		// --- Setup ---
+		gin.SetMode(gin.TestMode)
+		jobs := job.NewManager(nil, job.Config{QueueSize: 1, Retention: time.Hour})
+		j, err := jobs.Submit("brand-b", []string{"a"}, fcm.Message{}, false, "")
+		require.NoError(t, err)
+		h := NewHandler(nil, 4, jobs, nil, nil, nil)
+		router := gin.New()
+		router.Use(withAPIKey(&auth.Key{Name: "brand-a", Projects: []string{"brand-a"}}))
+		router.GET("/jobs/:id", h.GetJob)
+
+		// --- Execute ---
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+j.ID, nil))
+
+		// --- Assert ---
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("error - invalid async flag", func(t *testing.T) {
+		// --- Setup ---
+		h := NewHandler(nil, 4, nil, nil, nil, nil)
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.POST("/send", h.SendNotification)
+		body := []byte(`{"tokens":["a"],"notification":{"title":"x"}}`)
+
+		// --- Execute ---
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/send?async=maybe", bytes.NewReader(body)))
+
+		// --- Assert ---
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Invalid async query parameter")
+	})
+}
+
+func TestHandler_Schedules(t *testing.T) {
+	newRouter := func() *gin.Engine {
+		gin.SetMode(gin.TestMode)
+		// The scheduler is never started and every send_at is far in the
+		// future, so nothing is sent.
+		h := NewHandler(nil, 4, nil, schedule.NewScheduler(nil, schedule.Config{}), nil, nil)
+		router := gin.New()
+		router.POST("/send", h.SendNotification)
+		router.POST("/sendBroadcast", h.SendBroadcast)
+		router.GET("/schedules", h.ListSchedules)
+		router.DELETE("/schedules/:id", h.CancelSchedule)
+		return router
+	}
+	serve := func(router *gin.Engine, method, path string, body any) *httptest.ResponseRecorder {
+		var raw []byte
+		if body != nil {
+			var err error
+			raw, err = json.Marshal(body)
+			require.NoError(t, err)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(raw)))
+		return rec
+	}
+
+	t.Run("success - schedules a send in a time zone, lists and cancels it", func(t *testing.T) {
+		// --- Setup ---
+		router := newRouter()
+
+		// --- Execute ---
+		rec := serve(router, http.MethodPost, "/send", gin.H{
+			"tokens":       []string{"a", "b"},
+			"notification": gin.H{"title": "Promo"},
+			"send_at":      "2099-01-01T09:00:00",
+			"time_zone":    "Asia/Jakarta",
+		})
+
+		// --- Assert ---
+		require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+		var created schedule.Schedule
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+		assert.NotEmpty(t, created.ID)
+		assert.Equal(t, []string{"a", "b"}, created.Tokens)
+		assert.True(t, created.SendAt.Equal(time.Date(2099, 1, 1, 2, 0, 0, 0, time.UTC)))
+		assert.Contains(t, rec.Body.String(), `"send_at":"2099-01-01T09:00:00+07:00"`)
+
+		rec = serve(router, http.MethodGet, "/schedules", nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var list struct {
+			Schedules []schedule.Schedule `json:"schedules"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+		require.Len(t, list.Schedules, 1)
+		assert.Equal(t, created.ID, list.Schedules[0].ID)
+
+		rec = serve(router, http.MethodDelete, "/schedules/"+created.ID, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		rec = serve(router, http.MethodDelete, "/schedules/"+created.ID, nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		rec = serve(router, http.MethodGet, "/schedules", nil)
+		assert.JSONEq(t, `{"schedules":[]}`, rec.Body.String())
+	})
+
+	t.Run("success - schedules a broadcast with a UTC offset", func(t *testing.T) {
+		// --- Setup ---
+		router := newRouter()
+
+		// --- Execute ---
+		rec := serve(router, http.MethodPost, "/sendBroadcast", gin.H{
+			"condition":    "'news' in topics",
+			"notification": gin.H{"title": "Promo"},
+			"send_at":      "2099-06-01T08:30:00-04:00",
+			"dry_run":      true,
+		})
+
+		// --- Assert ---
+		require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+		var created schedule.Schedule
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+		assert.Equal(t, "'news' in topics", created.Condition)
+		assert.True(t, created.DryRun)
+		assert.True(t, created.SendAt.Equal(time.Date(2099, 6, 1, 12, 30, 0, 0, time.UTC)))
+	})
+
+	t.Run("success - hides schedules of projects the API key may not use", func(t *testing.T) {
+		// --- Setup ---
+		gin.SetMode(gin.TestMode)
+		scheduler := schedule.NewScheduler(nil, schedule.Config{})
+		sendAt := time.Now().Add(time.Hour)
+		own, err := scheduler.Add(schedule.Schedule{Project: "brand-a", Tokens: []string{"a"}, SendAt: sendAt})
+		require.NoError(t, err)
+		other, err := scheduler.Add(schedule.Schedule{Project: "brand-b", Tokens: []string{"b"}, SendAt: sendAt})
+		require.NoError(t, err)
+		h := NewHandler(nil, 4, nil, scheduler, nil, nil)
+		router := gin.New()
+		router.Use(withAPIKey(&auth.Key{Name: "brand-a", Projects: []string{"brand-a"}}))
+		router.GET("/schedules", h.ListSchedules)
+		router.DELETE("/schedules/:id", h.CancelSchedule)
+
+		// --- Execute ---
+		listRec := serve(router, http.MethodGet, "/schedules", nil)
+		cancelRec := serve(router, http.MethodDelete, "/schedules/"+other.ID, nil)
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, listRec.Code)
+		var list struct {
+			Schedules []schedule.Schedule `json:"schedules"`
+		}
+		require.NoError(t, json.Unmarshal(listRec.Body.Bytes(), &list))
+		require.Len(t, list.Schedules, 1)
+		assert.Equal(t, own.ID, list.Schedules[0].ID)
+		assert.Equal(t, http.StatusNotFound, cancelRec.Code)
+		_, pending := scheduler.Get(other.ID)
+		assert.True(t, pending)
+	})
+
+	tests := []struct {
+		name    string
+		body    gin.H
+		wantErr string
+	}{
+		{"error - send_at in the past", gin.H{"send_at": "2001-01-01T00:00:00Z"}, "send_at must be in the future"},
+		{"error - malformed send_at", gin.H{"send_at": "tomorrow"}, "send_at must be an RFC 3339 timestamp"},
+		{"error - unknown time zone", gin.H{"send_at": "2099-01-01T09:00:00", "time_zone": "Mars/Olympus"}, "unknown time_zone"},
+		{"error - time zone with offset", gin.H{"send_at": "2099-01-01T09:00:00Z", "time_zone": "Asia/Jakarta"}, "time_zone cannot be combined"},
+		{"error - time zone without send_at", gin.H{"time_zone": "Asia/Jakarta"}, "time_zone requires send_at"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Setup ---
+			router := newRouter()
+			body := gin.H{"tokens": []string{"a"}, "notification": gin.H{"title": "x"}}
+			for k, v := range tt.body {
+				body[k] = v
+			}
+
+			// --- Execute ---
+			rec := serve(router, http.MethodPost, "/send", body)
+
+			// --- Assert ---
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.wantErr)
+		})
+	}
+}
+
+func TestHandler_Callbacks(t *testing.T) {
+	// newCallbackServer records every callback it receives.
+	newCallbackServer := func(t *testing.T) (*httptest.Server, chan webhook.Event) {
+		events := make(chan webhook.Event, 10)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			sig := r.Header.Get(webhook.SignatureHeader)
+			ts := strings.TrimPrefix(strings.Split(sig, ",")[0], "t=")
+			unix, err := strconv.ParseInt(ts, 10, 64)
+			require.NoError(t, err)
+			assert.Equal(t, webhook.Sign("secret", time.Unix(unix, 0), body), sig)
+
+			var ev webhook.Event
+			require.NoError(t, json.Unmarshal(body, &ev))
+			events <- ev
+		}))
+		t.Cleanup(server.Close)
+		return server, events
+	}
+	waitEvent := func(t *testing.T, events chan webhook.Event) webhook.Event {
+		t.Helper()
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("no callback received")
+			return webhook.Event{}
+		}
+	}
+	// The callback servers listen on http://127.0.0.1.
+	notifier := webhook.NewNotifier(webhook.Config{Secret: "secret", AllowHTTP: true, AllowPrivateNetworks: true})
+
+	t.Run("success - /send reports per-token results to callback_url", func(t *testing.T) {
+		// --- Setup ---
+		callbacks, events := newCallbackServer(t)
+		service := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+			if readMessage(t, r)["token"] == "bad" {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error":{"code":404,"status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/1"}`))
+		})
+		h := NewHandler(service, 4, nil, nil, notifier, nil)
+
+		// --- Execute ---
+		rec := performRequest(t, h.SendNotification, http.MethodPost, "/send", gin.H{
+			"tokens":       []string{"good", "bad"},
+			"notification": gin.H{"title": "Hello"},
+			"callback_url": callbacks.URL,
+		})
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		ev := waitEvent(t, events)
+		assert.Equal(t, webhook.TypeSendCompleted, ev.Type)
+		assert.Equal(t, 1, ev.SuccessCount)
+		assert.Equal(t, 1, ev.FailureCount)
+		require.Len(t, ev.Results, 2)
+		assert.Equal(t, "projects/test-project/messages/1", ev.Results[0].MessageName)
+		assert.Equal(t, fcm.ErrorCodeUnregistered, ev.Results[1].ErrorCode)
+	})
+
+	t.Run("success - /sendTopic reports the message name", func(t *testing.T) {
+		// --- Setup ---
+		callbacks, events := newCallbackServer(t)
+		service := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/7"}`))
+		})
+		h := NewHandler(service, 4, nil, nil, notifier, nil)
+
+		// --- Execute ---
+		rec := performRequest(t, h.SendTopic, http.MethodPost, "/sendTopic", gin.H{
+			"topic":        "news",
+			"notification": gin.H{"title": "Hello"},
+			"callback_url": callbacks.URL,
+		})
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		ev := waitEvent(t, events)
+		assert.Equal(t, webhook.TypeTopicCompleted, ev.Type)
+		assert.Equal(t, "news", ev.Topic)
+		assert.Equal(t, "projects/test-project/messages/7", ev.MessageName)
+	})
+
+	t.Run("success - async job reports once finished", func(t *testing.T) {
+		// --- Setup ---
+		callbacks, events := newCallbackServer(t)
+		service := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/1"}`))
+		})
+		jobs := job.NewManager(service, job.Config{Workers: 1, QueueSize: 10, OnFinish: notifier.JobFinished})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		jobs.Start(ctx)
+		h := NewHandler(service, 4, jobs, nil, notifier, nil)
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.POST("/send", h.SendNotification)
+		body, err := json.Marshal(gin.H{"tokens": []string{"a"}, "data": gin.H{"k": "v"}, "callback_url": callbacks.URL})
+		require.NoError(t, err)
+
+		// --- Execute ---
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/send?async=true", bytes.NewReader(body)))
+
+		// --- Assert ---
+		require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+		ev := waitEvent(t, events)
+		assert.Equal(t, webhook.TypeJobCompleted, ev.Type)
+		assert.NotEmpty(t, ev.JobID)
+		assert.Equal(t, 1, ev.SuccessCount)
+	})
+
+	t.Run("error - callback_url without webhooks configured", func(t *testing.T) {
+		h := NewHandler(nil, 4, nil, nil, nil, nil)
+
+		rec := performRequest(t, h.SendBroadcast, http.MethodPost, "/sendBroadcast", gin.H{
+			"condition":    "'news' in topics",
+			"notification": gin.H{"title": "Hello"},
+			"callback_url": "https://example.com/hook",
+		})
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "webhooks.secret")
+	})
+
+	t.Run("error - callback_url is not an absolute URL", func(t *testing.T) {
+		h := NewHandler(nil, 4, nil, nil, notifier, nil)
+
+		rec := performRequest(t, h.SendNotification, http.MethodPost, "/send", gin.H{
+			"tokens":       []string{"a"},
+			"notification": gin.H{"title": "Hello"},
+			"callback_url": "/relative",
+		})
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "callback_url must be an absolute http or https URL")
+	})
+
+	t.Run("error - callback_url points to a non-public address", func(t *testing.T) {
+		h := NewHandler(nil, 4, nil, nil, webhook.NewNotifier(webhook.Config{Secret: "secret"}), nil)
+
+		rec := performRequest(t, h.SendNotification, http.MethodPost, "/send", gin.H{
+			"tokens":       []string{"a"},
+			"notification": gin.H{"title": "Hello"},
+			"callback_url": "https://169.254.169.254/latest/meta-data",
+		})
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "169.254.169.254 is not a public address")
+	})
+}
+
+func TestHandler_TopicSubscriptions(t *testing.T) {
+	newRouter := func(t *testing.T, iidHandler http.HandlerFunc) *gin.Engine {
+		t.Helper()
+		iidServer := httptest.NewServer(iidHandler)
+		t.Cleanup(iidServer.Close)
+		service := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+			t.Error("unexpected FCM send")
+		}, fcm.WithIIDURL(iidServer.URL))
+		h := NewHandler(service, 4, nil, nil, nil, nil)
+
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.POST("/topics/:topic/subscribe", h.SubscribeTopic)
+		router.POST("/topics/:topic/unsubscribe", h.UnsubscribeTopic)
+		return router
+	}
+
+	t.Run("success - subscribes tokens and reports per-token errors", func(t *testing.T) {
+		// --- Setup ---
+		var gotPath, gotTo string
+		router := newRouter(t, func(w http.ResponseWriter, r *http.Request) {
+			gotPath = r.URL.Path
+			var req struct {
+				To string `json:"to"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			gotTo = req.To
+			_, _ = w.Write([]byte(`{"results":[{},{"error":"NOT_FOUND"}]}`))
+		})
+
+		// --- Execute ---
+		rec := serveJSON(t, router, http.MethodPost, "/topics/news/subscribe", gin.H{"tokens": []string{"good", "stale"}})
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "/iid/v1:batchAdd", gotPath)
+		assert.Equal(t, "/topics/news", gotTo)
+		assert.JSONEq(t, `{
+			"topic": "news",
+			"success_count": 1,
+			"failure_count": 1,
+			"results": [
+				{"token": "good"},
+				{"token": "stale", "error": "NOT_FOUND", "error_code": "NOT_FOUND"}
+			]
+		}`, rec.Body.String())
+	})
+
+	t.Run("success - unsubscribes tokens", func(t *testing.T) {
+		var gotPath string
+		router := newRouter(t, func(w http.ResponseWriter, r *http.Request) {
+			gotPath = r.URL.Path
+			_, _ = w.Write([]byte(`{"results":[{}]}`))
+		})
+
+		rec := serveJSON(t, router, http.MethodPost, "/topics/news/unsubscribe", gin.H{"tokens": []string{"good"}})
+
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "/iid/v1:batchRemove", gotPath)
+	})
+
+	tests := []struct {
+		name    string
+		path    string
+		body    gin.H
+		wantErr string
+	}{
+		{"error - invalid topic", "/topics/news!/subscribe", gin.H{"tokens": []string{"a"}}, "invalid topic name"},
+		{"error - missing tokens", "/topics/news/subscribe", gin.H{}, "Invalid Request Body"},
+		{"error - empty tokens", "/topics/news/subscribe", gin.H{"tokens": []string{}}, "tokens list cannot be empty"},
+		{"error - empty token", "/topics/news/subscribe", gin.H{"tokens": []string{"a", ""}}, "tokens cannot contain empty tokens"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newRouter(t, func(w http.ResponseWriter, r *http.Request) {
+				t.Error("unexpected Instance ID call")
+			})
+
+			rec := serveJSON(t, router, http.MethodPost, tt.path, tt.body)
+
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.wantErr)
+		})
+	}
+}
+
+// withAPIKey stands in for AuthMiddleware and authenticates every request as
+// key.
+func withAPIKey(key *auth.Key) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(apiKeyKey, key)
+		c.Next()
+	}
+}
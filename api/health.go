@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Health answers liveness and readiness probes. Once Drain is called the
+// gateway reports itself not ready and refuses new requests, so load
+// balancers stop sending traffic while in-flight requests finish.
+type Health struct {
+	draining atomic.Bool
+}
+
+func NewHealth() *Health {
+	return &Health{}
+}
+
+// Drain flips readiness to not ready. It cannot be undone.
+func (h *Health) Drain() {
+	h.draining.Store(true)
+}
+
+func (h *Health) Draining() bool {
+	return h.draining.Load()
+}
+
+// Live answers 200 for as long as the process serves HTTP.
+func (h *Health) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Ready answers 200, or 503 once the gateway is draining.
+func (h *Health) Ready(c *gin.Context) {
+	if h.Draining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
+
+// RefuseWhileDraining answers 503 to every request once the gateway is
+// draining, and asks the client to close the connection so its retry
+// reaches another instance.
+func (h *Health) RefuseWhileDraining() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h.Draining() {
+			c.Header("Connection", "close")
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Server is shutting down, try again later"})
+			return
+		}
+		c.Next()
+	}
+}
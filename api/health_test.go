@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	t.Run("success - readiness flips and requests are refused once draining", func(t *testing.T) {
+		// --- Setup ---
+		health := NewHealth()
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.GET("/healthz", health.Live)
+		router.GET("/readyz", health.Ready)
+		router.Use(health.RefuseWhileDraining())
+		router.POST("/send", func(c *gin.Context) { c.Status(http.StatusOK) })
+		serve := func(method, path string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+			return rec
+		}
+
+		// --- Execute ---
+		readyBefore := serve(http.MethodGet, "/readyz")
+		sendBefore := serve(http.MethodPost, "/send")
+		health.Drain()
+		readyAfter := serve(http.MethodGet, "/readyz")
+		liveAfter := serve(http.MethodGet, "/healthz")
+		sendAfter := serve(http.MethodPost, "/send")
+
+		// --- Assert ---
+		assert.Equal(t, http.StatusOK, readyBefore.Code)
+		assert.Equal(t, http.StatusOK, sendBefore.Code)
+		assert.Equal(t, http.StatusServiceUnavailable, readyAfter.Code)
+		assert.JSONEq(t, `{"status":"draining"}`, readyAfter.Body.String())
+		assert.Equal(t, http.StatusOK, liveAfter.Code)
+		assert.Equal(t, http.StatusServiceUnavailable, sendAfter.Code)
+		assert.Equal(t, "close", sendAfter.Header().Get("Connection"))
+		assert.Equal(t, "1", sendAfter.Header().Get("Retry-After"))
+	})
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wirsal/fcm-gateway/internal/idempotency"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+// replayedHeaders are the response headers stored along with the body.
+var replayedHeaders = []string{"Content-Type", "Location"}
+
+// IdempotencyMiddleware answers a request carrying an Idempotency-Key that
+// was already handled with the stored response instead of running the
+// handler again. Only successful (2xx) responses are stored; after any other
+// response the key can be retried.
+func IdempotencyMiddleware(store *idempotency.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			return
+		}
+		// Keys of different API keys never collide.
+		if apiKey := apiKeyOf(c); apiKey != nil {
+			key = apiKey.Name + "\x00" + key
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		state, stored := store.Begin(key, fingerprint(c.Request, projectOf(c), body))
+		switch state {
+		case idempotency.StateMismatch:
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+			return
+		case idempotency.StateInProgress:
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still being processed"})
+			return
+		case idempotency.StateDone:
+			for name, values := range stored.Header {
+				c.Writer.Header()[name] = values
+			}
+			c.Header(idempotentReplayedHeader, "true")
+			c.Status(stored.Status)
+			_, _ = c.Writer.Write(stored.Body)
+			c.Abort()
+			return
+		}
+
+		rec := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = rec
+		defer func() {
+			// Nothing was written if the handler panicked.
+			status := rec.Status()
+			if !rec.Written() || status < 200 || status > 299 {
+				store.Release(key)
+				return
+			}
+			header := make(http.Header)
+			for _, name := range replayedHeaders {
+				if v := rec.Header().Values(name); len(v) > 0 {
+					header[name] = v
+				}
+			}
+			store.Complete(key, idempotency.Response{Status: status, Header: header, Body: rec.body.Bytes()})
+		}()
+		c.Next()
+	}
+}
+
+// fingerprint identifies a request by its method, path, project and body.
+func fingerprint(r *http.Request, project string, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+" "+project+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordingWriter keeps a copy of the response body.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
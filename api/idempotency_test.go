@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wirsal/fcm-gateway/internal/idempotency"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	// newRouter serves /send behind the middleware and counts FCM calls.
+	newRouter := func(t *testing.T, fcmHandler http.HandlerFunc) (*gin.Engine, *atomic.Int32) {
+		gin.SetMode(gin.TestMode)
+		var calls atomic.Int32
+		service := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			fcmHandler(w, r)
+		})
+		h := NewHandler(service, 4, nil, nil, nil, nil)
+		router := gin.New()
+		router.POST("/send", IdempotencyMiddleware(idempotency.NewStore(time.Hour, 0)), h.SendNotification)
+		return router, &calls
+	}
+	send := func(router *gin.Engine, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/send", bytes.NewReader([]byte(body)))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/1"}`))
+	}
+	body := `{"tokens":["a"],"notification":{"title":"Hello"}}`
+
+	t.Run("success - repeat with the same key and body is replayed", func(t *testing.T) {
+		// --- Setup ---
+		router, calls := newRouter(t, ok)
+
+		// --- Execute ---
+		first := send(router, "key-1", body)
+		second := send(router, "key-1", body)
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, first.Code, first.Body.String())
+		assert.Equal(t, http.StatusOK, second.Code)
+		assert.Equal(t, first.Body.String(), second.Body.String())
+		assert.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+		assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("success - requests without a key are not deduplicated", func(t *testing.T) {
+		router, calls := newRouter(t, ok)
+
+		send(router, "", body)
+		send(router, "", body)
+
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("success - failed requests are not stored", func(t *testing.T) {
+		// --- Setup ---
+		router, _ := newRouter(t, ok)
+		invalid := `{"tokens":[],"notification":{"title":"Hello"}}`
+
+		// --- Execute ---
+		first := send(router, "key-1", invalid)
+		second := send(router, "key-1", invalid)
+		third := send(router, "key-1", body)
+
+		// --- Assert ---
+		assert.Equal(t, http.StatusBadRequest, first.Code)
+		assert.Equal(t, http.StatusBadRequest, second.Code)
+		assert.Empty(t, second.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, http.StatusOK, third.Code)
+	})
+
+	t.Run("error - same key with a different body", func(t *testing.T) {
+		// --- Setup ---
+		router, calls := newRouter(t, ok)
+		send(router, "key-1", body)
+
+		// --- Execute ---
+		rec := send(router, "key-1", `{"tokens":["b"],"notification":{"title":"Hello"}}`)
+
+		// --- Assert ---
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("error - same key while the first request is still running", func(t *testing.T) {
+		// --- Setup ---
+		release := make(chan struct{})
+		router, calls := newRouter(t, func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			ok(w, r)
+		})
+		firstDone := make(chan *httptest.ResponseRecorder)
+		go func() { firstDone <- send(router, "key-1", body) }()
+		require.Eventually(t, func() bool { return calls.Load() == 1 }, 2*time.Second, 5*time.Millisecond)
+
+		// --- Execute ---
+		rec := send(router, "key-1", body)
+		close(release)
+		first := <-firstDone
+
+		// --- Assert ---
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, http.StatusOK, first.Code)
+	})
+
+	t.Run("error - key too long", func(t *testing.T) {
+		router, calls := newRouter(t, ok)
+
+		rec := send(router, string(bytes.Repeat([]byte("k"), 256)), body)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, int32(0), calls.Load())
+	})
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wirsal/fcm-gateway/internal/logging"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestIDMiddleware())
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, logging.RequestID(c.Request.Context()))
+	})
+	serve := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if id != "" {
+			req.Header.Set(RequestIDHeader, id)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("success - generates an ID when none is sent", func(t *testing.T) {
+		rec := serve("")
+
+		id := rec.Header().Get(RequestIDHeader)
+		assert.Len(t, id, 32)
+		assert.Equal(t, id, rec.Body.String())
+	})
+
+	t.Run("success - keeps the caller's ID", func(t *testing.T) {
+		rec := serve("abc-123")
+
+		assert.Equal(t, "abc-123", rec.Header().Get(RequestIDHeader))
+		assert.Equal(t, "abc-123", rec.Body.String())
+	})
+
+	t.Run("success - replaces an ID that is not printable ASCII", func(t *testing.T) {
+		for _, id := range []string{"two words", "line\nbreak", strings.Repeat("x", 129)} {
+			rec := serve(id)
+
+			assert.NotEqual(t, id, rec.Header().Get(RequestIDHeader))
+			assert.Len(t, rec.Header().Get(RequestIDHeader), 32)
+		}
+	})
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	t.Run("success - logs the route and request ID instead of the path", func(t *testing.T) {
+		// --- Setup ---
+		var buf bytes.Buffer
+		logger, err := logging.New(&buf, logging.Config{Format: logging.FormatJSON})
+		require.NoError(t, err)
+
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.Use(RequestIDMiddleware(), AccessLogMiddleware(logger))
+		router.GET("/tokens/:token", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+
+		// --- Execute ---
+		req := httptest.NewRequest(http.MethodGet, "/tokens/secret-device-token", nil)
+		req.Header.Set(RequestIDHeader, "req-1")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		// --- Assert ---
+		assert.NotContains(t, buf.String(), "secret-device-token")
+		var line map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+		assert.Equal(t, "HTTP request", line["msg"])
+		assert.Equal(t, "WARN", line["level"])
+		assert.Equal(t, "req-1", line["request_id"])
+		assert.Equal(t, "/tokens/:token", line["route"])
+		assert.Equal(t, "GET", line["method"])
+		assert.EqualValues(t, http.StatusNotFound, line["status"])
+	})
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	t.Run("success - answers 500 and logs the panic", func(t *testing.T) {
+		// --- Setup ---
+		var buf bytes.Buffer
+		logger, err := logging.New(&buf, logging.Config{Format: logging.FormatJSON})
+		require.NoError(t, err)
+
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.Use(RequestIDMiddleware(), RecoveryMiddleware(logger))
+		router.GET("/", func(c *gin.Context) { panic("boom") })
+
+		// --- Execute ---
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		// --- Assert ---
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		var line map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+		assert.Equal(t, "Handler panicked", line["msg"])
+		assert.Equal(t, "boom", line["panic"])
+		assert.Equal(t, rec.Header().Get(RequestIDHeader), line["request_id"])
+	})
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wirsal/fcm-gateway/internal/metrics"
+)
+
+// MetricsMiddleware records the duration and status of every request by its
+// route pattern. Requests matching no route are recorded as "unmatched".
+func MetricsMiddleware(m *metrics.Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		m.ObserveHTTP(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+	}
+}
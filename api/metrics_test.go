@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wirsal/fcm-gateway/fcm"
+	"github.com/wirsal/fcm-gateway/internal/metrics"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	t.Run("success - exports requests and sends by route and outcome", func(t *testing.T) {
+		// --- Setup ---
+		m := metrics.New()
+		service := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+			if readMessage(t, r)["token"] == "gone" {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error":{"code":404,"status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/1"}`))
+		}, fcm.WithObserver(m))
+		m.WatchProjects(fcm.SingleProject("default", service))
+		h := NewHandler(service, 2, nil, nil, nil, nil)
+
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.Use(MetricsMiddleware(m))
+		router.POST("/send", h.SendNotification)
+		router.GET("/jobs/:id", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+		router.GET("/metrics", gin.WrapH(m.Handler()))
+
+		// --- Execute ---
+		send := serveJSON(t, router, http.MethodPost, "/send", gin.H{"tokens": []string{"ok", "gone"}, "notification": gin.H{"title": "Hi"}})
+		serveJSON(t, router, http.MethodGet, "/jobs/abc", nil)
+		serveJSON(t, router, http.MethodGet, "/nowhere", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, send.Code, send.Body.String())
+		body := rec.Body.String()
+		for _, line := range []string{
+			`fcm_gateway_messages_total{error_code="",outcome="success",project="test-project",target="token"} 1`,
+			`fcm_gateway_messages_total{error_code="UNREGISTERED",outcome="failure",project="test-project",target="token"} 1`,
+			`fcm_gateway_fcm_request_duration_seconds_count{api="messages",project="test-project",status="200"} 1`,
+			`fcm_gateway_oauth_token_refreshes_total{outcome="success",project="test-project"} 1`,
+			`fcm_gateway_fcm_in_flight_messages{project="test-project"} 0`,
+			`fcm_gateway_http_request_duration_seconds_count{method="POST",route="/send",status="200"} 1`,
+			`fcm_gateway_http_request_duration_seconds_count{method="GET",route="/jobs/:id",status="404"} 1`,
+			`fcm_gateway_http_request_duration_seconds_count{method="GET",route="unmatched",status="404"} 1`,
+		} {
+			assert.Contains(t, body, line)
+		}
+	})
+}
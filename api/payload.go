@@ -1,18 +1,138 @@
 package api
 
-import "github.com/wirsal/fcm-gateway/fcm"
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/wirsal/fcm-gateway/fcm"
+)
+
+// MessagePayload is the message content and options shared by every send
+// endpoint.
+type MessagePayload struct {
+	// Notification may be left out for data-only (silent) messages, but at
+	// least one of Notification and Data must be set.
+	Notification *fcm.Notification  `json:"notification,omitempty"`
+	Data         map[string]string  `json:"data,omitempty"`
+	Android      *fcm.AndroidConfig `json:"android,omitempty"`
+	Apns         *fcm.ApnsConfig    `json:"apns,omitempty"`
+	Webpush      *fcm.WebpushConfig `json:"webpush,omitempty"`
+	// DryRun asks FCM to validate the message and targets without
+	// delivering anything.
+	DryRun bool `json:"dry_run,omitempty"`
+	// CallbackURL receives the signed results once the send finished. It
+	// overrides the configured default.
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+func (p *MessagePayload) validate() error {
+	if p.Notification == nil && len(p.Data) == 0 {
+		return errors.New("either notification or data must be set")
+	}
+	if err := fcm.ValidateData(p.Data); err != nil {
+		return err
+	}
+	if p.Android != nil {
+		if err := p.Android.Validate(); err != nil {
+			return err
+		}
+	}
+	if p.Apns != nil {
+		if err := p.Apns.Validate(); err != nil {
+			return err
+		}
+	}
+	if p.Webpush != nil {
+		if err := p.Webpush.Validate(); err != nil {
+			return err
+		}
+	}
+	if p.CallbackURL != "" {
+		u, err := url.Parse(p.CallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("callback_url must be an absolute http or https URL: %q", p.CallbackURL)
+		}
+	}
+	return nil
+}
+
+func (p *MessagePayload) message() fcm.Message {
+	return fcm.Message{
+		Notification: p.Notification,
+		Data:         p.Data,
+		Android:      p.Android,
+		Apns:         p.Apns,
+		Webpush:      p.Webpush,
+	}
+}
+
+// SchedulePayload delays a send until SendAt.
+type SchedulePayload struct {
+	// SendAt is an RFC 3339 timestamp. It may leave out the UTC offset, in
+	// which case it is read in TimeZone.
+	SendAt string `json:"send_at,omitempty"`
+	// TimeZone is an IANA time zone name such as "Asia/Jakarta". It defaults
+	// to UTC.
+	TimeZone string `json:"time_zone,omitempty"`
+}
+
+// sendAt returns when the send is due. scheduled is false when it should
+// go out right away.
+func (p *SchedulePayload) sendAt() (t time.Time, scheduled bool, err error) {
+	if p.SendAt == "" {
+		if p.TimeZone != "" {
+			return time.Time{}, false, errors.New("time_zone requires send_at")
+		}
+		return time.Time{}, false, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, p.SendAt); err == nil {
+		if p.TimeZone != "" {
+			return time.Time{}, false, errors.New("time_zone cannot be combined with a send_at that has a UTC offset")
+		}
+		return t, true, nil
+	}
+
+	loc := time.UTC
+	if p.TimeZone != "" {
+		if loc, err = time.LoadLocation(p.TimeZone); err != nil {
+			return time.Time{}, false, fmt.Errorf("unknown time_zone %q", p.TimeZone)
+		}
+	}
+	t, err = time.ParseInLocation("2006-01-02T15:04:05", p.SendAt, loc)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("send_at must be an RFC 3339 timestamp: %q", p.SendAt)
+	}
+	return t, true, nil
+}
 
 type BroadcastPayload struct {
-	Condition    string            `json:"condition" binding:"required"`
-	Notification fcm.Notification  `json:"notification" binding:"required"`
-	Data         map[string]string `json:"data,omitempty"`
-	Android      fcm.AndroidConfig `json:"android,omitempty"`
-	Apns         fcm.ApnsConfig    `json:"apns,omitempty"`
+	Condition string `json:"condition" binding:"required"`
+	MessagePayload
+	SchedulePayload
+}
+
+type TopicPayload struct {
+	// Topic is the topic name, with or without the "/topics/" prefix.
+	Topic string `json:"topic" binding:"required"`
+	MessagePayload
+}
+
+// SubscriptionPayload is the body of the topic subscribe and unsubscribe
+// endpoints.
+type SubscriptionPayload struct {
+	Tokens []string `json:"tokens" binding:"required"`
+}
+
+type UsersPayload struct {
+	UserIDs []string `json:"user_ids" binding:"required"`
+	MessagePayload
 }
 
 type RequestPayload struct {
-	Tokens       []string          `json:"tokens" binding:"required"`
-	Notification fcm.Notification  `json:"notification" binding:"required"`
-	Android      fcm.AndroidConfig `json:"android,omitempty"`
-	Apns         fcm.ApnsConfig    `json:"apns,omitempty"`
+	Tokens []string `json:"tokens" binding:"required"`
+	MessagePayload
+	SchedulePayload
 }
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wirsal/fcm-gateway/fcm"
+)
+
+// ProjectHeader names the Firebase project a request is sent through.
+const ProjectHeader = "X-Firebase-Project"
+
+// Keys ProjectMiddleware stores the picked project under in the gin context.
+const (
+	projectNameKey    = "fcm_project"
+	projectServiceKey = "fcm_service"
+)
+
+// ProjectMiddleware picks the Firebase project each request is sent through:
+// the :project path segment, else the X-Firebase-Project header, else the
+// default project of the caller's API key, else the default project.
+// Projects the API key is not allowed to use get 403.
+func ProjectMiddleware(projects *fcm.Projects) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("project")
+		if name == "" {
+			name = c.GetHeader(ProjectHeader)
+		}
+		key := apiKeyOf(c)
+		if name == "" && key != nil {
+			name = key.DefaultProject
+		}
+
+		resolved, service, err := projects.Resolve(name)
+		if errors.Is(err, fcm.ErrNoProject) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "No project selected: use /projects/{project}/... or the " + ProjectHeader + " header"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Project not found", "project": name})
+			return
+		}
+
+		if key != nil && !key.AllowsProject(resolved) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key " + key.Name + " may not use project " + resolved})
+			return
+		}
+
+		c.Set(projectNameKey, resolved)
+		c.Set(projectServiceKey, service)
+		c.Next()
+	}
+}
+
+// projectOf returns the project ProjectMiddleware picked for c, or "" for the
+// default project of handlers used without it.
+func projectOf(c *gin.Context) string {
+	return c.GetString(projectNameKey)
+}
+
+// allowsProject reports whether the caller's API key, if any, may use
+// project.
+func allowsProject(c *gin.Context, project string) bool {
+	key := apiKeyOf(c)
+	return key == nil || key.AllowsProject(project)
+}
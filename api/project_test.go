@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wirsal/fcm-gateway/fcm"
+	"github.com/wirsal/fcm-gateway/internal/schedule"
+)
+
+func TestProjectMiddleware(t *testing.T) {
+	// newRouter serves /send for two projects, brand-a being the default
+	// when defaultProject is set, and records which one each send went to.
+	newRouter := func(t *testing.T, defaultProject string) (*gin.Engine, *[]string, *schedule.Scheduler) {
+		t.Helper()
+		var sentBy []string
+		newProject := func(name string) *fcm.Service {
+			return newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+				sentBy = append(sentBy, name)
+				_, _ = w.Write([]byte(`{"name":"projects/` + name + `/messages/1"}`))
+			})
+		}
+		projects, err := fcm.NewProjects(defaultProject, map[string]*fcm.Service{
+			"brand-a": newProject("brand-a"),
+			"brand-b": newProject("brand-b"),
+		})
+		require.NoError(t, err)
+		schedules := schedule.NewScheduler(projects, schedule.Config{})
+		h := NewHandler(nil, 1, nil, schedules, nil, nil)
+
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		selected := ProjectMiddleware(projects)
+		router.POST("/send", selected, h.SendNotification)
+		router.POST("/projects/:project/send", selected, h.SendNotification)
+		return router, &sentBy, schedules
+	}
+	send := func(router http.Handler, path, header string, body gin.H) *httptest.ResponseRecorder {
+		raw, err := json.Marshal(body)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		if header != "" {
+			req.Header.Set(ProjectHeader, header)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	message := gin.H{"tokens": []string{"t"}, "notification": gin.H{"title": "Hello"}}
+
+	t.Run("success - picks the project from the path, the header or the default", func(t *testing.T) {
+		// --- Setup ---
+		router, sentBy, _ := newRouter(t, "brand-a")
+
+		// --- Execute ---
+		byPath := send(router, "/projects/brand-b/send", "", message)
+		byHeader := send(router, "/send", "Brand-B", message)
+		byDefault := send(router, "/send", "", message)
+		pathWins := send(router, "/projects/brand-a/send", "brand-b", message)
+
+		// --- Assert ---
+		for _, rec := range []*httptest.ResponseRecorder{byPath, byHeader, byDefault, pathWins} {
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		}
+		assert.Equal(t, []string{"brand-b", "brand-b", "brand-a", "brand-a"}, *sentBy)
+	})
+
+	t.Run("success - schedules keep the project they were created for", func(t *testing.T) {
+		router, _, schedules := newRouter(t, "brand-a")
+		body := gin.H{"tokens": []string{"t"}, "notification": gin.H{"title": "Hello"}, "send_at": "2999-01-01T00:00:00Z"}
+
+		rec := send(router, "/projects/brand-b/send", "", body)
+
+		require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+		require.Len(t, schedules.List(), 1)
+		assert.Equal(t, "brand-b", schedules.List()[0].Project)
+	})
+
+	t.Run("error - unknown project", func(t *testing.T) {
+		router, sentBy, _ := newRouter(t, "brand-a")
+
+		byPath := send(router, "/projects/brand-c/send", "", message)
+		byHeader := send(router, "/send", "brand-c", message)
+
+		assert.Equal(t, http.StatusNotFound, byPath.Code)
+		assert.Equal(t, http.StatusNotFound, byHeader.Code)
+		assert.Contains(t, byHeader.Body.String(), "Project not found")
+		assert.Empty(t, *sentBy)
+	})
+
+	t.Run("error - no project selected and no default", func(t *testing.T) {
+		router, sentBy, _ := newRouter(t, "")
+
+		rec := send(router, "/send", "", message)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "No project selected")
+		assert.Empty(t, *sentBy)
+	})
+}
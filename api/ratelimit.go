@@ -0,0 +1,33 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wirsal/fcm-gateway/internal/ratelimit"
+)
+
+// RateLimitMiddleware answers 429 with a Retry-After header once a client
+// has used up its share of limiter. Clients are told apart by their API key
+// and, when authentication is disabled, by their IP address, so it must run
+// after AuthMiddleware. The IP is gin's ClientIP, which only believes
+// X-Forwarded-For from the engine's trusted proxies.
+func RateLimitMiddleware(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client := "ip:" + c.ClientIP()
+		if key := apiKeyOf(c); key != nil {
+			client = "key:" + key.Name
+		}
+
+		ok, retryAfter := limiter.Allow(client)
+		if !ok {
+			seconds := max(1, int(math.Ceil(retryAfter.Seconds())))
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded, retry in " + strconv.Itoa(seconds) + "s"})
+			return
+		}
+		c.Next()
+	}
+}
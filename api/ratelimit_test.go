@@ -0,0 +1,96 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wirsal/fcm-gateway/internal/auth"
+	"github.com/wirsal/fcm-gateway/internal/ratelimit"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	newRouter := func(t *testing.T, middleware ...gin.HandlerFunc) *gin.Engine {
+		t.Helper()
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		handlers := append(middleware, RateLimitMiddleware(ratelimit.New(0.5, 1)), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		router.POST("/send", handlers...)
+		return router
+	}
+	request := func(router http.Handler, remoteAddr, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/send", nil)
+		req.RemoteAddr = remoteAddr
+		if apiKey != "" {
+			req.Header.Set(APIKeyHeader, apiKey)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("success - limits each API key separately", func(t *testing.T) {
+		// --- Setup ---
+		keys, err := auth.NewKeyring([]auth.Key{
+			{Name: "a", Hash: auth.HashKey("a-secret"), Scopes: []string{auth.ScopeSend}},
+			{Name: "b", Hash: auth.HashKey("b-secret"), Scopes: []string{auth.ScopeSend}},
+		})
+		require.NoError(t, err)
+		router := newRouter(t, AuthMiddleware(keys, nil))
+
+		// --- Execute ---
+		first := request(router, "10.0.0.1:1000", "a-secret")
+		limited := request(router, "10.0.0.1:1000", "a-secret")
+		otherKey := request(router, "10.0.0.1:1000", "b-secret")
+
+		// --- Assert ---
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Equal(t, http.StatusTooManyRequests, limited.Code)
+		assert.Equal(t, "2", limited.Header().Get("Retry-After"))
+		assert.Contains(t, limited.Body.String(), "Rate limit exceeded")
+		assert.Equal(t, http.StatusOK, otherKey.Code)
+	})
+
+	t.Run("success - limits by client IP without authentication", func(t *testing.T) {
+		router := newRouter(t)
+
+		first := request(router, "10.0.0.1:1000", "")
+		samePort := request(router, "10.0.0.1:2000", "")
+		otherIP := request(router, "10.0.0.2:1000", "")
+
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Equal(t, http.StatusTooManyRequests, samePort.Code)
+		assert.Equal(t, http.StatusOK, otherIP.Code)
+	})
+
+	t.Run("success - X-Forwarded-For only counts from trusted proxies", func(t *testing.T) {
+		// --- Setup ---
+		router := newRouter(t)
+		require.NoError(t, router.SetTrustedProxies([]string{"10.0.0.100"}))
+		forwarded := func(remoteAddr, clientIP string) int {
+			req := httptest.NewRequest(http.MethodPost, "/send", nil)
+			req.RemoteAddr = remoteAddr
+			req.Header.Set("X-Forwarded-For", clientIP)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		// --- Execute ---
+		direct := forwarded("10.0.0.1:1000", "192.0.2.1")
+		spoofed := forwarded("10.0.0.1:1000", "192.0.2.2")
+		viaProxy := forwarded("10.0.0.100:1000", "192.0.2.3")
+		viaProxyAgain := forwarded("10.0.0.100:1000", "192.0.2.4")
+
+		// --- Assert ---
+		assert.Equal(t, http.StatusOK, direct)
+		assert.Equal(t, http.StatusTooManyRequests, spoofed, "an untrusted peer cannot pick its IP")
+		assert.Equal(t, http.StatusOK, viaProxy)
+		assert.Equal(t, http.StatusOK, viaProxyAgain, "clients behind a trusted proxy are told apart")
+	})
+}
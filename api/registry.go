@@ -0,0 +1,148 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wirsal/fcm-gateway/fcm"
+	"github.com/wirsal/fcm-gateway/internal/registry"
+)
+
+// RegistryHandler serves the device token registry.
+type RegistryHandler struct {
+	registry *registry.Registry
+	projects *fcm.Projects
+}
+
+// NewRegistryHandler returns a handler registering tokens for the projects
+// in projects.
+func NewRegistryHandler(reg *registry.Registry, projects *fcm.Projects) *RegistryHandler {
+	return &RegistryHandler{registry: reg, projects: projects}
+}
+
+type DevicePayload struct {
+	Token      string `json:"token" binding:"required"`
+	UserID     string `json:"user_id" binding:"required"`
+	Platform   string `json:"platform" binding:"required"`
+	AppVersion string `json:"app_version"`
+	// Project the token was issued for; empty means the default project.
+	Project string `json:"project"`
+}
+
+// RegisterToken answers 201 for a new token and 200 when an existing
+// registration was updated.
+func (h *RegistryHandler) RegisterToken(c *gin.Context) {
+	var payload DevicePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body: " + err.Error()})
+		return
+	}
+
+	project, _, err := h.projects.Resolve(payload.Project)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body: " + err.Error()})
+		return
+	}
+	if key := apiKeyOf(c); key != nil && !key.AllowsProject(project) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key " + key.Name + " may not use project " + project})
+		return
+	}
+	// Nor may it move a token away from a project it may not use.
+	if existing, ok, err := h.registry.Get(payload.Token); err == nil && ok && !allowsProject(c, existing.Project) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key " + apiKeyOf(c).Name + " may not use project " + existing.Project})
+		return
+	}
+
+	device, created, err := h.registry.Register(registry.Device{
+		Token:      payload.Token,
+		UserID:     payload.UserID,
+		Platform:   payload.Platform,
+		AppVersion: payload.AppVersion,
+		Project:    project,
+	})
+	if errors.Is(err, registry.ErrInvalidDevice) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body: " + err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register token", "details": err.Error()})
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, device)
+}
+
+// ListTokens lists the tokens of the projects the caller's API key may use.
+func (h *RegistryHandler) ListTokens(c *gin.Context) {
+	includeInvalid, err := strconv.ParseBool(c.DefaultQuery("include_invalid", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid include_invalid query parameter: " + err.Error()})
+		return
+	}
+
+	devices, err := h.registry.List(registry.Filter{
+		UserID:         c.Query("user_id"),
+		Platform:       c.Query("platform"),
+		Project:        c.Query("project"),
+		IncludeInvalid: includeInvalid,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list tokens", "details": err.Error()})
+		return
+	}
+
+	devices = slices.DeleteFunc(devices, func(d registry.Device) bool {
+		return !allowsProject(c, d.Project)
+	})
+	c.JSON(http.StatusOK, gin.H{"tokens": devices})
+}
+
+// GetToken answers 404 for tokens of projects the caller's API key may not
+// use, as if they were not registered.
+func (h *RegistryHandler) GetToken(c *gin.Context) {
+	device, ok, err := h.registry.Get(c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up token", "details": err.Error()})
+		return
+	}
+	if !ok || !allowsProject(c, device.Project) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, device)
+}
+
+// DeleteToken answers 404 for tokens of projects the caller's API key may not
+// use.
+func (h *RegistryHandler) DeleteToken(c *gin.Context) {
+	token := c.Param("token")
+	device, ok, err := h.registry.Get(token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up token", "details": err.Error()})
+		return
+	}
+	if !ok || !allowsProject(c, device.Project) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+
+	deleted, err := h.registry.Delete(token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete token", "details": err.Error()})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token deleted"})
+}
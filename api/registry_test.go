@@ -0,0 +1,383 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wirsal/fcm-gateway/fcm"
+	"github.com/wirsal/fcm-gateway/internal/auth"
+	"github.com/wirsal/fcm-gateway/internal/registry"
+)
+
+func newRegistryRouter(t *testing.T, reg *registry.Registry) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	h := NewRegistryHandler(reg, fcm.SingleProject("default", nil))
+	router := gin.New()
+	router.POST("/tokens", h.RegisterToken)
+	router.GET("/tokens", h.ListTokens)
+	router.GET("/tokens/:token", h.GetToken)
+	router.DELETE("/tokens/:token", h.DeleteToken)
+	return router
+}
+
+func newTestRegistry(t *testing.T) *registry.Registry {
+	t.Helper()
+	store, err := registry.NewLocalStore("")
+	require.NoError(t, err)
+	return registry.New(store, false, "default")
+}
+
+func serveJSON(t *testing.T, router http.Handler, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var raw []byte
+	if body != nil {
+		var err error
+		raw, err = json.Marshal(body)
+		require.NoError(t, err)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(raw)))
+	return rec
+}
+
+func TestRegistryHandler(t *testing.T) {
+	t.Run("success - register, list, get and delete tokens", func(t *testing.T) {
+		// --- Setup ---
+		router := newRegistryRouter(t, newTestRegistry(t))
+		token := "fcm:APA91b-token"
+
+		// --- Execute ---
+		created := serveJSON(t, router, http.MethodPost, "/tokens", gin.H{"token": token, "user_id": "u1", "platform": "android", "app_version": "1.0"})
+		updated := serveJSON(t, router, http.MethodPost, "/tokens", gin.H{"token": token, "user_id": "u1", "platform": "android", "app_version": "1.1"})
+		serveJSON(t, router, http.MethodPost, "/tokens", gin.H{"token": "other", "user_id": "u2", "platform": "ios"})
+		list := serveJSON(t, router, http.MethodGet, "/tokens?user_id=u1", nil)
+		got := serveJSON(t, router, http.MethodGet, "/tokens/"+url.PathEscape(token), nil)
+		deleted := serveJSON(t, router, http.MethodDelete, "/tokens/"+url.PathEscape(token), nil)
+		deletedAgain := serveJSON(t, router, http.MethodDelete, "/tokens/"+url.PathEscape(token), nil)
+
+		// --- Assert ---
+		assert.Equal(t, http.StatusCreated, created.Code, created.Body.String())
+		assert.Equal(t, http.StatusOK, updated.Code)
+
+		require.Equal(t, http.StatusOK, list.Code)
+		var listed struct {
+			Tokens []registry.Device `json:"tokens"`
+		}
+		require.NoError(t, json.Unmarshal(list.Body.Bytes(), &listed))
+		require.Len(t, listed.Tokens, 1)
+		assert.Equal(t, "1.1", listed.Tokens[0].AppVersion)
+
+		require.Equal(t, http.StatusOK, got.Code)
+		var device registry.Device
+		require.NoError(t, json.Unmarshal(got.Body.Bytes(), &device))
+		assert.Equal(t, token, device.Token)
+
+		assert.Equal(t, http.StatusOK, deleted.Code)
+		assert.Equal(t, http.StatusNotFound, deletedAgain.Code)
+	})
+
+	t.Run("success - tokens FCM reports as unregistered are flagged", func(t *testing.T) {
+		// --- Setup ---
+		reg := newTestRegistry(t)
+		router := newRegistryRouter(t, reg)
+		serveJSON(t, router, http.MethodPost, "/tokens", gin.H{"token": "dead", "user_id": "u1", "platform": "ios"})
+		serveJSON(t, router, http.MethodPost, "/tokens", gin.H{"token": "alive", "user_id": "u1", "platform": "ios"})
+		service := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+			if readMessage(t, r)["token"] == "dead" {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error":{"code":404,"status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/1"}`))
+		}, fcm.WithInvalidTokenHandler(reg.InvalidTokenHandler("default")))
+		h := NewHandler(service, 4, nil, nil, nil, nil)
+
+		// --- Execute ---
+		sent := performRequest(t, h.SendNotification, http.MethodPost, "/send", gin.H{
+			"tokens":       []string{"dead", "alive"},
+			"notification": gin.H{"title": "Hello"},
+		})
+		active := serveJSON(t, router, http.MethodGet, "/tokens?user_id=u1", nil)
+		all := serveJSON(t, router, http.MethodGet, "/tokens?user_id=u1&include_invalid=true", nil)
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, sent.Code)
+		assert.JSONEq(t, `["alive"]`, tokensOf(t, active))
+		assert.JSONEq(t, `["dead","alive"]`, tokensOf(t, all))
+		assert.Contains(t, all.Body.String(), `"invalid_reason":"UNREGISTERED"`)
+	})
+
+	t.Run("success - a permission error leaves registered tokens alone", func(t *testing.T) {
+		// --- Setup ---
+		store, err := registry.NewLocalStore("")
+		require.NoError(t, err)
+		reg := registry.New(store, true, "default")
+		router := newRegistryRouter(t, reg)
+		serveJSON(t, router, http.MethodPost, "/tokens", gin.H{"token": "a", "user_id": "u1", "platform": "ios"})
+		service := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":{"code":403,"message":"Permission denied","status":"PERMISSION_DENIED",` +
+				`"details":[{"@type":"type.googleapis.com/google.rpc.ErrorInfo","reason":"IAM_PERMISSION_DENIED"}]}}`))
+		}, fcm.WithInvalidTokenHandler(reg.InvalidTokenHandler("default")))
+		h := NewHandler(service, 4, nil, nil, nil, reg)
+
+		// --- Execute ---
+		sent := performRequest(t, h.SendToUsers, http.MethodPost, "/sendToUsers", gin.H{
+			"user_ids":     []string{"u1"},
+			"notification": gin.H{"title": "Hello"},
+		})
+		active := serveJSON(t, router, http.MethodGet, "/tokens?user_id=u1", nil)
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, sent.Code, sent.Body.String())
+		assert.Contains(t, sent.Body.String(), `"failure_count":1`)
+		assert.JSONEq(t, `["a"]`, tokensOf(t, active))
+	})
+
+	t.Run("success - hides tokens of projects the API key may not use", func(t *testing.T) {
+		// --- Setup ---
+		reg := newTestRegistry(t)
+		for _, d := range []registry.Device{
+			{Token: "a-token", UserID: "u1", Platform: "ios", Project: "brand-a"},
+			{Token: "b-token", UserID: "u1", Platform: "ios", Project: "brand-b"},
+		} {
+			_, _, err := reg.Register(d)
+			require.NoError(t, err)
+		}
+		gin.SetMode(gin.TestMode)
+		h := NewRegistryHandler(reg, fcm.SingleProject("brand-a", nil))
+		router := gin.New()
+		router.Use(withAPIKey(&auth.Key{Name: "brand-a", Projects: []string{"brand-a"}}))
+		router.POST("/tokens", h.RegisterToken)
+		router.GET("/tokens", h.ListTokens)
+		router.GET("/tokens/:token", h.GetToken)
+		router.DELETE("/tokens/:token", h.DeleteToken)
+
+		// --- Execute ---
+		list := serveJSON(t, router, http.MethodGet, "/tokens?user_id=u1", nil)
+		got := serveJSON(t, router, http.MethodGet, "/tokens/b-token", nil)
+		deleted := serveJSON(t, router, http.MethodDelete, "/tokens/b-token", nil)
+		moved := serveJSON(t, router, http.MethodPost, "/tokens", gin.H{"token": "b-token", "user_id": "u2", "platform": "ios"})
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, list.Code)
+		assert.JSONEq(t, `["a-token"]`, tokensOf(t, list))
+		assert.Equal(t, http.StatusNotFound, got.Code)
+		assert.Equal(t, http.StatusNotFound, deleted.Code)
+		assert.Equal(t, http.StatusForbidden, moved.Code)
+		d, ok, err := reg.Get("b-token")
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, "brand-b", d.Project)
+		assert.Equal(t, "u1", d.UserID)
+	})
+
+	t.Run("error - invalid registration", func(t *testing.T) {
+		router := newRegistryRouter(t, newTestRegistry(t))
+
+		rec := serveJSON(t, router, http.MethodPost, "/tokens", gin.H{"token": "t", "user_id": "u1", "platform": "blackberry"})
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "platform must be android, ios or web")
+	})
+
+	t.Run("error - unknown token", func(t *testing.T) {
+		router := newRegistryRouter(t, newTestRegistry(t))
+
+		rec := serveJSON(t, router, http.MethodGet, "/tokens/nope", nil)
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("error - invalid include_invalid flag", func(t *testing.T) {
+		router := newRegistryRouter(t, newTestRegistry(t))
+
+		rec := serveJSON(t, router, http.MethodGet, "/tokens?include_invalid=perhaps", nil)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+// tokensOf returns the tokens of a GET /tokens response as a JSON array.
+func tokensOf(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Tokens []registry.Device `json:"tokens"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	tokens := make([]string, len(body.Tokens))
+	for i, d := range body.Tokens {
+		tokens[i] = d.Token
+	}
+	raw, err := json.Marshal(tokens)
+	require.NoError(t, err)
+	return string(raw)
+}
+
+func TestHandler_SendToUsers(t *testing.T) {
+	t.Run("success - resolves users to their tokens and groups results per user", func(t *testing.T) {
+		// --- Setup ---
+		reg := newTestRegistry(t)
+		for _, d := range []registry.Device{
+			{Token: "u1-phone", UserID: "u1", Platform: "android"},
+			{Token: "u1-dead", UserID: "u1", Platform: "ios"},
+			{Token: "u2-web", UserID: "u2", Platform: "web"},
+			{Token: "u3-dead", UserID: "u3", Platform: "ios"},
+			{Token: "other", UserID: "u4", Platform: "ios"},
+		} {
+			_, _, err := reg.Register(d)
+			require.NoError(t, err)
+		}
+		var mu sync.Mutex
+		var sent []string
+		service := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+			token := readMessage(t, r)["token"].(string)
+			mu.Lock()
+			sent = append(sent, token)
+			mu.Unlock()
+			if strings.HasSuffix(token, "-dead") {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error":{"code":404,"status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`))
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"name":"projects/test-project/messages/%s"}`, token)
+		})
+		h := NewHandler(service, 2, nil, nil, nil, reg)
+
+		// --- Execute ---
+		rec := performRequest(t, h.SendToUsers, http.MethodPost, "/sendToUsers", gin.H{
+			"user_ids":     []string{"u2", "u1", "u3", "u1", "nobody"},
+			"notification": gin.H{"title": "Hello"},
+		})
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var body struct {
+			SuccessCount int          `json:"success_count"`
+			FailureCount int          `json:"failure_count"`
+			Users        []UserResult `json:"users"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, 2, body.SuccessCount)
+		assert.Equal(t, 2, body.FailureCount)
+		mu.Lock()
+		assert.ElementsMatch(t, []string{"u1-phone", "u1-dead", "u2-web", "u3-dead"}, sent)
+		mu.Unlock()
+
+		require.Len(t, body.Users, 4)
+		u2, u1, u3, nobody := body.Users[0], body.Users[1], body.Users[2], body.Users[3]
+		assert.Equal(t, "u2", u2.UserID)
+		assert.True(t, u2.Delivered)
+
+		assert.Equal(t, "u1", u1.UserID)
+		assert.True(t, u1.Delivered)
+		assert.Equal(t, 1, u1.SuccessCount)
+		assert.Equal(t, 1, u1.FailureCount)
+		require.Len(t, u1.Results, 2)
+		assert.Equal(t, "projects/test-project/messages/u1-phone", u1.Results[0].MessageName)
+		assert.Equal(t, fcm.ErrorCodeUnregistered, u1.Results[1].ErrorCode)
+
+		assert.Equal(t, "u3", u3.UserID)
+		assert.False(t, u3.Delivered)
+		assert.Equal(t, 1, u3.FailureCount)
+
+		assert.Equal(t, "nobody", nobody.UserID)
+		assert.False(t, nobody.Delivered)
+		assert.Empty(t, nobody.Results)
+	})
+
+	t.Run("success - sends only to tokens of the selected project", func(t *testing.T) {
+		// --- Setup ---
+		reg := newTestRegistry(t)
+		mismatch := func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":{"code":403,"status":"PERMISSION_DENIED","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"SENDER_ID_MISMATCH"}]}}`))
+		}
+		var mu sync.Mutex
+		var sent []string
+		newProject := func(name string) *fcm.Service {
+			return newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+				token := readMessage(t, r)["token"].(string)
+				mu.Lock()
+				sent = append(sent, token)
+				mu.Unlock()
+				if !strings.HasPrefix(token, name) {
+					mismatch(w)
+					return
+				}
+				_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/1"}`))
+			}, fcm.WithInvalidTokenHandler(reg.InvalidTokenHandler(name)))
+		}
+		projects, err := fcm.NewProjects("default", map[string]*fcm.Service{
+			"default": newProject("default"),
+			"brand-b": newProject("brand-b"),
+		})
+		require.NoError(t, err)
+		registryRouter := gin.New()
+		registryRouter.POST("/tokens", NewRegistryHandler(reg, projects).RegisterToken)
+		registered := serveJSON(t, registryRouter, http.MethodPost, "/tokens", gin.H{"token": "default-phone", "user_id": "u1", "platform": "ios"})
+		require.Equal(t, http.StatusCreated, registered.Code, registered.Body.String())
+		registered = serveJSON(t, registryRouter, http.MethodPost, "/tokens", gin.H{"token": "brand-b-phone", "user_id": "u1", "platform": "ios", "project": "brand-b"})
+		require.Equal(t, http.StatusCreated, registered.Code, registered.Body.String())
+		unknown := serveJSON(t, registryRouter, http.MethodPost, "/tokens", gin.H{"token": "x", "user_id": "u1", "platform": "ios", "project": "brand-z"})
+
+		h := NewHandler(nil, 2, nil, nil, nil, reg)
+		router := gin.New()
+		router.POST("/projects/:project/sendToUsers", ProjectMiddleware(projects), h.SendToUsers)
+
+		// --- Execute ---
+		rec := serveJSON(t, router, http.MethodPost, "/projects/brand-b/sendToUsers", gin.H{
+			"user_ids":     []string{"u1"},
+			"notification": gin.H{"title": "Hello"},
+		})
+
+		// --- Assert ---
+		assert.Equal(t, http.StatusBadRequest, unknown.Code)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, []string{"brand-b-phone"}, sent)
+		d, ok, err := reg.Get("default-phone")
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Nil(t, d.InvalidAt)
+		assert.Equal(t, "default", d.Project)
+	})
+
+	t.Run("error - registry disabled", func(t *testing.T) {
+		h := NewHandler(nil, 4, nil, nil, nil, nil)
+
+		rec := performRequest(t, h.SendToUsers, http.MethodPost, "/sendToUsers", gin.H{"user_ids": []string{"u1"}, "notification": gin.H{"title": "x"}})
+
+		assert.Equal(t, http.StatusNotImplemented, rec.Code)
+	})
+
+	tests := []struct {
+		name    string
+		body    gin.H
+		wantErr string
+	}{
+		{"error - empty user_ids", gin.H{"user_ids": []string{}, "notification": gin.H{"title": "x"}}, "user_ids list cannot be empty"},
+		{"error - empty user ID", gin.H{"user_ids": []string{"u1", ""}, "notification": gin.H{"title": "x"}}, "user_ids cannot contain empty IDs"},
+		{"error - no content", gin.H{"user_ids": []string{"u1"}}, "either notification or data must be set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(nil, 4, nil, nil, nil, newTestRegistry(t))
+
+			rec := performRequest(t, h.SendToUsers, http.MethodPost, "/sendToUsers", tt.body)
+
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.wantErr)
+		})
+	}
+}
@@ -4,8 +4,53 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/wirsal/fcm-gateway/fcm"
+	"github.com/wirsal/fcm-gateway/internal/job"
 )
 
+// FailedToken reports a device token that could not be sent to.
+type FailedToken struct {
+	Token string `json:"token"`
+	Error string `json:"error"`
+	// ErrorCode is the FCM error code, e.g. UNREGISTERED or QUOTA_EXCEEDED.
+	ErrorCode string `json:"error_code"`
+	Attempts  int    `json:"attempts"`
+}
+
+// newFailedTokens picks the failures out of results, keeping their order.
+func newFailedTokens(results []fcm.TokenResult) []FailedToken {
+	var failed []FailedToken
+	for _, res := range results {
+		if res.Error == "" {
+			continue
+		}
+		failed = append(failed, FailedToken{
+			Token:     res.Token,
+			Error:     res.Error,
+			ErrorCode: res.ErrorCode,
+			Attempts:  res.Attempts,
+		})
+	}
+	return failed
+}
+
+// UserResult groups the outcome of a send to every registered token of one
+// user.
+type UserResult struct {
+	UserID string `json:"user_id"`
+	// Delivered is set when at least one of the user's tokens was sent to.
+	Delivered    bool              `json:"delivered"`
+	SuccessCount int               `json:"success_count"`
+	FailureCount int               `json:"failure_count"`
+	Results      []fcm.TokenResult `json:"results"`
+}
+
+// jobResponse is the body of GET /jobs/:id.
+type jobResponse struct {
+	job.Job
+	FailedTokens []FailedToken `json:"failed_tokens,omitempty"`
+}
+
 func setSafeHeaders(w http.ResponseWriter) {
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.Header().Set("X-XSS-Protection", "1; mode=block")
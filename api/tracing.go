@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wirsal/fcm-gateway/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/wirsal/fcm-gateway/api"
+
+// TracingMiddleware records a server span for every request. The span
+// continues the trace of the caller's W3C traceparent header, if any, and
+// is handed to the handlers through c.Request.Context(), so the FCM calls
+// they make become its children. Matched requests are named by their route
+// rather than their path, which may hold a device token.
+func TracingMiddleware(tp trace.TracerProvider) gin.HandlerFunc {
+	tracer := tp.Tracer(tracerName)
+	propagator := tracing.Propagator()
+	return func(c *gin.Context) {
+		ctx := propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		name := c.Request.Method
+		attrs := []attribute.KeyValue{
+			attribute.String("http.request.method", c.Request.Method),
+		}
+		if route := c.FullPath(); route != "" {
+			name += " " + route
+			attrs = append(attrs, attribute.String("http.route", route))
+		} else {
+			attrs = append(attrs, attribute.String("url.path", c.Request.URL.Path))
+		}
+		ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if project := projectOf(c); project != "" {
+			span.SetAttributes(attribute.String("fcm.project", project))
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
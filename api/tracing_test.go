@@ -0,0 +1,122 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wirsal/fcm-gateway/fcm"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	newRouter := func(t *testing.T, fcmHandler http.HandlerFunc) (*gin.Engine, *tracetest.InMemoryExporter) {
+		t.Helper()
+		exporter := tracetest.NewInMemoryExporter()
+		tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+		service := newTestService(t, fcmHandler, fcm.WithTracerProvider(tp))
+		projects := fcm.SingleProject("default", service)
+		h := NewHandler(service, 1, nil, nil, nil, nil)
+
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.Use(TracingMiddleware(tp))
+		router.POST("/projects/:project/send", ProjectMiddleware(projects), h.SendNotification)
+		router.GET("/tokens/:token", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+		return router, exporter
+	}
+	newRequest := func(t *testing.T) *http.Request {
+		raw, err := json.Marshal(gin.H{"tokens": []string{"t"}, "notification": gin.H{"title": "Hi"}})
+		require.NoError(t, err)
+		return httptest.NewRequest(http.MethodPost, "/projects/default/send", bytes.NewReader(raw))
+	}
+	spansByName := func(exporter *tracetest.InMemoryExporter) map[string]tracetest.SpanStub {
+		spans := make(map[string]tracetest.SpanStub)
+		for _, span := range exporter.GetSpans() {
+			spans[span.Name] = span
+		}
+		return spans
+	}
+
+	t.Run("success - continues the caller's trace down to the FCM call", func(t *testing.T) {
+		// --- Setup ---
+		router, exporter := newRouter(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"name":"projects/test-project/messages/1"}`))
+		})
+		req := newRequest(t)
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		rec := httptest.NewRecorder()
+
+		// --- Execute ---
+		router.ServeHTTP(rec, req)
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		spans := spansByName(exporter)
+		server, ok := spans["POST /projects/:project/send"]
+		require.True(t, ok, "spans: %v", spans)
+		assert.Equal(t, trace.SpanKindServer, server.SpanKind)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String())
+		assert.Contains(t, server.Attributes, attribute.Int("http.response.status_code", http.StatusOK))
+		assert.Contains(t, server.Attributes, attribute.String("fcm.project", "default"))
+
+		send := spans["fcm.send"]
+		assert.Equal(t, server.SpanContext.SpanID(), send.Parent.SpanID())
+		assert.Contains(t, send.Attributes, fcm.AttrMessageName.String("projects/test-project/messages/1"))
+		assert.Equal(t, send.SpanContext.SpanID(), spans["POST messages"].Parent.SpanID())
+	})
+
+	t.Run("success - starts a new trace without traceparent", func(t *testing.T) {
+		router, exporter := newRouter(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"code":404,"status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`))
+		})
+
+		router.ServeHTTP(httptest.NewRecorder(), newRequest(t))
+
+		spans := spansByName(exporter)
+		server := spans["POST /projects/:project/send"]
+		assert.False(t, server.Parent.IsValid())
+		assert.Equal(t, server.SpanContext.TraceID(), spans["fcm.send"].SpanContext.TraceID())
+		assert.Equal(t, codes.Error, spans["fcm.send"].Status.Code)
+	})
+
+	t.Run("success - device tokens in the path are not exported", func(t *testing.T) {
+		// --- Setup ---
+		router, exporter := newRouter(t, nil)
+		req := httptest.NewRequest(http.MethodGet, "/tokens/secret-device-token", nil)
+
+		// --- Execute ---
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		// --- Assert ---
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 1)
+		assert.Equal(t, "GET /tokens/:token", spans[0].Name)
+		assert.Contains(t, spans[0].Attributes, attribute.String("http.route", "/tokens/:token"))
+		for _, attr := range spans[0].Attributes {
+			assert.NotContains(t, attr.Value.Emit(), "secret-device-token", "attribute %s", attr.Key)
+		}
+	})
+
+	t.Run("success - unmatched requests keep their path", func(t *testing.T) {
+		router, exporter := newRouter(t, nil)
+
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 1)
+		assert.Equal(t, "GET", spans[0].Name)
+		assert.Contains(t, spans[0].Attributes, attribute.String("url.path", "/nowhere"))
+	})
+}
@@ -2,39 +2,398 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	// Embedded so send_at time zones resolve on hosts without tzdata.
+	_ "time/tzdata"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wirsal/fcm-gateway/api"
 	"github.com/wirsal/fcm-gateway/fcm"
+	"github.com/wirsal/fcm-gateway/internal/auth"
 	"github.com/wirsal/fcm-gateway/internal/config"
+	"github.com/wirsal/fcm-gateway/internal/idempotency"
+	"github.com/wirsal/fcm-gateway/internal/job"
+	"github.com/wirsal/fcm-gateway/internal/logging"
+	"github.com/wirsal/fcm-gateway/internal/metrics"
+	"github.com/wirsal/fcm-gateway/internal/ratelimit"
+	"github.com/wirsal/fcm-gateway/internal/registry"
+	"github.com/wirsal/fcm-gateway/internal/schedule"
+	"github.com/wirsal/fcm-gateway/internal/tracing"
+	"github.com/wirsal/fcm-gateway/internal/webhook"
 )
 
 func main() {
 
 	cfg, err := config.LoadConfig("configs/")
 	if err != nil {
-		log.Fatalf("Gagal memuat konfigurasi: %v", err)
+		fatal("Failed to load configuration", err)
+	}
+
+	logger, err := logging.New(os.Stdout, logging.Config{Level: cfg.Log.Level, Format: cfg.Log.Format})
+	if err != nil {
+		fatal("Invalid log configuration", err)
+	}
+	slog.SetDefault(logger)
+	logging.SetRedactTokens(cfg.Log.RedactTokens)
+	if !strings.EqualFold(cfg.Log.Level, "debug") {
+		gin.SetMode(gin.ReleaseMode)
 	}
 
 	ctx := context.Background()
 
-	fcmService, err := fcm.NewService(ctx, cfg.FCM.CredentialsFile, cfg.FCM.Scopes, cfg.FCM.EndpointURL)
+	fcmOptions := []fcm.Option{
+		fcm.WithRetryPolicy(fcm.RetryPolicy{
+			MaxAttempts: cfg.FCM.Retry.MaxAttempts,
+			BaseDelay:   cfg.FCM.Retry.BaseDelay,
+			MaxDelay:    cfg.FCM.Retry.MaxDelay,
+			Jitter:      cfg.FCM.Retry.Jitter,
+		}),
+		fcm.WithDryRun(cfg.FCM.DryRun),
+		fcm.WithIIDURL(cfg.FCM.IIDURL),
+	}
+
+	serviceName := cfg.Server.Name
+	if serviceName == "" {
+		serviceName = "fcm-gateway"
+	}
+	tracerProvider, shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		ServiceName: serviceName,
+		Version:     cfg.Server.Version,
+	})
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Warn("Failed to flush traces", "error", err)
+		}
+	}()
+	fcmOptions = append(fcmOptions, fcm.WithTracerProvider(tracerProvider))
+
+	var gatewayMetrics *metrics.Metrics
+	if cfg.Metrics.Enabled {
+		gatewayMetrics = metrics.New()
+		fcmOptions = append(fcmOptions, fcm.WithObserver(gatewayMetrics))
+	}
+
+	projectConfigs, defaultProject, err := cfg.FCM.ProjectConfigs()
+	if err != nil {
+		fatal("Invalid FCM project configuration", err)
+	}
+
+	var tokenRegistry *registry.Registry
+	if cfg.Registry.Enabled {
+		registryStore, err := registry.NewLocalStore(cfg.Registry.Path)
+		if err != nil {
+			fatal("Failed to open token registry", err)
+		}
+		tokenRegistry = registry.New(registryStore, cfg.Registry.RemoveInvalid, defaultProject)
+	}
+
+	services := make(map[string]*fcm.Service, len(projectConfigs))
+	for name, p := range projectConfigs {
+		opts := append(slices.Clone(fcmOptions), fcm.WithRateLimit(p.RateLimit.PerSecond, p.RateLimit.Burst))
+		if tokenRegistry != nil {
+			// Keyed by project, so a token rejected by one project is not
+			// pruned when it was registered for another.
+			opts = append(opts, fcm.WithInvalidTokenHandler(tokenRegistry.InvalidTokenHandler(name)))
+		}
+		service, err := fcm.NewService(ctx, p.CredentialsFile, p.Scopes, p.EndpointURL, opts...)
+		if err != nil {
+			fatal("Failed to initialize FCM service", err, "project", name)
+		}
+		services[name] = service
+	}
+	projects, err := fcm.NewProjects(defaultProject, services)
 	if err != nil {
-		log.Fatalf("Gagal inisialisasi service FCM: %v", err)
+		fatal("Invalid FCM project configuration", err)
+	}
+	slog.Info("Serving Firebase projects", "projects", projects.Names(), "default", projects.Default())
+	if gatewayMetrics != nil {
+		gatewayMetrics.WatchProjects(projects)
 	}
 
-	apiHandler := api.NewHandler(fcmService)
+	if cfg.FCM.DryRun {
+		slog.Warn("FCM dry run is enabled: messages are validated but never delivered")
+	}
+
+	var notifier *webhook.Notifier
+	if cfg.Webhooks.Secret != "" {
+		notifier = webhook.NewNotifier(webhook.Config{
+			Secret:               cfg.Webhooks.Secret,
+			DefaultURL:           cfg.Webhooks.DefaultURL,
+			RetryDelays:          cfg.Webhooks.RetryDelays,
+			Timeout:              cfg.Webhooks.Timeout,
+			AllowedHosts:         cfg.Webhooks.AllowedHosts,
+			AllowHTTP:            cfg.Webhooks.AllowHTTP,
+			AllowPrivateNetworks: cfg.Webhooks.AllowPrivateNetworks,
+		})
+	} else if cfg.Webhooks.DefaultURL != "" {
+		fatal("Invalid webhook configuration", errors.New("webhooks.default_url requires webhooks.secret"))
+	}
 
-	router := gin.Default()
-	router.Use(api.SafeHeaderMiddleware())
+	jobConfig := job.Config{
+		Workers:     cfg.Jobs.Workers,
+		QueueSize:   cfg.Jobs.QueueSize,
+		Concurrency: cfg.FCM.MaxConcurrency,
+		Retention:   cfg.Jobs.Retention,
+		OnFinish:    notifier.JobFinished,
+	}
+	if cfg.Jobs.StoreDir != "" {
+		jobStore, err := job.NewFileStore(cfg.Jobs.StoreDir)
+		if err != nil {
+			fatal("Failed to open job store", err)
+		}
+		jobConfig.Store = jobStore
+	}
+	jobManager := job.NewManager(projects, jobConfig)
+	resumed, err := jobManager.Recover()
+	if err != nil {
+		fatal("Failed to recover jobs", err)
+	}
+	if resumed > 0 {
+		slog.Info("Resuming unfinished jobs", "jobs", resumed)
+	}
+	jobManager.Start(ctx)
+
+	scheduleConfig := schedule.Config{
+		Concurrency: cfg.FCM.MaxConcurrency,
+		OnSent:      notifier.ScheduleSent,
+	}
+	if cfg.Schedules.StoreDir != "" {
+		scheduleStore, err := schedule.NewFileStore(cfg.Schedules.StoreDir)
+		if err != nil {
+			fatal("Failed to open schedule store", err)
+		}
+		scheduleConfig.Store = scheduleStore
+	}
+	scheduler := schedule.NewScheduler(projects, scheduleConfig)
+	restored, err := scheduler.Recover()
+	if err != nil {
+		fatal("Failed to recover schedules", err)
+	}
+	if restored > 0 {
+		slog.Info("Restored pending schedules", "schedules", restored)
+	}
+	scheduler.Start(ctx)
+
+	var (
+		keyring     *auth.Keyring
+		jwtVerifier *auth.JWTVerifier
+	)
+	if cfg.Auth.Enabled {
+		if len(cfg.Auth.Keys) > 0 || cfg.Auth.KeyFile != "" {
+			keyring, err = loadKeyring(cfg.Auth)
+			if err != nil {
+				fatal("Failed to load API keys", err)
+			}
+		}
+		if cfg.Auth.JWT.Configured() {
+			jwtVerifier, err = newJWTVerifier(cfg.Auth.JWT)
+			if err != nil {
+				fatal("Failed to set up JWT authentication", err)
+			}
+		}
+		if keyring == nil && jwtVerifier == nil {
+			fatal("Invalid auth configuration", errors.New("authentication is enabled but neither API keys nor JWT are configured"))
+		}
+	} else {
+		slog.Warn("API key authentication is disabled: anyone who can reach the server can send notifications")
+	}
+
+	apiHandler := api.NewHandler(services[defaultProject], cfg.FCM.MaxConcurrency, jobManager, scheduler, notifier, tokenRegistry)
+
+	router := gin.New()
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		fatal("Invalid server.trusted_proxies", err)
+	}
+	router.Use(api.RequestIDMiddleware(), api.TracingMiddleware(tracerProvider), api.AccessLogMiddleware(logger))
+	if gatewayMetrics != nil {
+		router.Use(api.MetricsMiddleware(gatewayMetrics))
+		router.GET(cfg.Metrics.Path, gin.WrapH(gatewayMetrics.Handler()))
+	}
+	health := api.NewHealth()
+	router.GET("/healthz", health.Live)
+	router.GET("/readyz", health.Ready)
+	router.Use(api.RecoveryMiddleware(logger), health.RefuseWhileDraining(), api.SafeHeaderMiddleware())
 	router.GET("/", apiHandler.Welcome)
-	router.POST("/send", apiHandler.SendNotification)
-	router.POST("/sendBroadcast", apiHandler.SendBroadcast)
+	idempotent := api.IdempotencyMiddleware(idempotency.NewStore(cfg.Idempotency.Window, cfg.Idempotency.MaxBytes))
+	protected := router.Group("/")
+	if cfg.Auth.Enabled {
+		protected.Use(api.AuthMiddleware(keyring, jwtVerifier))
+	}
+	if cfg.Server.RateLimit.PerSecond > 0 {
+		limiter := ratelimit.New(cfg.Server.RateLimit.PerSecond, cfg.Server.RateLimit.Burst)
+		if gatewayMetrics != nil {
+			gatewayMetrics.WatchRateLimiter(limiter)
+		}
+		protected.Use(api.RateLimitMiddleware(limiter))
+	}
+	requireSend := api.RequireScope(auth.ScopeSend)
+	requireBroadcast := api.RequireScope(auth.ScopeBroadcast)
+	requireAdmin := api.RequireScope(auth.ScopeAdmin)
+	// Project-scoped routes are served both as /send, for the project named
+	// in the X-Firebase-Project header or the default one, and as
+	// /projects/{project}/send.
+	projectRoutes := func(r gin.IRoutes) {
+		r.POST("/send", requireSend, idempotent, apiHandler.SendNotification)
+		r.POST("/sendBroadcast", requireBroadcast, idempotent, apiHandler.SendBroadcast)
+		r.POST("/sendTopic", requireBroadcast, idempotent, apiHandler.SendTopic)
+		r.POST("/topics/:topic/subscribe", requireAdmin, apiHandler.SubscribeTopic)
+		r.POST("/topics/:topic/unsubscribe", requireAdmin, apiHandler.UnsubscribeTopic)
+		if tokenRegistry != nil {
+			r.POST("/sendToUsers", requireSend, idempotent, apiHandler.SendToUsers)
+		}
+	}
+	selectProject := api.ProjectMiddleware(projects)
+	projectRoutes(protected.Group("/", selectProject))
+	projectRoutes(protected.Group("/projects/:project", selectProject))
+	requireSender := api.RequireScope(auth.ScopeSend, auth.ScopeBroadcast)
+	protected.GET("/jobs/:id", requireSender, apiHandler.GetJob)
+	protected.GET("/schedules", requireSender, apiHandler.ListSchedules)
+	protected.DELETE("/schedules/:id", requireSender, apiHandler.CancelSchedule)
+	if tokenRegistry != nil {
+		registryHandler := api.NewRegistryHandler(tokenRegistry, projects)
+		protected.POST("/tokens", requireAdmin, registryHandler.RegisterToken)
+		protected.GET("/tokens", requireAdmin, registryHandler.ListTokens)
+		protected.GET("/tokens/:token", requireAdmin, registryHandler.GetToken)
+		protected.DELETE("/tokens/:token", requireAdmin, registryHandler.DeleteToken)
+	}
+
+	// Requests are cancelled through baseCtx once the drain timeout passed.
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	server := &http.Server{
+		Addr:        ":" + cfg.Server.Port,
+		Handler:     router,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+	slog.Info("Server listening", "addr", server.Addr)
 
-	log.Printf("Server Gin berjalan di http://localhost:%s", cfg.Server.Port)
-	if err := router.Run(":" + cfg.Server.Port); err != nil {
-		log.Fatalf("Gagal menjalankan server Gin: %v", err)
+	select {
+	case err := <-serveErr:
+		fatal("Server failed", err)
+	case sig := <-signals:
+		// A second signal kills the process instead of waiting for the drain.
+		signal.Stop(signals)
+		slog.Info("Shutting down", "signal", sig.String(), "delay", cfg.Server.Shutdown.Delay, "timeout", cfg.Server.Shutdown.Timeout)
 	}
 
+	health.Drain()
+	time.Sleep(cfg.Server.Shutdown.Delay)
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.Server.Shutdown.Timeout)
+	defer cancelDrain()
+	var wg sync.WaitGroup
+	drain := func(name string, shutdown func(context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := shutdown(drainCtx); err != nil {
+				slog.Warn("Drain timed out", "component", name, "error", err)
+			}
+		}()
+	}
+	drain("jobs", jobManager.Shutdown)
+	drain("schedules", scheduler.Shutdown)
+	drain("http", func(ctx context.Context) error {
+		err := server.Shutdown(ctx)
+		if err != nil {
+			// Handlers still running answer with their remaining tokens
+			// reported as not attempted once their requests are cancelled.
+			cancelRequests()
+			graceCtx, cancel := context.WithTimeout(context.Background(), cancelGrace)
+			defer cancel()
+			if server.Shutdown(graceCtx) != nil {
+				_ = server.Close()
+			}
+		}
+		return err
+	})
+	wg.Wait()
+
+	// Results reported while draining get one more delivery attempt.
+	webhookCtx, cancelWebhooks := context.WithTimeout(context.Background(), cfg.Webhooks.Timeout)
+	defer cancelWebhooks()
+	if err := notifier.Shutdown(webhookCtx); err != nil {
+		slog.Warn("Drain timed out", "component", "webhooks", "error", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// cancelGrace is how long handlers get to answer once their requests were
+// cancelled at the end of the drain timeout.
+const cancelGrace = 5 * time.Second
+
+// fatal logs msg with err and exits.
+func fatal(msg string, err error, args ...any) {
+	slog.Error(msg, append(args, "error", err)...)
+	os.Exit(1)
+}
+
+// loadKeyring collects the API keys of the config and of its key file.
+func loadKeyring(cfg config.AuthConfig) (*auth.Keyring, error) {
+	var keys []auth.Key
+	for _, k := range cfg.Keys {
+		keys = append(keys, auth.Key{
+			Name:           k.Name,
+			Hash:           k.Hash,
+			Scopes:         k.Scopes,
+			Projects:       k.Projects,
+			Topics:         k.Topics,
+			DefaultProject: k.DefaultProject,
+		})
+	}
+	if cfg.KeyFile != "" {
+		fileKeys, err := auth.LoadKeyFile(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fileKeys...)
+	}
+	return auth.NewKeyring(keys)
+}
+
+// newJWTVerifier returns a verifier for JWTs signed with the keys of the
+// configured JWKS file or URL.
+func newJWTVerifier(cfg config.JWTAuthConfig) (*auth.JWTVerifier, error) {
+	var keys auth.KeySource
+	if cfg.JWKSFile != "" {
+		set, err := auth.LoadJWKSFile(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = set
+	} else {
+		keys = auth.NewRemoteJWKS(cfg.JWKSURL, cfg.CacheTTL)
+	}
+	return auth.NewJWTVerifier(keys, auth.JWTConfig{
+		Issuer:              cfg.Issuer,
+		Audience:            cfg.Audience,
+		Leeway:              cfg.Leeway,
+		ScopesClaim:         cfg.Claims.Scopes,
+		ProjectsClaim:       cfg.Claims.Projects,
+		TopicsClaim:         cfg.Claims.Topics,
+		DefaultProjectClaim: cfg.Claims.DefaultProject,
+	})
 }
@@ -0,0 +1,221 @@
+package fcm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Error codes FCM v1 reports in the FcmError detail of a failed send.
+// See https://firebase.google.com/docs/reference/fcm/rest/v1/ErrorCode.
+const (
+	ErrorCodeUnspecified      = "UNSPECIFIED_ERROR"
+	ErrorCodeInvalidArgument  = "INVALID_ARGUMENT"
+	ErrorCodeUnregistered     = "UNREGISTERED"
+	ErrorCodeSenderIDMismatch = "SENDER_ID_MISMATCH"
+	ErrorCodeQuotaExceeded    = "QUOTA_EXCEEDED"
+	ErrorCodeUnavailable      = "UNAVAILABLE"
+	ErrorCodeInternal         = "INTERNAL"
+	ErrorCodeThirdPartyAuth   = "THIRD_PARTY_AUTH_ERROR"
+)
+
+const (
+	fcmErrorType   = "type.googleapis.com/google.firebase.fcm.v1.FcmError"
+	badRequestType = "type.googleapis.com/google.rpc.BadRequest"
+)
+
+// FieldViolation is one entry of a google.rpc.BadRequest detail.
+type FieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// ErrorDetail is one entry of the details array of a google.rpc.Status.
+type ErrorDetail struct {
+	Type            string           `json:"@type"`
+	ErrorCode       string           `json:"errorCode,omitempty"`
+	FieldViolations []FieldViolation `json:"fieldViolations,omitempty"`
+}
+
+// Error is a non-200 response from the FCM v1 API.
+type Error struct {
+	// StatusCode is the HTTP status of the response.
+	StatusCode int
+	// Code is the FCM error code, e.g. UNREGISTERED or QUOTA_EXCEEDED.
+	Code string
+	// Status is the canonical google.rpc status name, e.g. NOT_FOUND.
+	Status  string
+	Message string
+	Details []ErrorDetail
+	// Body is the raw response body, kept for responses that are not a
+	// google.rpc.Status.
+	Body string
+	// RetryAfter is the wait FCM asked for in its Retry-After header.
+	RetryAfter time.Duration
+}
+
+type rpcStatus struct {
+	Error struct {
+		Code    int           `json:"code"`
+		Message string        `json:"message"`
+		Status  string        `json:"status"`
+		Details []ErrorDetail `json:"details"`
+	} `json:"error"`
+}
+
+// parseError builds an *Error from a non-200 FCM response body.
+func parseError(statusCode int, body []byte) *Error {
+	e := &Error{StatusCode: statusCode, Body: string(body)}
+
+	var st rpcStatus
+	if err := json.Unmarshal(body, &st); err == nil {
+		e.Status = st.Error.Status
+		e.Message = st.Error.Message
+		e.Details = st.Error.Details
+	} else {
+		// The Instance ID API reports errors as {"error": "message"}.
+		var plain struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &plain) == nil {
+			e.Message = plain.Error
+		}
+	}
+
+	e.Code = e.detailCode()
+	if e.Code == "" {
+		// Good enough for metrics and retries, but TokenInvalid ignores it.
+		e.Code = codeFromStatus(statusCode)
+	}
+
+	return e
+}
+
+// codeFromStatus maps an HTTP status to the FCM error code FCM documents for
+// it, for responses that carry no FcmError detail.
+func codeFromStatus(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return ErrorCodeInvalidArgument
+	case http.StatusUnauthorized:
+		return ErrorCodeThirdPartyAuth
+	case http.StatusForbidden:
+		return ErrorCodeSenderIDMismatch
+	case http.StatusNotFound:
+		return ErrorCodeUnregistered
+	case http.StatusTooManyRequests:
+		return ErrorCodeQuotaExceeded
+	case http.StatusInternalServerError:
+		return ErrorCodeInternal
+	case http.StatusServiceUnavailable:
+		return ErrorCodeUnavailable
+	default:
+		return ErrorCodeUnspecified
+	}
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = e.Body
+	}
+	return fmt.Sprintf("FCM error %d: %s", e.StatusCode, msg)
+}
+
+// Retryable reports whether sending the same message again later may succeed.
+func (e *Error) Retryable() bool {
+	switch e.Code {
+	case ErrorCodeQuotaExceeded, ErrorCodeUnavailable, ErrorCodeInternal:
+		return true
+	case ErrorCodeInvalidArgument, ErrorCodeUnregistered, ErrorCodeSenderIDMismatch, ErrorCodeThirdPartyAuth:
+		return false
+	}
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// TokenInvalid reports whether the target registration token will never be
+// accepted again and should be dropped by the caller. Only an FcmError code or
+// a message.token field violation counts: a bare 403 or 404, e.g. from an IAM
+// misconfiguration or a wrong endpoint_url, says nothing about the token.
+func (e *Error) TokenInvalid() bool {
+	switch e.detailCode() {
+	case ErrorCodeUnregistered, ErrorCodeSenderIDMismatch:
+		return true
+	case ErrorCodeInvalidArgument:
+		if strings.Contains(e.Message, "registration token") {
+			return true
+		}
+	}
+	for _, d := range e.Details {
+		if d.Type != badRequestType {
+			continue
+		}
+		for _, v := range d.FieldViolations {
+			if v.Field == "message.token" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// detailCode returns the error code of the FcmError detail, or "" when the
+// response has none.
+func (e *Error) detailCode() string {
+	for _, d := range e.Details {
+		if d.Type == fcmErrorType && d.ErrorCode != "" {
+			return d.ErrorCode
+		}
+	}
+	return ""
+}
+
+// ErrorCode returns the FCM error code carried by err, ErrorCodeUnspecified
+// when err did not come from an FCM response, and "" when err is nil.
+func ErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	var fcmErr *Error
+	if errors.As(err, &fcmErr) {
+		return fcmErr.Code
+	}
+	return ErrorCodeUnspecified
+}
+
+// transportError marks a network failure talking to FCM.
+type transportError struct {
+	err error
+}
+
+// newTransportError wraps err unless ctx was cancelled, in which case the
+// failure is the caller's doing and not worth retrying.
+func newTransportError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return err
+	}
+	return &transportError{err: err}
+}
+
+func (e *transportError) Error() string { return e.err.Error() }
+func (e *transportError) Unwrap() error { return e.err }
+
+// IsRetryable reports whether sending again may succeed: err is a retryable
+// FCM error or a network failure.
+func IsRetryable(err error) bool {
+	var fcmErr *Error
+	if errors.As(err, &fcmErr) {
+		return fcmErr.Retryable()
+	}
+	var tErr *transportError
+	return errors.As(err, &tErr)
+}
@@ -0,0 +1,147 @@
+package fcm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name         string
+		statusCode   int
+		body         string
+		wantCode     string
+		wantStatus   string
+		wantMessage  string
+		retryable    bool
+		tokenInvalid bool
+	}{
+		{
+			name:       "unregistered token",
+			statusCode: http.StatusNotFound,
+			body: `{"error":{"code":404,"message":"Requested entity was not found.","status":"NOT_FOUND",
+				"details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`,
+			wantCode:     ErrorCodeUnregistered,
+			wantStatus:   "NOT_FOUND",
+			wantMessage:  "FCM error 404: Requested entity was not found.",
+			tokenInvalid: true,
+		},
+		{
+			name:       "quota exceeded",
+			statusCode: http.StatusTooManyRequests,
+			body: `{"error":{"code":429,"message":"Quota exceeded.","status":"RESOURCE_EXHAUSTED",
+				"details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"QUOTA_EXCEEDED"}]}}`,
+			wantCode:    ErrorCodeQuotaExceeded,
+			wantStatus:  "RESOURCE_EXHAUSTED",
+			wantMessage: "FCM error 429: Quota exceeded.",
+			retryable:   true,
+		},
+		{
+			name:       "invalid registration token",
+			statusCode: http.StatusBadRequest,
+			body: `{"error":{"code":400,"message":"The registration token is not a valid FCM registration token","status":"INVALID_ARGUMENT",
+				"details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"INVALID_ARGUMENT"}]}}`,
+			wantCode:     ErrorCodeInvalidArgument,
+			wantStatus:   "INVALID_ARGUMENT",
+			wantMessage:  "FCM error 400: The registration token is not a valid FCM registration token",
+			tokenInvalid: true,
+		},
+		{
+			name:       "invalid payload field",
+			statusCode: http.StatusBadRequest,
+			body: `{"error":{"code":400,"message":"Invalid value at 'message.android.ttl'","status":"INVALID_ARGUMENT",
+				"details":[{"@type":"type.googleapis.com/google.rpc.BadRequest","fieldViolations":[{"field":"message.android.ttl","description":"Invalid value"}]}]}}`,
+			wantCode:    ErrorCodeInvalidArgument,
+			wantStatus:  "INVALID_ARGUMENT",
+			wantMessage: "FCM error 400: Invalid value at 'message.android.ttl'",
+		},
+		{
+			name:       "token field violation without an FcmError",
+			statusCode: http.StatusBadRequest,
+			body: `{"error":{"code":400,"message":"Invalid value","status":"INVALID_ARGUMENT",
+				"details":[{"@type":"type.googleapis.com/google.rpc.BadRequest","fieldViolations":[{"field":"message.token","description":"Invalid registration token"}]}]}}`,
+			wantCode:     ErrorCodeInvalidArgument,
+			wantStatus:   "INVALID_ARGUMENT",
+			wantMessage:  "FCM error 400: Invalid value",
+			tokenInvalid: true,
+		},
+		{
+			name:       "IAM permission denied",
+			statusCode: http.StatusForbidden,
+			body: `{"error":{"code":403,"message":"Permission 'cloudmessaging.messages.create' denied on resource","status":"PERMISSION_DENIED",
+				"details":[{"@type":"type.googleapis.com/google.rpc.ErrorInfo","reason":"IAM_PERMISSION_DENIED"}]}}`,
+			wantCode:    ErrorCodeSenderIDMismatch,
+			wantStatus:  "PERMISSION_DENIED",
+			wantMessage: "FCM error 403: Permission 'cloudmessaging.messages.create' denied on resource",
+		},
+		{
+			name:        "HTML 404 from a wrong endpoint",
+			statusCode:  http.StatusNotFound,
+			body:        `<html><body>Not Found</body></html>`,
+			wantCode:    ErrorCodeUnregistered,
+			wantMessage: "FCM error 404: <html><body>Not Found</body></html>",
+		},
+		{
+			name:        "body is not a google.rpc.Status",
+			statusCode:  http.StatusServiceUnavailable,
+			body:        `upstream connect error`,
+			wantCode:    ErrorCodeUnavailable,
+			wantMessage: "FCM error 503: upstream connect error",
+			retryable:   true,
+		},
+		{
+			name:        "unknown status",
+			statusCode:  http.StatusConflict,
+			body:        `{}`,
+			wantCode:    ErrorCodeUnspecified,
+			wantMessage: "FCM error 409: {}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Execute ---
+			err := parseError(tt.statusCode, []byte(tt.body))
+
+			// --- Assert ---
+			assert.Equal(t, tt.statusCode, err.StatusCode)
+			assert.Equal(t, tt.wantCode, err.Code)
+			assert.Equal(t, tt.wantStatus, err.Status)
+			assert.Equal(t, tt.wantMessage, err.Error())
+			assert.Equal(t, tt.retryable, err.Retryable())
+			assert.Equal(t, tt.tokenInvalid, err.TokenInvalid())
+		})
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	fcmErr := parseError(http.StatusNotFound, []byte(`{"error":{"status":"NOT_FOUND"}}`))
+
+	assert.Equal(t, "", ErrorCode(nil))
+	assert.Equal(t, ErrorCodeUnregistered, ErrorCode(fcmErr))
+	assert.Equal(t, ErrorCodeUnregistered, ErrorCode(fmt.Errorf("wrapped: %w", fcmErr)))
+	assert.Equal(t, ErrorCodeUnspecified, ErrorCode(errors.New("connection refused")))
+
+	var target *Error
+	require.ErrorAs(t, fmt.Errorf("wrapped: %w", fcmErr), &target)
+	assert.False(t, target.TokenInvalid(), "a bare 404 does not prove the token invalid")
+}
+
+func TestIsRetryable(t *testing.T) {
+	ctx := context.Background()
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	assert.True(t, IsRetryable(parseError(http.StatusServiceUnavailable, nil)))
+	assert.False(t, IsRetryable(parseError(http.StatusNotFound, nil)))
+	assert.True(t, IsRetryable(fmt.Errorf("wrapped: %w", newTransportError(ctx, errors.New("connection reset")))))
+	assert.False(t, IsRetryable(newTransportError(cancelled, errors.New("context canceled"))))
+	assert.False(t, IsRetryable(errors.New("create token failed")))
+	assert.False(t, IsRetryable(nil))
+}
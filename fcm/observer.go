@@ -0,0 +1,62 @@
+package fcm
+
+import (
+	"time"
+)
+
+// Message targets reported to an Observer.
+const (
+	TargetToken     = "token"
+	TargetTopic     = "topic"
+	TargetCondition = "condition"
+)
+
+// APIs reported to Observer.RoundTrip.
+const (
+	APIMessages   = "messages"
+	APIInstanceID = "iid"
+)
+
+// Observer is told about the work a Service does, e.g. to export it as
+// metrics. Its methods are called concurrently and should return quickly.
+type Observer interface {
+	// MessageSent is called once for every message after its last attempt.
+	// target is one of the Target constants; errorCode is empty on success.
+	MessageSent(projectID, target, errorCode string)
+	// RoundTrip is called after every HTTP call to Google, with the
+	// response status, or 0 when no response arrived.
+	RoundTrip(projectID, api string, status int, d time.Duration)
+	// TokenRefreshed is called whenever an OAuth2 access token is fetched,
+	// with the error if that failed.
+	TokenRefreshed(projectID string, err error)
+}
+
+// WithObserver reports the work of the Service to o.
+func WithObserver(o Observer) Option {
+	return func(s *Service) {
+		s.observer = o
+	}
+}
+
+// InFlight returns the number of messages the Service is sending right now,
+// across every fan-out using it.
+func (s *Service) InFlight() int64 {
+	return s.inFlight.Load()
+}
+
+// ProjectID returns the ID of the Firebase project the Service sends to.
+func (s *Service) ProjectID() string {
+	return s.projectID
+}
+
+// messageTarget names what msg is addressed to.
+func messageTarget(msg Message) string {
+	switch {
+	case msg.Topic != "":
+		return TargetTopic
+	case msg.Condition != "":
+		return TargetCondition
+	default:
+		return TargetToken
+	}
+}
@@ -1,23 +1,251 @@
 package fcm
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ApnsAlert is the alert dictionary of an APNs payload.
+// See https://developer.apple.com/documentation/usernotifications/generating-a-remote-notification.
+type ApnsAlert struct {
+	Title           string   `json:"title,omitempty"`
+	Subtitle        string   `json:"subtitle,omitempty"`
+	Body            string   `json:"body,omitempty"`
+	LaunchImage     string   `json:"launch-image,omitempty"`
+	TitleLocKey     string   `json:"title-loc-key,omitempty"`
+	TitleLocArgs    []string `json:"title-loc-args,omitempty"`
+	SubtitleLocKey  string   `json:"subtitle-loc-key,omitempty"`
+	SubtitleLocArgs []string `json:"subtitle-loc-args,omitempty"`
+	LocKey          string   `json:"loc-key,omitempty"`
+	LocArgs         []string `json:"loc-args,omitempty"`
+	ActionLocKey    string   `json:"action-loc-key,omitempty"`
+}
+
+// ApnsSound is a sound file name, or a sound dictionary when Critical is set.
+// It is written as a plain string unless it describes a critical alert.
+type ApnsSound struct {
+	Name     string
+	Critical bool
+	// Volume is between 0 and 1 and only used for critical alerts.
+	Volume float64
+}
+
+type apnsSoundDict struct {
+	Critical int     `json:"critical,omitempty"`
+	Name     string  `json:"name,omitempty"`
+	Volume   float64 `json:"volume,omitempty"`
+}
+
+func (s ApnsSound) MarshalJSON() ([]byte, error) {
+	if !s.Critical {
+		return json.Marshal(s.Name)
+	}
+	return json.Marshal(apnsSoundDict{Critical: 1, Name: s.Name, Volume: s.Volume})
+}
+
+func (s *ApnsSound) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &s.Name); err == nil {
+		return nil
+	}
+	var d apnsSoundDict
+	if err := json.Unmarshal(data, &d); err != nil {
+		return fmt.Errorf("sound must be a string or a sound dictionary: %w", err)
+	}
+	*s = ApnsSound{Name: d.Name, Critical: d.Critical == 1, Volume: d.Volume}
+	return nil
+}
+
 type ApnsAps struct {
-	MutableContent int `json:"mutable-content,omitempty"`
-	// Alert          *ApnsAlert `json:"alert,omitempty"`
-	Badge int    `json:"badge,omitempty"`
-	Sound string `json:"sound,omitempty"`
+	Alert *ApnsAlert `json:"alert,omitempty"`
+	// Badge is a pointer so that 0, which clears the badge, is still sent.
+	Badge            *int       `json:"badge,omitempty"`
+	Sound            *ApnsSound `json:"sound,omitempty"`
+	ThreadID         string     `json:"thread-id,omitempty"`
+	Category         string     `json:"category,omitempty"`
+	ContentAvailable int        `json:"content-available,omitempty"`
+	MutableContent   int        `json:"mutable-content,omitempty"`
+	TargetContentID  string     `json:"target-content-id,omitempty"`
+	// InterruptionLevel is passive, active, time-sensitive or critical.
+	InterruptionLevel string `json:"interruption-level,omitempty"`
+	// RelevanceScore is between 0 and 1.
+	RelevanceScore *float64 `json:"relevance-score,omitempty"`
+	FilterCriteria string   `json:"filter-criteria,omitempty"`
+
+	// Live Activity keys.
+	Timestamp     int64          `json:"timestamp,omitempty"`
+	Event         string         `json:"event,omitempty"`
+	ContentState  map[string]any `json:"content-state,omitempty"`
+	StaleDate     int64          `json:"stale-date,omitempty"`
+	DismissalDate int64          `json:"dismissal-date,omitempty"`
 }
 
+// ApnsPayload is the APNs JSON payload. CustomData holds app-defined keys
+// that are sent next to "aps" at the top level.
 type ApnsPayload struct {
-	Aps ApnsAps `json:"aps"`
+	Aps        ApnsAps
+	CustomData map[string]any
+}
+
+func (p ApnsPayload) MarshalJSON() ([]byte, error) {
+	out := make(map[string]any, len(p.CustomData)+1)
+	for k, v := range p.CustomData {
+		out[k] = v
+	}
+	out["aps"] = p.Aps
+	return json.Marshal(out)
+}
+
+func (p *ApnsPayload) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*p = ApnsPayload{}
+	if aps, ok := raw["aps"]; ok {
+		if err := json.Unmarshal(aps, &p.Aps); err != nil {
+			return err
+		}
+		delete(raw, "aps")
+	}
+	for k, v := range raw {
+		var value any
+		if err := json.Unmarshal(v, &value); err != nil {
+			return err
+		}
+		if p.CustomData == nil {
+			p.CustomData = make(map[string]any, len(raw))
+		}
+		p.CustomData[k] = value
+	}
+	return nil
+}
+
+type ApnsFCMOptions struct {
+	AnalyticsLabel string `json:"analytics_label,omitempty"`
+	Image          string `json:"image,omitempty"`
 }
 
 type ApnsConfig struct {
-	Headers map[string]string `json:"headers,omitempty"`
-	Payload ApnsPayload       `json:"payload"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Payload    *ApnsPayload      `json:"payload,omitempty"`
+	FCMOptions *ApnsFCMOptions   `json:"fcm_options,omitempty"`
 }
 
+// AndroidConfig holds the Android-specific options of a message.
+// See https://firebase.google.com/docs/reference/fcm/rest/v1/projects.messages#androidconfig.
 type AndroidConfig struct {
+	CollapseKey string `json:"collapse_key,omitempty"`
+	// Priority is NORMAL or HIGH.
 	Priority string `json:"priority,omitempty"`
+	// TTL is a duration in seconds with up to nine fractional digits,
+	// e.g. "3.5s".
+	TTL                   string               `json:"ttl,omitempty"`
+	RestrictedPackageName string               `json:"restricted_package_name,omitempty"`
+	Data                  map[string]string    `json:"data,omitempty"`
+	Notification          *AndroidNotification `json:"notification,omitempty"`
+	FCMOptions            *AndroidFCMOptions   `json:"fcm_options,omitempty"`
+	DirectBootOK          bool                 `json:"direct_boot_ok,omitempty"`
+}
+
+type AndroidFCMOptions struct {
+	AnalyticsLabel string `json:"analytics_label,omitempty"`
+}
+
+// AndroidNotification overrides the message notification on Android devices.
+type AndroidNotification struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+	Icon  string `json:"icon,omitempty"`
+	// Color is the icon colour in #rrggbb format.
+	Color        string   `json:"color,omitempty"`
+	Sound        string   `json:"sound,omitempty"`
+	Tag          string   `json:"tag,omitempty"`
+	ClickAction  string   `json:"click_action,omitempty"`
+	BodyLocKey   string   `json:"body_loc_key,omitempty"`
+	BodyLocArgs  []string `json:"body_loc_args,omitempty"`
+	TitleLocKey  string   `json:"title_loc_key,omitempty"`
+	TitleLocArgs []string `json:"title_loc_args,omitempty"`
+	ChannelID    string   `json:"channel_id,omitempty"`
+	Ticker       string   `json:"ticker,omitempty"`
+	Sticky       bool     `json:"sticky,omitempty"`
+	// EventTime is an RFC 3339 timestamp.
+	EventTime string `json:"event_time,omitempty"`
+	LocalOnly bool   `json:"local_only,omitempty"`
+	// NotificationPriority is one of PRIORITY_MIN, PRIORITY_LOW,
+	// PRIORITY_DEFAULT, PRIORITY_HIGH or PRIORITY_MAX.
+	NotificationPriority  string `json:"notification_priority,omitempty"`
+	DefaultSound          bool   `json:"default_sound,omitempty"`
+	DefaultVibrateTimings bool   `json:"default_vibrate_timings,omitempty"`
+	DefaultLightSettings  bool   `json:"default_light_settings,omitempty"`
+	// VibrateTimings are durations in the same format as AndroidConfig.TTL.
+	VibrateTimings []string `json:"vibrate_timings,omitempty"`
+	// Visibility is PRIVATE, PUBLIC or SECRET.
+	Visibility        string         `json:"visibility,omitempty"`
+	NotificationCount *int           `json:"notification_count,omitempty"`
+	LightSettings     *LightSettings `json:"light_settings,omitempty"`
+	Image             string         `json:"image,omitempty"`
+}
+
+// LightSettings controls the notification LED. Durations use the same format
+// as AndroidConfig.TTL.
+type LightSettings struct {
+	Color            Color  `json:"color"`
+	LightOnDuration  string `json:"light_on_duration"`
+	LightOffDuration string `json:"light_off_duration"`
+}
+
+// Color is an RGBA colour with components between 0 and 1.
+type Color struct {
+	Red   float64  `json:"red"`
+	Green float64  `json:"green"`
+	Blue  float64  `json:"blue"`
+	Alpha *float64 `json:"alpha,omitempty"`
+}
+
+// WebpushConfig holds the Web Push options of a message.
+// See https://firebase.google.com/docs/reference/fcm/rest/v1/projects.messages#webpushconfig.
+type WebpushConfig struct {
+	// Headers are Web Push protocol headers such as TTL, Urgency and Topic.
+	Headers      map[string]string    `json:"headers,omitempty"`
+	Data         map[string]string    `json:"data,omitempty"`
+	Notification *WebpushNotification `json:"notification,omitempty"`
+	FCMOptions   *WebpushFCMOptions   `json:"fcm_options,omitempty"`
+}
+
+type WebpushFCMOptions struct {
+	// Link is opened when the user clicks the notification. It must use HTTPS.
+	Link           string `json:"link,omitempty"`
+	AnalyticsLabel string `json:"analytics_label,omitempty"`
+}
+
+// WebpushNotification mirrors the options of the browser Notification API,
+// which is why its JSON names are camelCase.
+type WebpushNotification struct {
+	Title   string                      `json:"title,omitempty"`
+	Body    string                      `json:"body,omitempty"`
+	Icon    string                      `json:"icon,omitempty"`
+	Badge   string                      `json:"badge,omitempty"`
+	Image   string                      `json:"image,omitempty"`
+	Lang    string                      `json:"lang,omitempty"`
+	Tag     string                      `json:"tag,omitempty"`
+	Actions []WebpushNotificationAction `json:"actions,omitempty"`
+	// Direction is auto, ltr or rtl.
+	Direction          string `json:"dir,omitempty"`
+	Renotify           bool   `json:"renotify,omitempty"`
+	RequireInteraction bool   `json:"requireInteraction,omitempty"`
+	Silent             bool   `json:"silent,omitempty"`
+	// Timestamp is in milliseconds since the Unix epoch.
+	Timestamp int64 `json:"timestamp,omitempty"`
+	// Vibrate is a vibration pattern in milliseconds.
+	Vibrate []int `json:"vibrate,omitempty"`
+	Data    any   `json:"data,omitempty"`
+}
+
+type WebpushNotificationAction struct {
+	Action string `json:"action"`
+	Title  string `json:"title"`
+	Icon   string `json:"icon,omitempty"`
 }
 
 type Notification struct {
@@ -27,27 +255,22 @@ type Notification struct {
 	Image string `json:"image"`
 }
 
+// Message is an FCM v1 message. Exactly one of Token, Topic and Condition
+// selects the target; Service fills it in from the method being called.
 type Message struct {
-	Token        string        `json:"token"`
-	Notification Notification  `json:"notification"`
-	Android      AndroidConfig `json:"android,omitempty"`
-	Apns         ApnsConfig    `json:"apns,omitempty"`
-}
-
-type FCMRequest struct {
-	Message Message `json:"message"`
-}
-
-// ### Here all broadcast ###
-
-type BroadcastMessage struct {
-	Condition    string            `json:"condition"`
-	Notification Notification      `json:"notification"`
+	Token     string `json:"token,omitempty"`
+	Topic     string `json:"topic,omitempty"`
+	Condition string `json:"condition,omitempty"`
+	// Notification is nil for data-only messages.
+	Notification *Notification     `json:"notification,omitempty"`
 	Data         map[string]string `json:"data,omitempty"`
-	Android      AndroidConfig     `json:"android,omitempty"`
-	Apns         ApnsConfig        `json:"apns,omitempty"`
+	Android      *AndroidConfig    `json:"android,omitempty"`
+	Apns         *ApnsConfig       `json:"apns,omitempty"`
+	Webpush      *WebpushConfig    `json:"webpush,omitempty"`
 }
 
-type FCMBroadcastRequest struct {
-	Message BroadcastMessage `json:"message"`
+type FCMRequest struct {
+	// ValidateOnly asks FCM to validate the message without delivering it.
+	ValidateOnly bool    `json:"validate_only,omitempty"`
+	Message      Message `json:"message"`
 }
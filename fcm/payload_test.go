@@ -0,0 +1,73 @@
+package fcm
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApnsPayload_JSON(t *testing.T) {
+	t.Run("success - badge 0 and custom keys are sent", func(t *testing.T) {
+		// --- Setup ---
+		badge := 0
+		payload := ApnsPayload{
+			Aps: ApnsAps{
+				Alert:             &ApnsAlert{LocKey: "NEW_MESSAGE", LocArgs: []string{"Sari"}, Subtitle: "Chat"},
+				Badge:             &badge,
+				Sound:             &ApnsSound{Name: "default"},
+				ThreadID:          "chat-42",
+				InterruptionLevel: "time-sensitive",
+			},
+			CustomData: map[string]any{"conversation_id": "42"},
+		}
+
+		// --- Execute ---
+		raw, err := json.Marshal(payload)
+
+		// --- Assert ---
+		require.NoError(t, err)
+		assert.JSONEq(t, `{
+			"aps": {
+				"alert": {"subtitle": "Chat", "loc-key": "NEW_MESSAGE", "loc-args": ["Sari"]},
+				"badge": 0,
+				"sound": "default",
+				"thread-id": "chat-42",
+				"interruption-level": "time-sensitive"
+			},
+			"conversation_id": "42"
+		}`, string(raw))
+	})
+
+	t.Run("success - unset badge is left out", func(t *testing.T) {
+		raw, err := json.Marshal(ApnsPayload{})
+
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"aps": {}}`, string(raw))
+	})
+
+	t.Run("success - round trip keeps custom keys and critical sound", func(t *testing.T) {
+		// --- Setup ---
+		in := `{"aps":{"sound":{"critical":1,"name":"alarm.caf","volume":0.8},"content-available":1},"meta":{"id":7}}`
+
+		// --- Execute ---
+		var payload ApnsPayload
+		require.NoError(t, json.Unmarshal([]byte(in), &payload))
+		out, err := json.Marshal(payload)
+
+		// --- Assert ---
+		require.NoError(t, err)
+		assert.Equal(t, &ApnsSound{Name: "alarm.caf", Critical: true, Volume: 0.8}, payload.Aps.Sound)
+		assert.Equal(t, 1, payload.Aps.ContentAvailable)
+		assert.Equal(t, map[string]any{"meta": map[string]any{"id": float64(7)}}, payload.CustomData)
+		assert.JSONEq(t, in, string(out))
+	})
+
+	t.Run("error - sound of the wrong type", func(t *testing.T) {
+		var payload ApnsPayload
+		err := json.Unmarshal([]byte(`{"aps":{"sound":42}}`), &payload)
+
+		assert.ErrorContains(t, err, "sound must be a string or a sound dictionary")
+	})
+}
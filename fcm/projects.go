@@ -0,0 +1,112 @@
+package fcm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrUnknownProject is returned for a project name that was not
+	// configured.
+	ErrUnknownProject = errors.New("unknown project")
+	// ErrNoProject is returned when no project was named and there is no
+	// default one.
+	ErrNoProject = errors.New("no project selected")
+)
+
+// Projects holds one Service per Firebase project the gateway serves.
+// Project names are case-insensitive.
+type Projects struct {
+	services    map[string]*Service
+	defaultName string
+}
+
+// NewProjects returns the Projects made of services, keyed by name.
+// defaultName is used when a caller names no project and may be empty.
+func NewProjects(defaultName string, services map[string]*Service) (*Projects, error) {
+	p := &Projects{services: make(map[string]*Service, len(services)), defaultName: strings.ToLower(defaultName)}
+	for name, s := range services {
+		p.services[strings.ToLower(name)] = s
+	}
+	if len(p.services) == 0 {
+		return nil, errors.New("at least one project is required")
+	}
+	if _, ok := p.services[p.defaultName]; p.defaultName != "" && !ok {
+		return nil, fmt.Errorf("default project %q: %w", defaultName, ErrUnknownProject)
+	}
+	return p, nil
+}
+
+// SingleProject wraps s as the only, and default, project.
+func SingleProject(name string, s *Service) *Projects {
+	name = strings.ToLower(name)
+	return &Projects{services: map[string]*Service{name: s}, defaultName: name}
+}
+
+// Default returns the name of the default project, or "" if there is none.
+func (p *Projects) Default() string {
+	return p.defaultName
+}
+
+// Names returns the configured project names in sorted order.
+func (p *Projects) Names() []string {
+	names := make([]string, 0, len(p.services))
+	for name := range p.services {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Resolve returns the canonical name and Service of the named project, or of
+// the default project when name is empty.
+func (p *Projects) Resolve(name string) (string, *Service, error) {
+	name = strings.ToLower(name)
+	if name == "" {
+		if p.defaultName == "" {
+			return "", nil, ErrNoProject
+		}
+		name = p.defaultName
+	}
+	s, ok := p.services[name]
+	if !ok {
+		return "", nil, fmt.Errorf("%w: %s", ErrUnknownProject, name)
+	}
+	return name, s, nil
+}
+
+// SendNotification sends through the project named in ctx (see WithProject).
+func (p *Projects) SendNotification(ctx context.Context, token string, msg Message, validateOnly bool) (SendResult, error) {
+	_, s, err := p.Resolve(ProjectFromContext(ctx))
+	if err != nil {
+		return SendResult{}, err
+	}
+	return s.SendNotification(ctx, token, msg, validateOnly)
+}
+
+// BroadcastNotification sends through the project named in ctx (see
+// WithProject).
+func (p *Projects) BroadcastNotification(ctx context.Context, condition string, msg Message, validateOnly bool) (SendResult, error) {
+	_, s, err := p.Resolve(ProjectFromContext(ctx))
+	if err != nil {
+		return SendResult{}, err
+	}
+	return s.BroadcastNotification(ctx, condition, msg, validateOnly)
+}
+
+type projectKey struct{}
+
+// WithProject returns a copy of ctx that makes Projects send through the
+// named project. An empty name selects the default project.
+func WithProject(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, projectKey{}, name)
+}
+
+// ProjectFromContext returns the project name set by WithProject.
+func ProjectFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(projectKey{}).(string)
+	return name
+}
@@ -0,0 +1,62 @@
+package fcm
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProjects(t *testing.T) {
+	t.Run("success - sends through the project named in the context", func(t *testing.T) {
+		// --- Setup ---
+		var sentBy []string
+		newProject := func(name string) *Service {
+			return newMockService(t, func(w http.ResponseWriter, r *http.Request) {
+				sentBy = append(sentBy, name)
+				_, _ = w.Write([]byte(`{"name":"projects/` + name + `/messages/1"}`))
+			})
+		}
+		projects, err := NewProjects("Brand-A", map[string]*Service{
+			"brand-a": newProject("brand-a"),
+			"Brand-B": newProject("brand-b"),
+		})
+		require.NoError(t, err)
+
+		// --- Execute ---
+		_, err = projects.SendNotification(context.Background(), "t", Message{}, false)
+		require.NoError(t, err)
+		_, err = projects.SendNotification(WithProject(context.Background(), "BRAND-B"), "t", Message{}, false)
+		require.NoError(t, err)
+		_, err = projects.BroadcastNotification(WithProject(context.Background(), "brand-c"), "'a' in topics", Message{}, false)
+
+		// --- Assert ---
+		assert.ErrorIs(t, err, ErrUnknownProject)
+		assert.Equal(t, []string{"brand-a", "brand-b"}, sentBy)
+		assert.Equal(t, "brand-a", projects.Default())
+		assert.Equal(t, []string{"brand-a", "brand-b"}, projects.Names())
+	})
+
+	t.Run("error - no default project", func(t *testing.T) {
+		projects, err := NewProjects("", map[string]*Service{"a": {}, "b": {}})
+		require.NoError(t, err)
+
+		_, _, err = projects.Resolve("")
+
+		assert.ErrorIs(t, err, ErrNoProject)
+	})
+
+	t.Run("error - unknown default project", func(t *testing.T) {
+		_, err := NewProjects("c", map[string]*Service{"a": {}})
+
+		assert.ErrorIs(t, err, ErrUnknownProject)
+	})
+
+	t.Run("error - no projects", func(t *testing.T) {
+		_, err := NewProjects("", nil)
+
+		assert.ErrorContains(t, err, "at least one project is required")
+	})
+}
@@ -0,0 +1,76 @@
+package fcm
+
+import (
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how Service resends messages that failed with a
+// transient error (429, 5xx or a network failure).
+type RetryPolicy struct {
+	// MaxAttempts is the total number of sends, including the first one.
+	// Values below 1 disable retries.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry. It doubles on every
+	// further retry up to MaxDelay, or without a cap when MaxDelay is 0.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter randomises each delay by up to this fraction in either
+	// direction, e.g. 0.2 for ±20%.
+	Jitter float64
+}
+
+// DefaultRetryPolicy returns the policy used when NewService is not given
+// WithRetryPolicy.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    10 * time.Second,
+		Jitter:      0.2,
+	}
+}
+
+func (p RetryPolicy) attempts() int {
+	return max(1, p.MaxAttempts)
+}
+
+// delay returns how long to wait after the given failed attempt (1-based).
+// A Retry-After hint from FCM wins over a shorter computed delay. ok is false
+// when the hint is longer than MaxDelay, so the caller gives up instead of
+// waiting that long.
+func (p RetryPolicy) delay(attempt int, retryAfter time.Duration) (d time.Duration, ok bool) {
+	if p.MaxDelay > 0 && retryAfter > p.MaxDelay {
+		return 0, false
+	}
+	d = p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay) && d <= math.MaxInt64/2; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 {
+		d = min(d, p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		d = time.Duration(float64(d) * (1 + p.Jitter*(2*rand.Float64()-1)))
+	}
+	return max(d, retryAfter), true
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an
+// HTTP date.
+func parseRetryAfter(h http.Header, now time.Time) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return max(0, time.Duration(secs)*time.Second)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(0, t.Sub(now))
+	}
+	return 0
+}
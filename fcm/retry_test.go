@@ -0,0 +1,94 @@
+package fcm
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy_delay(t *testing.T) {
+	delay := func(p RetryPolicy, attempt int, retryAfter time.Duration) time.Duration {
+		d, ok := p.delay(attempt, retryAfter)
+		require.True(t, ok)
+		return d
+	}
+
+	t.Run("success - doubles up to max delay", func(t *testing.T) {
+		p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+		assert.Equal(t, time.Second, delay(p, 1, 0))
+		assert.Equal(t, 2*time.Second, delay(p, 2, 0))
+		assert.Equal(t, 4*time.Second, delay(p, 3, 0))
+		assert.Equal(t, 5*time.Second, delay(p, 4, 0))
+		assert.Equal(t, 5*time.Second, delay(p, 40, 0))
+	})
+
+	t.Run("success - doubles without a cap when max delay is 0", func(t *testing.T) {
+		p := RetryPolicy{BaseDelay: time.Second}
+
+		assert.Equal(t, time.Second, delay(p, 1, 0))
+		assert.Equal(t, 2*time.Second, delay(p, 2, 0))
+		assert.Equal(t, 8*time.Second, delay(p, 4, 0))
+		assert.Positive(t, delay(p, 100, 0), "does not overflow")
+	})
+
+	t.Run("success - retry-after wins over a shorter delay", func(t *testing.T) {
+		p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+		assert.Equal(t, 4*time.Second, delay(p, 1, 4*time.Second))
+		assert.Equal(t, 2*time.Second, delay(p, 2, time.Millisecond))
+		assert.Equal(t, time.Minute, delay(RetryPolicy{BaseDelay: time.Second}, 1, time.Minute))
+	})
+
+	t.Run("error - retry-after above max delay gives up", func(t *testing.T) {
+		p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+		_, ok := p.delay(1, 6*time.Second)
+
+		assert.False(t, ok)
+	})
+
+	t.Run("success - jitter stays within bounds", func(t *testing.T) {
+		p := RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Second, Jitter: 0.5}
+
+		for range 100 {
+			d := delay(p, 1, 0)
+			assert.GreaterOrEqual(t, d, 500*time.Millisecond)
+			assert.LessOrEqual(t, d, 1500*time.Millisecond)
+		}
+	})
+
+	t.Run("success - non-positive max attempts means a single attempt", func(t *testing.T) {
+		assert.Equal(t, 1, RetryPolicy{}.attempts())
+		assert.Equal(t, 3, RetryPolicy{MaxAttempts: 3}.attempts())
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{name: "missing", value: "", want: 0},
+		{name: "seconds", value: "120", want: 2 * time.Minute},
+		{name: "http date", value: now.Add(90 * time.Second).Format(http.TimeFormat), want: 90 * time.Second},
+		{name: "date in the past", value: now.Add(-time.Hour).Format(http.TimeFormat), want: 0},
+		{name: "garbage", value: "soon", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			if tt.value != "" {
+				h.Set("Retry-After", tt.value)
+			}
+			assert.Equal(t, tt.want, parseRetryAfter(h, now))
+		})
+	}
+}
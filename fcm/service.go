@@ -4,12 +4,22 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/wirsal/fcm-gateway/internal/wait"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"golang.org/x/time/rate"
 )
 
 type Service struct {
@@ -17,105 +27,368 @@ type Service struct {
 	projectID   string
 	endpointURL string
 	httpClient  *http.Client
+	retry       RetryPolicy
+	dryRun      bool
+	// iidURL is the base URL of the Instance ID API used for topic
+	// subscriptions.
+	iidURL string
+	sleep  func(ctx context.Context, d time.Duration) error
+	// onInvalidToken is called when FCM rejects a device token for good.
+	onInvalidToken func(token string, err *Error)
+	// limiter caps the messages posted to FCM; nil means no limit.
+	limiter      *rate.Limiter
+	throttled    atomic.Uint64
+	throttledFor atomic.Int64
+	// observer, when set, is told about sends, round trips and token
+	// refreshes.
+	observer Observer
+	inFlight atomic.Int64
+	// tracer, when set, records spans of sends and calls to Google.
+	tracer trace.Tracer
+	// token caches the access token, see accessToken.
+	tokenMu sync.Mutex
+	token   *oauth2.Token
 }
 
-func NewService(ctx context.Context, credentialsFile string, scopes []string, endpointURL string) (*Service, error) {
+// Option configures optional Service behaviour.
+type Option func(*Service)
+
+// WithRetryPolicy replaces DefaultRetryPolicy.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(s *Service) {
+		s.retry = p
+	}
+}
+
+// WithDryRun makes every send validate-only, regardless of what callers ask
+// for. It is meant for staging environments.
+func WithDryRun(dryRun bool) Option {
+	return func(s *Service) {
+		s.dryRun = dryRun
+	}
+}
+
+// WithInvalidTokenHandler registers fn to be called whenever FCM reports a
+// device token as permanently invalid, e.g. UNREGISTERED.
+func WithInvalidTokenHandler(fn func(token string, err *Error)) Option {
+	return func(s *Service) {
+		s.onInvalidToken = fn
+	}
+}
+
+// WithIIDURL replaces DefaultIIDURL, e.g. to point topic subscriptions at a
+// local stub.
+func WithIIDURL(url string) Option {
+	return func(s *Service) {
+		if url != "" {
+			s.iidURL = url
+		}
+	}
+}
+
+// WithRateLimit caps the messages posted to FCM at perSecond on average,
+// with bursts of up to burst, to stay within the project's quota. Sends over
+// the limit wait for their turn. perSecond of 0 or less means no limit; a
+// burst below 1 defaults to perSecond rounded up.
+func WithRateLimit(perSecond float64, burst int) Option {
+	return func(s *Service) {
+		if perSecond <= 0 {
+			s.limiter = nil
+			return
+		}
+		if burst < 1 {
+			burst = max(1, int(math.Ceil(perSecond)))
+		}
+		s.limiter = rate.NewLimiter(rate.Limit(perSecond), burst)
+	}
+}
+
+// RateLimitStats describes the outbound rate limit of a Service.
+type RateLimitStats struct {
+	// Limit is the configured messages per second, 0 when unlimited.
+	Limit float64
+	Burst int
+	// Throttled counts the sends that had to wait, ThrottledFor how long
+	// they waited in total.
+	Throttled    uint64
+	ThrottledFor time.Duration
+}
+
+// RateLimitStats returns the state of the limit set with WithRateLimit.
+func (s *Service) RateLimitStats() RateLimitStats {
+	stats := RateLimitStats{Throttled: s.throttled.Load(), ThrottledFor: time.Duration(s.throttledFor.Load())}
+	if s.limiter != nil {
+		stats.Limit = float64(s.limiter.Limit())
+		stats.Burst = s.limiter.Burst()
+	}
+	return stats
+}
+
+// SendResult describes the outcome of a send. It is returned alongside an
+// error as well, so callers can always see how many attempts were made.
+type SendResult struct {
+	// Name is the message ID FCM assigned, e.g. projects/p/messages/123.
+	Name string `json:"name"`
+	// Attempts is how many times the message was posted to FCM.
+	Attempts int `json:"-"`
+	// ValidateOnly is set when FCM only validated the message and nothing
+	// was delivered.
+	ValidateOnly bool `json:"-"`
+}
+
+// TokenResult is the outcome of sending to a single device token, in the
+// form the gateway reports it to its callers.
+type TokenResult struct {
+	Token string `json:"token"`
+	// MessageName is the ID FCM assigned to the message on success.
+	MessageName string `json:"message_name,omitempty"`
+	Attempts    int    `json:"attempts"`
+	Error       string `json:"error,omitempty"`
+	ErrorCode   string `json:"error_code,omitempty"`
+	// ValidateOnly marks results of a dry run, where FCM only validated the
+	// message and nothing was delivered.
+	ValidateOnly bool `json:"validate_only,omitempty"`
+}
+
+// ErrorCodeNotAttempted marks a token the gateway gave up on before sending
+// to it, because the request was cancelled or the gateway shut down. Unlike
+// the other error codes it does not come from FCM.
+const ErrorCodeNotAttempted = "NOT_ATTEMPTED"
+
+// NotAttempted returns the result of a token that was skipped because of
+// err, typically the error of a cancelled context.
+func NotAttempted(token string, err error, validateOnly bool) TokenResult {
+	return TokenResult{
+		Token:        token,
+		Error:        "not sent: " + err.Error(),
+		ErrorCode:    ErrorCodeNotAttempted,
+		ValidateOnly: validateOnly,
+	}
+}
+
+// NewTokenResult combines what SendNotification returned for token.
+func NewTokenResult(token string, res SendResult, err error) TokenResult {
+	r := TokenResult{Token: token, MessageName: res.Name, Attempts: res.Attempts, ValidateOnly: res.ValidateOnly}
+	if err != nil {
+		r.Error = err.Error()
+		r.ErrorCode = ErrorCode(err)
+	}
+	return r
+}
+
+func NewService(ctx context.Context, credentialsFile string, scopes []string, endpointURL string, opts ...Option) (*Service, error) {
 	data, err := os.ReadFile(credentialsFile)
 	if err != nil {
-		return nil, fmt.Errorf("error read credentials file")
+		return nil, fmt.Errorf("read credentials file: %w", err)
 	}
 
 	creds, err := google.CredentialsFromJSON(ctx, data, scopes...)
 	if err != nil {
-		return nil, fmt.Errorf("gagal load credentials: %w", err)
+		return nil, fmt.Errorf("load credentials: %w", err)
 	}
 
-	return &Service{
+	s := &Service{
 		creds:       creds,
 		projectID:   creds.ProjectID,
 		endpointURL: endpointURL,
 		httpClient:  &http.Client{},
-	}, nil
-}
-
-func (s *Service) SendNotification(
-	token string,
-	notification Notification,
-	androidPriority string,
-	apnsHeaders map[string]string,
-	apnsPayload ApnsPayload,
-) (string, error) {
-	reqBody := FCMRequest{
-		Message: Message{
-			Token:        token,
-			Notification: notification,
-			Android:      AndroidConfig{Priority: androidPriority},
-			Apns: ApnsConfig{
-				Headers: apnsHeaders,
-				Payload: apnsPayload},
-		},
-	}
-	return sendToFirebase(s, reqBody)
-}
-
-func (s *Service) BroadcastNotification(
-	condition string,
-	notification Notification,
-	data map[string]string, // Argument baru
-	androidPriority string,
-	apnsHeaders map[string]string,
-	apnsPayload ApnsPayload,
-) (string, error) {
-	reqBody := FCMBroadcastRequest{ // Menggunakan struct request yang baru
-		Message: BroadcastMessage{
-			Condition:    condition,
-			Notification: notification,
-			Data:         data, // Sertakan Data
-			Android:      AndroidConfig{Priority: androidPriority},
-			Apns: ApnsConfig{
-				Headers: apnsHeaders,
-				Payload: apnsPayload,
-			},
-		},
-	}
-
-	return sendToFirebase(s, reqBody)
-}
-
-func sendToFirebase(s *Service, reqBody interface{}) (string, error) {
-	tok, err := s.creds.TokenSource.Token()
+		retry:       DefaultRetryPolicy(),
+		iidURL:      DefaultIIDURL,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+// DryRun reports whether the service was configured to validate every
+// message without delivering it.
+func (s *Service) DryRun() bool {
+	return s.dryRun
+}
+
+// SendNotification sends msg to a single device registration token. When
+// validateOnly is set FCM checks the message and token without delivering it.
+func (s *Service) SendNotification(ctx context.Context, token string, msg Message, validateOnly bool) (SendResult, error) {
+	msg.Token = token
+	res, err := sendToFirebase(ctx, s, FCMRequest{ValidateOnly: validateOnly, Message: msg})
+
+	var fcmErr *Error
+	if s.onInvalidToken != nil && errors.As(err, &fcmErr) && fcmErr.TokenInvalid() {
+		s.onInvalidToken(token, fcmErr)
+	}
+	return res, err
+}
+
+// BroadcastNotification sends msg to every device matching a topic condition
+// such as "'news' in topics && 'sports' in topics".
+func (s *Service) BroadcastNotification(ctx context.Context, condition string, msg Message, validateOnly bool) (SendResult, error) {
+	msg.Condition = condition
+	return sendToFirebase(ctx, s, FCMRequest{ValidateOnly: validateOnly, Message: msg})
+}
+
+// SendToTopic sends msg to every device subscribed to topic.
+func (s *Service) SendToTopic(ctx context.Context, topic string, msg Message, validateOnly bool) (SendResult, error) {
+	msg.Topic = topic
+	return sendToFirebase(ctx, s, FCMRequest{ValidateOnly: validateOnly, Message: msg})
+}
+
+// sendToFirebase posts reqBody to FCM, retrying transient failures according
+// to s.retry.
+func sendToFirebase(ctx context.Context, s *Service, reqBody FCMRequest) (res SendResult, err error) {
+	reqBody.ValidateOnly = reqBody.ValidateOnly || s.dryRun
+	target := messageTarget(reqBody.Message)
+	ctx, span := s.startSpan(ctx, "fcm.send", trace.WithAttributes(
+		AttrProjectID.String(s.projectID),
+		AttrTarget.String(target),
+		AttrValidateOnly.Bool(reqBody.ValidateOnly),
+	))
+	s.inFlight.Add(1)
+	defer func() {
+		s.inFlight.Add(-1)
+		span.SetAttributes(AttrAttempts.Int(res.Attempts))
+		if res.Name != "" {
+			span.SetAttributes(AttrMessageName.String(res.Name))
+		}
+		endSpan(span, err)
+		if s.observer != nil {
+			code := ""
+			if err != nil {
+				code = ErrorCode(err)
+			}
+			s.observer.MessageSent(s.projectID, target, code)
+		}
+	}()
+
+	result := SendResult{ValidateOnly: reqBody.ValidateOnly}
+
+	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("create token failed")
+		return result, fmt.Errorf("marshal request body: %w", err)
 	}
+
 	url := fmt.Sprintf(s.endpointURL, s.projectID)
+	result.Attempts, err = s.withRetry(ctx, func() error {
+		if err := s.waitForRateLimit(ctx); err != nil {
+			return err
+		}
+		body, err := s.post(ctx, APIMessages, url, nil, jsonData)
+		if err != nil {
+			return err
+		}
+		// FCM already accepted the message, so an unreadable body only
+		// costs us the message name.
+		_ = json.Unmarshal(body, &result)
+		return nil
+	})
+	return result, err
+}
 
-	jsonData, err := json.Marshal(reqBody)
+// waitForRateLimit blocks until s.limiter lets another message through.
+func (s *Service) waitForRateLimit(ctx context.Context) error {
+	if s.limiter == nil {
+		return nil
+	}
+	r := s.limiter.Reserve()
+	delay := r.Delay()
+	if delay == 0 {
+		return nil
+	}
+	s.throttled.Add(1)
+	s.throttledFor.Add(int64(delay))
+	if err := s.sleepFunc()(ctx, delay); err != nil {
+		r.Cancel()
+		return fmt.Errorf("waiting for the FCM rate limit: %w", err)
+	}
+	return nil
+}
+
+// withRetry calls attempt until it succeeds, fails permanently, s.retry is
+// exhausted or FCM asks to wait longer than its MaxDelay, and returns how many
+// times it was called.
+func (s *Service) withRetry(ctx context.Context, attempt func() error) (int, error) {
+	sleep := s.sleepFunc()
+	for attempts := 1; ; attempts++ {
+		err := attempt()
+		if err == nil {
+			return attempts, nil
+		}
+		if !IsRetryable(err) || attempts >= s.retry.attempts() {
+			return attempts, err
+		}
+
+		var retryAfter time.Duration
+		var fcmErr *Error
+		if errors.As(err, &fcmErr) {
+			retryAfter = fcmErr.RetryAfter
+		}
+		delay, ok := s.retry.delay(attempts, retryAfter)
+		if !ok {
+			return attempts, err
+		}
+		if sleepErr := sleep(ctx, delay); sleepErr != nil {
+			return attempts, fmt.Errorf("%w (retry aborted: %v)", err, sleepErr)
+		}
+	}
+}
+
+func (s *Service) sleepFunc() func(ctx context.Context, d time.Duration) error {
+	if s.sleep == nil {
+		return wait.Sleep
+	}
+	return s.sleep
+}
+
+// post makes a single authorised POST of jsonData to url of api and returns
+// the body of a 200 response.
+func (s *Service) post(ctx context.Context, api, url string, header http.Header, jsonData []byte) (_ []byte, err error) {
+	tok, err := s.accessToken(ctx)
 	if err != nil {
-		return "", fmt.Errorf("gagal marshal request body: %w", err)
+		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	ctx, span := s.startSpan(ctx, "POST "+api, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		AttrProjectID.String(s.projectID),
+		attribute.String("http.request.method", http.MethodPost),
+		attribute.String("url.full", url),
+	))
+	defer func() { endSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonData))
 	if err != nil {
-		return "", fmt.Errorf("failed http request %w", err)
+		return nil, fmt.Errorf("failed http request %w", err)
 	}
 
+	for k, v := range header {
+		req.Header[k] = v
+	}
 	req.Header.Set("Authorization", "Bearer "+tok.AccessToken)
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	start := time.Now()
+	resp, err := s.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("error kirim request: %w", err)
+		s.observeRoundTrip(api, 0, start)
+		return nil, newTransportError(ctx, fmt.Errorf("send request: %w", err))
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
+	s.observeRoundTrip(api, resp.StatusCode, start)
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 	if err != nil {
-		return "", fmt.Errorf("gagal baca response body: %w", err)
+		return nil, newTransportError(ctx, fmt.Errorf("read response body: %w", err))
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("FCM error %d: %s", resp.StatusCode, string(body))
+		fcmErr := parseError(resp.StatusCode, body)
+		fcmErr.RetryAfter = parseRetryAfter(resp.Header, time.Now())
+		return nil, fcmErr
 	}
+	return body, nil
+}
 
-	return string(body), nil
+func (s *Service) observeRoundTrip(api string, status int, start time.Time) {
+	if s.observer != nil {
+		s.observer.RoundTrip(s.projectID, api, status, time.Since(start))
+	}
 }
@@ -0,0 +1,109 @@
+package fcm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DefaultIIDURL is the base URL of the Instance ID API, which manages topic
+// subscriptions.
+const DefaultIIDURL = "https://iid.googleapis.com"
+
+// MaxTopicBatch is the most tokens the Instance ID API accepts in one
+// batchAdd or batchRemove call. Longer lists are split into several calls.
+const MaxTopicBatch = 1000
+
+// TopicResult is the outcome of subscribing or unsubscribing one token.
+type TopicResult struct {
+	Token string `json:"token"`
+	Error string `json:"error,omitempty"`
+	// ErrorCode is the Instance ID error for the token, e.g. NOT_FOUND or
+	// INVALID_ARGUMENT, or the FCM error code when the whole batch failed.
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+type topicBatchRequest struct {
+	To                 string   `json:"to"`
+	RegistrationTokens []string `json:"registration_tokens"`
+}
+
+type topicBatchResponse struct {
+	Results []struct {
+		Error string `json:"error,omitempty"`
+	} `json:"results"`
+}
+
+// SubscribeToTopic subscribes tokens to topic. It returns one result per
+// token, in order; the error is only set for an invalid topic or token list.
+func (s *Service) SubscribeToTopic(ctx context.Context, topic string, tokens []string) ([]TopicResult, error) {
+	return s.manageTopic(ctx, "batchAdd", topic, tokens)
+}
+
+// UnsubscribeFromTopic removes tokens from topic. Results are reported as by
+// SubscribeToTopic.
+func (s *Service) UnsubscribeFromTopic(ctx context.Context, topic string, tokens []string) ([]TopicResult, error) {
+	return s.manageTopic(ctx, "batchRemove", topic, tokens)
+}
+
+func (s *Service) manageTopic(ctx context.Context, op, topic string, tokens []string) ([]TopicResult, error) {
+	name, err := NormalizeTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("tokens list cannot be empty")
+	}
+
+	url := strings.TrimSuffix(s.iidURL, "/") + "/iid/v1:" + op
+	results := make([]TopicResult, 0, len(tokens))
+	for start := 0; start < len(tokens); start += MaxTopicBatch {
+		batch := tokens[start:min(start+MaxTopicBatch, len(tokens))]
+		batchResults, err := s.postTopicBatch(ctx, url, name, batch)
+		if err != nil {
+			batchResults = make([]TopicResult, len(batch))
+			for i, token := range batch {
+				batchResults[i] = TopicResult{Token: token, Error: err.Error(), ErrorCode: ErrorCode(err)}
+			}
+		}
+		results = append(results, batchResults...)
+	}
+	return results, nil
+}
+
+// postTopicBatch makes one batch call for at most MaxTopicBatch tokens,
+// retrying transient failures according to s.retry.
+func (s *Service) postTopicBatch(ctx context.Context, url, topic string, tokens []string) ([]TopicResult, error) {
+	jsonData, err := json.Marshal(topicBatchRequest{To: "/topics/" + topic, RegistrationTokens: tokens})
+	if err != nil {
+		return nil, fmt.Errorf("marshal topic request: %w", err)
+	}
+	// Tells the Instance ID API to accept an OAuth2 access token instead of
+	// a legacy server key.
+	header := http.Header{}
+	header.Set("access_token_auth", "true")
+
+	var resp topicBatchResponse
+	_, err = s.withRetry(ctx, func() error {
+		body, err := s.post(ctx, APIInstanceID, url, header, jsonData)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(body, &resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Results) != len(tokens) {
+		return nil, fmt.Errorf("instance ID API returned %d results for %d tokens", len(resp.Results), len(tokens))
+	}
+
+	results := make([]TopicResult, len(tokens))
+	for i, token := range tokens {
+		results[i] = TopicResult{Token: token, Error: resp.Results[i].Error, ErrorCode: resp.Results[i].Error}
+	}
+	return results, nil
+}
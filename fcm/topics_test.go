@@ -0,0 +1,153 @@
+package fcm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTopicService returns a Service whose Instance ID calls are served by
+// iidHandler.
+func newTopicService(t *testing.T, iidHandler http.HandlerFunc) *Service {
+	t.Helper()
+	service := newMockService(t, nil, WithIIDURL("https://iid.test"))
+	service.httpClient.Transport.(*mockRoundTripper).handlers["https://iid.test/"] = iidHandler
+	return service
+}
+
+func readTopicBatch(t *testing.T, r *http.Request) topicBatchRequest {
+	t.Helper()
+	var req topicBatchRequest
+	require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+	return req
+}
+
+func TestService_SubscribeToTopic(t *testing.T) {
+	t.Run("success - splits tokens into batches and reports per-token errors", func(t *testing.T) {
+		// --- Setup ---
+		tokens := make([]string, 2500)
+		for i := range tokens {
+			tokens[i] = fmt.Sprintf("token-%d", i)
+		}
+		tokens[1500] = "stale"
+		var batchSizes []int
+		service := newTopicService(t, func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/iid/v1:batchAdd", r.URL.Path)
+			assert.Equal(t, "Bearer mock-access-token", r.Header.Get("Authorization"))
+			assert.Equal(t, "true", r.Header.Get("access_token_auth"))
+			req := readTopicBatch(t, r)
+			assert.Equal(t, "/topics/news", req.To)
+			batchSizes = append(batchSizes, len(req.RegistrationTokens))
+
+			results := make([]map[string]string, len(req.RegistrationTokens))
+			for i, token := range req.RegistrationTokens {
+				results[i] = map[string]string{}
+				if token == "stale" {
+					results[i]["error"] = "NOT_FOUND"
+				}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"results": results})
+		})
+
+		// --- Execute ---
+		results, err := service.SubscribeToTopic(context.Background(), "/topics/news", tokens)
+
+		// --- Assert ---
+		require.NoError(t, err)
+		assert.Equal(t, []int{1000, 1000, 500}, batchSizes)
+		require.Len(t, results, len(tokens))
+		assert.Equal(t, TopicResult{Token: "token-0"}, results[0])
+		assert.Equal(t, TopicResult{Token: "stale", Error: "NOT_FOUND", ErrorCode: "NOT_FOUND"}, results[1500])
+		assert.Equal(t, TopicResult{Token: "token-2499"}, results[2499])
+	})
+
+	t.Run("success - unsubscribes through batchRemove", func(t *testing.T) {
+		var path string
+		service := newTopicService(t, func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			_, _ = w.Write([]byte(`{"results":[{}]}`))
+		})
+
+		results, err := service.UnsubscribeFromTopic(context.Background(), "news", []string{"a"})
+
+		require.NoError(t, err)
+		assert.Equal(t, "/iid/v1:batchRemove", path)
+		assert.Equal(t, []TopicResult{{Token: "a"}}, results)
+	})
+
+	t.Run("error - a rejected batch fails only its own tokens", func(t *testing.T) {
+		// --- Setup ---
+		tokens := make([]string, MaxTopicBatch+1)
+		for i := range tokens {
+			tokens[i] = fmt.Sprintf("token-%d", i)
+		}
+		calls := 0
+		service := newTopicService(t, func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			req := readTopicBatch(t, r)
+			if calls == 2 {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error":"InvalidToken"}`))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"results": make([]struct{}, len(req.RegistrationTokens))})
+		})
+
+		// --- Execute ---
+		results, err := service.SubscribeToTopic(context.Background(), "news", tokens)
+
+		// --- Assert ---
+		require.NoError(t, err)
+		require.Len(t, results, len(tokens))
+		assert.Empty(t, results[0].Error)
+		last := results[MaxTopicBatch]
+		assert.Equal(t, ErrorCodeInvalidArgument, last.ErrorCode)
+		assert.Equal(t, "FCM error 400: InvalidToken", last.Error)
+	})
+
+	t.Run("success - transient failures are retried", func(t *testing.T) {
+		calls := 0
+		service := newTopicService(t, func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte(`{"results":[{}]}`))
+		})
+		service.sleep = func(context.Context, time.Duration) error { return nil }
+
+		results, err := service.SubscribeToTopic(context.Background(), "news", []string{"a"})
+
+		require.NoError(t, err)
+		assert.Equal(t, 2, calls)
+		assert.Empty(t, results[0].Error)
+	})
+
+	tests := []struct {
+		name    string
+		topic   string
+		tokens  []string
+		wantErr string
+	}{
+		{"error - invalid topic", "news!", []string{"a"}, "invalid topic name"},
+		{"error - no tokens", "news", nil, "tokens list cannot be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := newTopicService(t, func(w http.ResponseWriter, r *http.Request) {
+				t.Error("unexpected Instance ID call")
+			})
+
+			_, err := service.SubscribeToTopic(context.Background(), tt.topic, tt.tokens)
+
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
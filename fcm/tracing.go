@@ -0,0 +1,78 @@
+package fcm
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"golang.org/x/oauth2"
+)
+
+const tracerName = "github.com/wirsal/fcm-gateway/fcm"
+
+// Span attributes set by a Service.
+const (
+	AttrProjectID    = attribute.Key("fcm.project_id")
+	AttrTarget       = attribute.Key("fcm.target")
+	AttrMessageName  = attribute.Key("fcm.message_name")
+	AttrErrorCode    = attribute.Key("fcm.error_code")
+	AttrAttempts     = attribute.Key("fcm.attempts")
+	AttrValidateOnly = attribute.Key("fcm.validate_only")
+)
+
+var noopTracer = noop.NewTracerProvider().Tracer(tracerName)
+
+// WithTracerProvider records a span for every message, every HTTP call to
+// Google and every OAuth2 token refresh, as children of the span in the
+// caller's context.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(s *Service) {
+		s.tracer = tp.Tracer(tracerName)
+	}
+}
+
+func (s *Service) startSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	tracer := s.tracer
+	if tracer == nil {
+		tracer = noopTracer
+	}
+	return tracer.Start(ctx, name, opts...)
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if code := ErrorCode(err); code != ErrorCodeUnspecified {
+			span.SetAttributes(AttrErrorCode.String(code))
+		}
+	}
+	span.End()
+}
+
+// accessToken returns a valid OAuth2 access token, fetching a new one when
+// the cached one has expired.
+func (s *Service) accessToken(ctx context.Context) (*oauth2.Token, error) {
+	s.tokenMu.Lock()
+	defer s.tokenMu.Unlock()
+	if s.token.Valid() {
+		return s.token, nil
+	}
+
+	_, span := s.startSpan(ctx, "oauth2.token", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(AttrProjectID.String(s.projectID)))
+	tok, err := s.creds.TokenSource.Token()
+	if s.observer != nil {
+		s.observer.TokenRefreshed(s.projectID, err)
+	}
+	endSpan(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("create token failed: %w", err)
+	}
+	s.token = tok
+	return tok, nil
+}
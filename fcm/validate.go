@@ -0,0 +1,227 @@
+package fcm
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// topicPattern is the topic name syntax FCM accepts, without the optional
+// "/topics/" prefix.
+var topicPattern = regexp.MustCompile(`^[a-zA-Z0-9\-_.~%]+$`)
+
+var (
+	// durationPattern is the protobuf Duration JSON form, e.g. "3.5s".
+	durationPattern = regexp.MustCompile(`^\d+(\.\d{1,9})?s$`)
+	hexColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+	androidPriorities      = []string{"NORMAL", "HIGH"}
+	notificationPriorities = []string{"PRIORITY_UNSPECIFIED", "PRIORITY_MIN", "PRIORITY_LOW", "PRIORITY_DEFAULT", "PRIORITY_HIGH", "PRIORITY_MAX"}
+	visibilities           = []string{"VISIBILITY_UNSPECIFIED", "PRIVATE", "PUBLIC", "SECRET"}
+
+	apnsPriorities     = []string{"1", "5", "10"}
+	apnsPushTypes      = []string{"alert", "background", "location", "voip", "complication", "fileprovider", "mdm", "liveactivity", "pushtotalk"}
+	interruptionLevels = []string{"passive", "active", "time-sensitive", "critical"}
+
+	webpushUrgencies  = []string{"very-low", "low", "normal", "high"}
+	webpushDirections = []string{"auto", "ltr", "rtl"}
+	// webpushTopicPattern is the URL-safe base64 alphabet, at most 32
+	// characters, that RFC 8030 allows in the Topic header.
+	webpushTopicPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+)
+
+// reservedDataKeys are data keys FCM rejects, compared case-insensitively.
+var reservedDataKeys = []string{"from", "notification", "message_type"}
+
+// ValidateData checks a data payload against the key rules of FCM v1.
+func ValidateData(data map[string]string) error {
+	for _, key := range slices.Sorted(maps.Keys(data)) {
+		lower := strings.ToLower(key)
+		if key == "" {
+			return fmt.Errorf("data keys cannot be empty")
+		}
+		if slices.Contains(reservedDataKeys, lower) ||
+			strings.HasPrefix(lower, "google") || strings.HasPrefix(lower, "gcm") {
+			return fmt.Errorf("data key %q is reserved by FCM", key)
+		}
+	}
+	return nil
+}
+
+// NormalizeTopic strips an optional "/topics/" prefix from topic and checks
+// the remaining name against the character set FCM allows.
+func NormalizeTopic(topic string) (string, error) {
+	name := strings.TrimPrefix(topic, "/topics/")
+	if !topicPattern.MatchString(name) {
+		return "", fmt.Errorf("invalid topic name %q: only letters, digits and -_.~%% are allowed", topic)
+	}
+	return name, nil
+}
+
+// Validate checks c against the field formats FCM v1 accepts, so bad values
+// can be rejected before anything is sent.
+func (c *AndroidConfig) Validate() error {
+	if c.Priority != "" && !slices.Contains(androidPriorities, strings.ToUpper(c.Priority)) {
+		return fmt.Errorf("android.priority %q must be NORMAL or HIGH", c.Priority)
+	}
+	if c.TTL != "" && !durationPattern.MatchString(c.TTL) {
+		return fmt.Errorf("android.ttl %q must be a duration in seconds such as \"3.5s\"", c.TTL)
+	}
+	if err := ValidateData(c.Data); err != nil {
+		return fmt.Errorf("android.data: %w", err)
+	}
+	if c.Notification != nil {
+		return c.Notification.validate()
+	}
+	return nil
+}
+
+func (n *AndroidNotification) validate() error {
+	if n.Color != "" && !hexColorPattern.MatchString(n.Color) {
+		return fmt.Errorf("android.notification.color %q must be in #rrggbb format", n.Color)
+	}
+	if len(n.BodyLocArgs) > 0 && n.BodyLocKey == "" {
+		return errors.New("android.notification.body_loc_args requires body_loc_key")
+	}
+	if len(n.TitleLocArgs) > 0 && n.TitleLocKey == "" {
+		return errors.New("android.notification.title_loc_args requires title_loc_key")
+	}
+	if n.EventTime != "" {
+		if _, err := time.Parse(time.RFC3339Nano, n.EventTime); err != nil {
+			return fmt.Errorf("android.notification.event_time %q must be an RFC 3339 timestamp", n.EventTime)
+		}
+	}
+	if n.NotificationPriority != "" && !slices.Contains(notificationPriorities, n.NotificationPriority) {
+		return fmt.Errorf("android.notification.notification_priority %q must be one of %s",
+			n.NotificationPriority, strings.Join(notificationPriorities, ", "))
+	}
+	if n.Visibility != "" && !slices.Contains(visibilities, n.Visibility) {
+		return fmt.Errorf("android.notification.visibility %q must be one of %s",
+			n.Visibility, strings.Join(visibilities, ", "))
+	}
+	for _, d := range n.VibrateTimings {
+		if !durationPattern.MatchString(d) {
+			return fmt.Errorf("android.notification.vibrate_timings %q must be a duration in seconds such as \"0.5s\"", d)
+		}
+	}
+	if n.NotificationCount != nil && *n.NotificationCount < 0 {
+		return errors.New("android.notification.notification_count cannot be negative")
+	}
+	if ls := n.LightSettings; ls != nil {
+		for _, v := range []float64{ls.Color.Red, ls.Color.Green, ls.Color.Blue} {
+			if v < 0 || v > 1 {
+				return errors.New("android.notification.light_settings.color components must be between 0 and 1")
+			}
+		}
+		if a := ls.Color.Alpha; a != nil && (*a < 0 || *a > 1) {
+			return errors.New("android.notification.light_settings.color components must be between 0 and 1")
+		}
+		if !durationPattern.MatchString(ls.LightOnDuration) || !durationPattern.MatchString(ls.LightOffDuration) {
+			return errors.New("android.notification.light_settings durations must be in seconds such as \"0.5s\"")
+		}
+	}
+	return nil
+}
+
+// Validate checks c against the APNs header and payload rules, so bad values
+// can be rejected before anything is sent.
+func (c *ApnsConfig) Validate() error {
+	if v, ok := c.Headers["apns-priority"]; ok && !slices.Contains(apnsPriorities, v) {
+		return fmt.Errorf("apns.headers.apns-priority %q must be 1, 5 or 10", v)
+	}
+	if v, ok := c.Headers["apns-push-type"]; ok && !slices.Contains(apnsPushTypes, v) {
+		return fmt.Errorf("apns.headers.apns-push-type %q must be one of %s", v, strings.Join(apnsPushTypes, ", "))
+	}
+	if c.Payload == nil {
+		return nil
+	}
+	if _, ok := c.Payload.CustomData["aps"]; ok {
+		return errors.New("apns.payload custom keys cannot include aps")
+	}
+
+	aps := c.Payload.Aps
+	if aps.Badge != nil && *aps.Badge < 0 {
+		return errors.New("apns.payload.aps.badge cannot be negative")
+	}
+	if aps.ContentAvailable != 0 && aps.ContentAvailable != 1 {
+		return errors.New("apns.payload.aps.content-available must be 0 or 1")
+	}
+	if aps.MutableContent != 0 && aps.MutableContent != 1 {
+		return errors.New("apns.payload.aps.mutable-content must be 0 or 1")
+	}
+	if aps.InterruptionLevel != "" && !slices.Contains(interruptionLevels, aps.InterruptionLevel) {
+		return fmt.Errorf("apns.payload.aps.interruption-level %q must be one of %s",
+			aps.InterruptionLevel, strings.Join(interruptionLevels, ", "))
+	}
+	if r := aps.RelevanceScore; r != nil && (*r < 0 || *r > 1) {
+		return errors.New("apns.payload.aps.relevance-score must be between 0 and 1")
+	}
+	if snd := aps.Sound; snd != nil && snd.Critical && (snd.Volume < 0 || snd.Volume > 1) {
+		return errors.New("apns.payload.aps.sound.volume must be between 0 and 1")
+	}
+	if a := aps.Alert; a != nil {
+		if len(a.LocArgs) > 0 && a.LocKey == "" {
+			return errors.New("apns.payload.aps.alert.loc-args requires loc-key")
+		}
+		if len(a.TitleLocArgs) > 0 && a.TitleLocKey == "" {
+			return errors.New("apns.payload.aps.alert.title-loc-args requires title-loc-key")
+		}
+		if len(a.SubtitleLocArgs) > 0 && a.SubtitleLocKey == "" {
+			return errors.New("apns.payload.aps.alert.subtitle-loc-args requires subtitle-loc-key")
+		}
+	}
+	return nil
+}
+
+// Validate checks c against the Web Push header rules and the HTTPS-only rule
+// for fcm_options.link, so bad values can be rejected before anything is sent.
+func (c *WebpushConfig) Validate() error {
+	for _, name := range slices.Sorted(maps.Keys(c.Headers)) {
+		v := c.Headers[name]
+		switch strings.ToLower(name) {
+		case "ttl":
+			if n, err := strconv.Atoi(v); err != nil || n < 0 {
+				return fmt.Errorf("webpush.headers.TTL %q must be a non-negative number of seconds", v)
+			}
+		case "urgency":
+			if !slices.Contains(webpushUrgencies, v) {
+				return fmt.Errorf("webpush.headers.Urgency %q must be one of %s", v, strings.Join(webpushUrgencies, ", "))
+			}
+		case "topic":
+			if !webpushTopicPattern.MatchString(v) {
+				return fmt.Errorf("webpush.headers.Topic %q must be at most 32 URL-safe base64 characters", v)
+			}
+		}
+	}
+	if err := ValidateData(c.Data); err != nil {
+		return fmt.Errorf("webpush.data: %w", err)
+	}
+	if o := c.FCMOptions; o != nil && o.Link != "" {
+		u, err := url.Parse(o.Link)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("webpush.fcm_options.link %q must be an absolute HTTPS URL", o.Link)
+		}
+	}
+	if n := c.Notification; n != nil {
+		if n.Direction != "" && !slices.Contains(webpushDirections, n.Direction) {
+			return fmt.Errorf("webpush.notification.dir %q must be auto, ltr or rtl", n.Direction)
+		}
+		for _, a := range n.Actions {
+			if a.Action == "" || a.Title == "" {
+				return errors.New("webpush.notification.actions need both action and title")
+			}
+		}
+		for _, v := range n.Vibrate {
+			if v < 0 {
+				return errors.New("webpush.notification.vibrate cannot contain negative durations")
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package fcm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateData(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string]string
+		wantErr string
+	}{
+		{name: "nil", data: nil},
+		{name: "plain keys", data: map[string]string{"order_id": "42", "type": "sync"}},
+		{name: "empty key", data: map[string]string{"": "x"}, wantErr: "data keys cannot be empty"},
+		{name: "reserved key", data: map[string]string{"From": "x"}, wantErr: `data key "From" is reserved by FCM`},
+		{name: "google prefix", data: map[string]string{"google.c.a": "x"}, wantErr: `data key "google.c.a" is reserved by FCM`},
+		{name: "gcm prefix", data: map[string]string{"gcm_id": "x"}, wantErr: `data key "gcm_id" is reserved by FCM`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateData(tt.data)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestNormalizeTopic(t *testing.T) {
+	tests := []struct {
+		name    string
+		topic   string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain name", topic: "news", want: "news"},
+		{name: "prefixed name", topic: "/topics/news", want: "news"},
+		{name: "all allowed characters", topic: "a-Z_0.9~%", want: "a-Z_0.9~%"},
+		{name: "empty", topic: "", wantErr: true},
+		{name: "prefix only", topic: "/topics/", wantErr: true},
+		{name: "space", topic: "breaking news", wantErr: true},
+		{name: "condition syntax", topic: "'news' in topics", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeTopic(tt.topic)
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "invalid topic name")
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAndroidConfig_Validate(t *testing.T) {
+	count := func(n int) *int { return &n }
+	alpha := func(a float64) *float64 { return &a }
+
+	tests := []struct {
+		name    string
+		config  AndroidConfig
+		wantErr string
+	}{
+		{
+			name: "full valid config",
+			config: AndroidConfig{
+				CollapseKey:           "score",
+				Priority:              "high",
+				TTL:                   "86400s",
+				RestrictedPackageName: "com.example.app",
+				Data:                  map[string]string{"match": "1"},
+				DirectBootOK:          true,
+				FCMOptions:            &AndroidFCMOptions{AnalyticsLabel: "campaign"},
+				Notification: &AndroidNotification{
+					Color:                "#FF8800",
+					ChannelID:            "scores",
+					BodyLocKey:           "GOAL",
+					BodyLocArgs:          []string{"Ajax"},
+					EventTime:            "2025-06-01T12:00:00.5Z",
+					NotificationPriority: "PRIORITY_HIGH",
+					Visibility:           "PUBLIC",
+					VibrateTimings:       []string{"0.2s", "1s"},
+					NotificationCount:    count(3),
+					LightSettings: &LightSettings{
+						Color:            Color{Red: 1, Green: 0.5, Blue: 0, Alpha: alpha(1)},
+						LightOnDuration:  "0.5s",
+						LightOffDuration: "1.000000001s",
+					},
+				},
+			},
+		},
+		{name: "bad priority", config: AndroidConfig{Priority: "urgent"}, wantErr: `android.priority "urgent" must be NORMAL or HIGH`},
+		{name: "ttl without unit", config: AndroidConfig{TTL: "3600"}, wantErr: `android.ttl "3600" must be a duration`},
+		{name: "ttl in minutes", config: AndroidConfig{TTL: "5m"}, wantErr: `android.ttl "5m" must be a duration`},
+		{name: "reserved data key", config: AndroidConfig{Data: map[string]string{"gcm.x": "1"}}, wantErr: `android.data: data key "gcm.x" is reserved`},
+		{name: "named colour", config: AndroidConfig{Notification: &AndroidNotification{Color: "red"}}, wantErr: `android.notification.color "red" must be in #rrggbb format`},
+		{name: "short colour", config: AndroidConfig{Notification: &AndroidNotification{Color: "#f80"}}, wantErr: `android.notification.color "#f80"`},
+		{name: "loc args without key", config: AndroidConfig{Notification: &AndroidNotification{TitleLocArgs: []string{"x"}}}, wantErr: "title_loc_args requires title_loc_key"},
+		{name: "bad event time", config: AndroidConfig{Notification: &AndroidNotification{EventTime: "yesterday"}}, wantErr: "event_time"},
+		{name: "bad notification priority", config: AndroidConfig{Notification: &AndroidNotification{NotificationPriority: "HIGH"}}, wantErr: "notification_priority"},
+		{name: "bad visibility", config: AndroidConfig{Notification: &AndroidNotification{Visibility: "hidden"}}, wantErr: "visibility"},
+		{name: "bad vibrate timing", config: AndroidConfig{Notification: &AndroidNotification{VibrateTimings: []string{"200ms"}}}, wantErr: "vibrate_timings"},
+		{name: "negative count", config: AndroidConfig{Notification: &AndroidNotification{NotificationCount: count(-1)}}, wantErr: "notification_count"},
+		{
+			name: "light colour out of range",
+			config: AndroidConfig{Notification: &AndroidNotification{LightSettings: &LightSettings{
+				Color: Color{Red: 255}, LightOnDuration: "1s", LightOffDuration: "1s",
+			}}},
+			wantErr: "color components must be between 0 and 1",
+		},
+		{
+			name: "light duration missing",
+			config: AndroidConfig{Notification: &AndroidNotification{LightSettings: &LightSettings{
+				Color: Color{Red: 1},
+			}}},
+			wantErr: "light_settings durations",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestApnsConfig_Validate(t *testing.T) {
+	intPtr := func(n int) *int { return &n }
+	floatPtr := func(f float64) *float64 { return &f }
+
+	tests := []struct {
+		name    string
+		config  ApnsConfig
+		wantErr string
+	}{
+		{name: "headers only", config: ApnsConfig{Headers: map[string]string{"apns-priority": "10", "apns-push-type": "alert"}}},
+		{
+			name: "full valid payload",
+			config: ApnsConfig{Payload: &ApnsPayload{
+				Aps: ApnsAps{
+					Alert:             &ApnsAlert{TitleLocKey: "T", TitleLocArgs: []string{"a"}},
+					Badge:             intPtr(0),
+					Sound:             &ApnsSound{Name: "alarm.caf", Critical: true, Volume: 1},
+					ContentAvailable:  1,
+					MutableContent:    1,
+					InterruptionLevel: "critical",
+					RelevanceScore:    floatPtr(0.75),
+				},
+				CustomData: map[string]any{"deeplink": "app://inbox"},
+			}},
+		},
+		{name: "bad priority header", config: ApnsConfig{Headers: map[string]string{"apns-priority": "high"}}, wantErr: "apns-priority"},
+		{name: "bad push type", config: ApnsConfig{Headers: map[string]string{"apns-push-type": "silent"}}, wantErr: "apns-push-type"},
+		{name: "aps as custom key", config: ApnsConfig{Payload: &ApnsPayload{CustomData: map[string]any{"aps": 1}}}, wantErr: "cannot include aps"},
+		{name: "negative badge", config: ApnsConfig{Payload: &ApnsPayload{Aps: ApnsAps{Badge: intPtr(-1)}}}, wantErr: "badge cannot be negative"},
+		{name: "content-available 2", config: ApnsConfig{Payload: &ApnsPayload{Aps: ApnsAps{ContentAvailable: 2}}}, wantErr: "content-available"},
+		{name: "mutable-content 5", config: ApnsConfig{Payload: &ApnsPayload{Aps: ApnsAps{MutableContent: 5}}}, wantErr: "mutable-content"},
+		{name: "unknown interruption level", config: ApnsConfig{Payload: &ApnsPayload{Aps: ApnsAps{InterruptionLevel: "urgent"}}}, wantErr: "interruption-level"},
+		{name: "relevance above 1", config: ApnsConfig{Payload: &ApnsPayload{Aps: ApnsAps{RelevanceScore: floatPtr(2)}}}, wantErr: "relevance-score"},
+		{name: "critical volume above 1", config: ApnsConfig{Payload: &ApnsPayload{Aps: ApnsAps{Sound: &ApnsSound{Critical: true, Volume: 3}}}}, wantErr: "sound.volume"},
+		{name: "loc args without key", config: ApnsConfig{Payload: &ApnsPayload{Aps: ApnsAps{Alert: &ApnsAlert{LocArgs: []string{"x"}}}}}, wantErr: "loc-args requires loc-key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestWebpushConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  WebpushConfig
+		wantErr string
+	}{
+		{
+			name: "full valid config",
+			config: WebpushConfig{
+				Headers: map[string]string{"TTL": "3600", "Urgency": "high", "Topic": "order-42"},
+				Data:    map[string]string{"order": "42"},
+				Notification: &WebpushNotification{
+					Title:              "Order shipped",
+					Badge:              "/badge.png",
+					Icon:               "/icon.png",
+					RequireInteraction: true,
+					Vibrate:            []int{200, 100, 200},
+					Direction:          "ltr",
+					Actions:            []WebpushNotificationAction{{Action: "track", Title: "Track"}},
+				},
+				FCMOptions: &WebpushFCMOptions{Link: "https://dashboard.example.com/orders/42"},
+			},
+		},
+		{name: "negative ttl", config: WebpushConfig{Headers: map[string]string{"TTL": "-1"}}, wantErr: "webpush.headers.TTL"},
+		{name: "duration ttl", config: WebpushConfig{Headers: map[string]string{"ttl": "1h"}}, wantErr: "webpush.headers.TTL"},
+		{name: "unknown urgency", config: WebpushConfig{Headers: map[string]string{"Urgency": "urgent"}}, wantErr: "webpush.headers.Urgency"},
+		{name: "topic too long", config: WebpushConfig{Headers: map[string]string{"Topic": strings.Repeat("a", 33)}}, wantErr: "webpush.headers.Topic"},
+		{name: "topic with spaces", config: WebpushConfig{Headers: map[string]string{"Topic": "a b"}}, wantErr: "webpush.headers.Topic"},
+		{name: "reserved data key", config: WebpushConfig{Data: map[string]string{"from": "x"}}, wantErr: "webpush.data"},
+		{name: "http link", config: WebpushConfig{FCMOptions: &WebpushFCMOptions{Link: "http://example.com"}}, wantErr: "must be an absolute HTTPS URL"},
+		{name: "relative link", config: WebpushConfig{FCMOptions: &WebpushFCMOptions{Link: "/orders"}}, wantErr: "must be an absolute HTTPS URL"},
+		{name: "bad direction", config: WebpushConfig{Notification: &WebpushNotification{Direction: "up"}}, wantErr: "webpush.notification.dir"},
+		{name: "action without title", config: WebpushConfig{Notification: &WebpushNotification{Actions: []WebpushNotificationAction{{Action: "a"}}}}, wantErr: "need both action and title"},
+		{name: "negative vibrate", config: WebpushConfig{Notification: &WebpushNotification{Vibrate: []int{100, -1}}}, wantErr: "vibrate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Package auth checks the API keys and JWTs callers present to the gateway.
+// Keys are only ever stored as hashes.
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Scopes a key can be granted.
+const (
+	// ScopeSend allows sending to device tokens and users.
+	ScopeSend = "send"
+	// ScopeBroadcast allows sending to topics and topic conditions.
+	ScopeBroadcast = "broadcast"
+	// ScopeAdmin allows everything, including managing tokens and topic
+	// subscriptions.
+	ScopeAdmin = "admin"
+)
+
+const hashPrefix = "sha256:"
+
+// Key is an API key as configured, identified by the hash of its secret.
+type Key struct {
+	// Name identifies the key in logs.
+	Name string `json:"name"`
+	// Hash is "sha256:" followed by the hex SHA-256 of the secret, see
+	// HashKey.
+	Hash   string   `json:"hash"`
+	Scopes []string `json:"scopes"`
+	// Projects and Topics are the only Firebase projects and topics the key
+	// may use. nil allows every project or topic, an empty list none.
+	Projects []string `json:"projects,omitempty"`
+	Topics   []string `json:"topics,omitempty"`
+	// DefaultProject is used for requests with this key that name no
+	// project.
+	DefaultProject string `json:"default_project,omitempty"`
+}
+
+// HasScope reports whether k was granted scope. Admin keys have every scope.
+func (k *Key) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope) || slices.Contains(k.Scopes, ScopeAdmin)
+}
+
+// AllowsProject reports whether k may use the Firebase project name.
+func (k *Key) AllowsProject(name string) bool {
+	return k.Projects == nil || slices.ContainsFunc(k.Projects, func(p string) bool {
+		return strings.EqualFold(p, name)
+	})
+}
+
+// AllowsTopic reports whether k may send to or manage topic.
+func (k *Key) AllowsTopic(topic string) bool {
+	return k.Topics == nil || slices.Contains(k.Topics, topic)
+}
+
+// HashKey returns the value to configure as Key.Hash for secret.
+func HashKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// Keyring looks up keys by their secret.
+type Keyring struct {
+	byHash map[string]*Key
+}
+
+// NewKeyring checks keys and returns a Keyring holding them.
+func NewKeyring(keys []Key) (*Keyring, error) {
+	r := &Keyring{byHash: make(map[string]*Key, len(keys))}
+	for i := range keys {
+		k := keys[i]
+		if k.Name == "" {
+			return nil, fmt.Errorf("API key %d: name is required", i+1)
+		}
+		k.Hash = strings.ToLower(k.Hash)
+		digest, ok := strings.CutPrefix(k.Hash, hashPrefix)
+		if raw, err := hex.DecodeString(digest); !ok || err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("API key %s: hash must be %q followed by 64 hex digits", k.Name, hashPrefix)
+		}
+		if len(k.Scopes) == 0 {
+			return nil, fmt.Errorf("API key %s: at least one scope is required", k.Name)
+		}
+		for _, s := range k.Scopes {
+			if s != ScopeSend && s != ScopeBroadcast && s != ScopeAdmin {
+				return nil, fmt.Errorf("API key %s: unknown scope %q", k.Name, s)
+			}
+		}
+		if _, dup := r.byHash[k.Hash]; dup {
+			return nil, fmt.Errorf("API key %s: hash is used by another key", k.Name)
+		}
+		r.byHash[k.Hash] = &k
+	}
+	if len(r.byHash) == 0 {
+		return nil, errors.New("no API keys configured")
+	}
+	return r, nil
+}
+
+// Authenticate returns the key whose secret is secret.
+func (r *Keyring) Authenticate(secret string) (*Key, bool) {
+	if secret == "" {
+		return nil, false
+	}
+	k, ok := r.byHash[HashKey(secret)]
+	return k, ok
+}
+
+// LoadKeyFile reads a JSON array of keys from path.
+func LoadKeyFile(path string) ([]Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read API key file: %w", err)
+	}
+	var keys []Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("parse API key file %s: %w", path, err)
+	}
+	return keys, nil
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyring(t *testing.T) {
+	t.Run("success - authenticates keys by their secret", func(t *testing.T) {
+		// --- Setup ---
+		ring, err := NewKeyring([]Key{
+			{Name: "backend", Hash: HashKey("s3cret"), Scopes: []string{ScopeSend}},
+			{Name: "ops", Hash: strings.ToUpper(HashKey("0ps")), Scopes: []string{ScopeAdmin}},
+		})
+		require.NoError(t, err)
+
+		// --- Execute ---
+		backend, okBackend := ring.Authenticate("s3cret")
+		ops, okOps := ring.Authenticate("0ps")
+		_, okWrong := ring.Authenticate("S3CRET")
+		_, okEmpty := ring.Authenticate("")
+
+		// --- Assert ---
+		require.True(t, okBackend)
+		assert.Equal(t, "backend", backend.Name)
+		require.True(t, okOps)
+		assert.Equal(t, "ops", ops.Name)
+		assert.False(t, okWrong)
+		assert.False(t, okEmpty)
+	})
+
+	tests := []struct {
+		name    string
+		keys    []Key
+		wantErr string
+	}{
+		{"error - no keys", nil, "no API keys configured"},
+		{"error - missing name", []Key{{Hash: HashKey("a"), Scopes: []string{ScopeSend}}}, "name is required"},
+		{"error - plain text secret", []Key{{Name: "k", Hash: "s3cret", Scopes: []string{ScopeSend}}}, "hash must be"},
+		{"error - short hash", []Key{{Name: "k", Hash: "sha256:abcd", Scopes: []string{ScopeSend}}}, "hash must be"},
+		{"error - no scopes", []Key{{Name: "k", Hash: HashKey("a")}}, "at least one scope is required"},
+		{"error - unknown scope", []Key{{Name: "k", Hash: HashKey("a"), Scopes: []string{"root"}}}, `unknown scope "root"`},
+		{"error - duplicate secret", []Key{
+			{Name: "a", Hash: HashKey("a"), Scopes: []string{ScopeSend}},
+			{Name: "b", Hash: HashKey("a"), Scopes: []string{ScopeSend}},
+		}, "hash is used by another key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeyring(tt.keys)
+
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	t.Run("success - admin implies every scope", func(t *testing.T) {
+		admin := Key{Scopes: []string{ScopeAdmin}}
+		sender := Key{Scopes: []string{ScopeSend}}
+
+		assert.True(t, admin.HasScope(ScopeBroadcast))
+		assert.True(t, sender.HasScope(ScopeSend))
+		assert.False(t, sender.HasScope(ScopeBroadcast))
+		assert.False(t, sender.HasScope(ScopeAdmin))
+	})
+
+	t.Run("success - missing allow-lists allow everything, empty ones nothing", func(t *testing.T) {
+		open := Key{}
+		limited := Key{Projects: []string{"Brand-A"}, Topics: []string{"news"}}
+		closed := Key{Projects: []string{}, Topics: []string{}}
+
+		assert.True(t, open.AllowsProject("anything"))
+		assert.True(t, open.AllowsTopic("anything"))
+		assert.True(t, limited.AllowsProject("brand-a"))
+		assert.False(t, limited.AllowsProject("brand-b"))
+		assert.True(t, limited.AllowsTopic("news"))
+		assert.False(t, limited.AllowsTopic("sports"))
+		assert.False(t, closed.AllowsProject("brand-a"))
+		assert.False(t, closed.AllowsTopic("news"))
+	})
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	t.Run("success - reads keys from JSON", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "keys.json")
+		content := `[{"name":"backend","hash":"` + HashKey("s3cret") + `","scopes":["send"],"projects":["brand-a"],"default_project":"brand-a"}]`
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+		keys, err := LoadKeyFile(path)
+
+		require.NoError(t, err)
+		assert.Equal(t, []Key{{Name: "backend", Hash: HashKey("s3cret"), Scopes: []string{"send"}, Projects: []string{"brand-a"}, DefaultProject: "brand-a"}}, keys)
+	})
+
+	t.Run("error - invalid JSON", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "keys.json")
+		require.NoError(t, os.WriteFile(path, []byte("not json"), 0600))
+
+		_, err := LoadKeyFile(path)
+
+		assert.ErrorContains(t, err, "parse API key file")
+	})
+}
@@ -0,0 +1,268 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrUnknownKey is returned by a KeySource that has no key with the
+// requested ID.
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// KeySource provides the public keys JWTs are verified with.
+type KeySource interface {
+	// Key returns the key with ID kid. An empty kid selects the only key
+	// of a single-key set.
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// JWKS is a fixed JSON Web Key Set. It holds the RSA and P-256 keys of the
+// set and skips keys of other types or meant for encryption.
+type JWKS struct {
+	keys map[string]crypto.PublicKey
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS parses a JSON Web Key Set document.
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse JWKS: %w", err)
+	}
+
+	set := &JWKS{keys: make(map[string]crypto.PublicKey)}
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var (
+			key crypto.PublicKey
+			err error
+		)
+		switch k.Kty {
+		case "RSA":
+			key, err = k.rsaKey()
+		case "EC":
+			key, err = k.ecKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse JWKS key %q: %w", k.Kid, err)
+		}
+		set.keys[k.Kid] = key
+	}
+	if len(set.keys) == 0 {
+		return nil, errors.New("parse JWKS: no RSA or EC signing keys")
+	}
+	return set, nil
+}
+
+// LoadJWKSFile reads a JSON Web Key Set from path.
+func LoadJWKSFile(path string) (*JWKS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read JWKS file: %w", err)
+	}
+	return ParseJWKS(data)
+}
+
+func (s *JWKS) Key(_ context.Context, kid string) (crypto.PublicKey, error) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, nil
+		}
+	}
+	k, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	return k, nil
+}
+
+func (k jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("modulus: %w", err)
+	}
+	e, err := decodeBigInt(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("exponent: %w", err)
+	}
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid exponent")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (k jwk) ecKey() (*ecdsa.PublicKey, error) {
+	if k.Crv != "P-256" {
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+	x, err := decodeBigInt(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("x: %w", err)
+	}
+	y, err := decodeBigInt(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("y: %w", err)
+	}
+	key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	if _, err := key.ECDH(); err != nil {
+		return nil, errors.New("point is not on the curve")
+	}
+	return key, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// minRefreshInterval limits how often RemoteJWKS fetches the set again after
+// a failed fetch or for an unknown key ID.
+const minRefreshInterval = time.Minute
+
+// RemoteJWKS fetches a JSON Web Key Set from a URL and caches it. The set is
+// fetched again once ttl has passed, or early when a token names a key the
+// cached set does not have.
+type RemoteJWKS struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+	now    func() time.Time
+
+	mu        sync.Mutex
+	set       *JWKS
+	fetchedAt time.Time
+	// triedAt is the time of the last fetch, successful or not, and err
+	// its error.
+	triedAt time.Time
+	err     error
+	// fetching is closed once the fetch in progress, if any, is done.
+	fetching chan struct{}
+}
+
+// NewRemoteJWKS returns a RemoteJWKS for url. The set is fetched on first use.
+func NewRemoteJWKS(url string, ttl time.Duration) *RemoteJWKS {
+	return &RemoteJWKS{url: url, ttl: ttl, client: &http.Client{Timeout: 10 * time.Second}, now: time.Now}
+}
+
+func (r *RemoteJWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	set, err := r.load(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	key, err := set.Key(ctx, kid)
+	if errors.Is(err, ErrUnknownKey) {
+		// The issuer may have rotated its keys.
+		if fresh, loadErr := r.load(ctx, true); loadErr == nil && fresh != set {
+			key, err = fresh.Key(ctx, kid)
+		}
+	}
+	return key, err
+}
+
+// load returns the key set, fetching it first when none is cached, when it is
+// older than ttl or, with force, in any case. Fetches happen at most once per
+// minRefreshInterval: in between, a failed refresh keeps the cached set in use
+// and without one the error of the last fetch is returned. Concurrent calls
+// share a single fetch, which runs without holding r.mu.
+func (r *RemoteJWKS) load(ctx context.Context, force bool) (*JWKS, error) {
+	r.mu.Lock()
+	for r.fetching != nil {
+		fetching := r.fetching
+		r.mu.Unlock()
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		r.mu.Lock()
+	}
+	stale := force || r.set == nil || r.now().Sub(r.fetchedAt) >= r.ttl
+	if !stale || r.now().Sub(r.triedAt) < minRefreshInterval {
+		defer r.mu.Unlock()
+		return r.cachedLocked()
+	}
+	fetching := make(chan struct{})
+	r.fetching = fetching
+	r.triedAt = r.now()
+	r.mu.Unlock()
+
+	// The fetch is shared, so a caller giving up must not cancel it; the
+	// client's timeout bounds it instead.
+	set, err := r.fetch(context.WithoutCancel(ctx))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fetching = nil
+	close(fetching)
+	r.err = err
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to fetch JWKS", "url", r.url, "error", err)
+		return r.cachedLocked()
+	}
+	r.set = set
+	r.fetchedAt = r.triedAt
+	return set, nil
+}
+
+func (r *RemoteJWKS) cachedLocked() (*JWKS, error) {
+	if r.set == nil {
+		return nil, r.err
+	}
+	return r.set, nil
+}
+
+func (r *RemoteJWKS) fetch(ctx context.Context) (*JWKS, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetch JWKS: %w", err)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch JWKS: status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("fetch JWKS: %w", err)
+	}
+	return ParseJWKS(data)
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTConfig controls which JWTs a JWTVerifier accepts and how their claims
+// map onto permissions.
+type JWTConfig struct {
+	// Issuer and Audience must match the iss and aud claims.
+	Issuer   string
+	Audience string
+	// Leeway tolerates clock skew when checking exp, nbf and iat.
+	Leeway time.Duration
+	// ScopesClaim names the claim holding the scopes, either as an array or
+	// as a space-separated string. Values other than send, broadcast and
+	// admin are ignored. Defaults to "scope".
+	ScopesClaim string
+	// ProjectsClaim and TopicsClaim name the claims holding the allow-lists
+	// of Firebase projects and topics; tokens without them are not
+	// restricted, while an empty list allows nothing. Default to "projects"
+	// and "topics".
+	ProjectsClaim string
+	TopicsClaim   string
+	// DefaultProjectClaim names the claim holding the project used for
+	// requests that name none. Defaults to "default_project".
+	DefaultProjectClaim string
+}
+
+// JWTVerifier checks RS256 and ES256 signed JWTs against a KeySource.
+type JWTVerifier struct {
+	keys   KeySource
+	cfg    JWTConfig
+	parser *jwt.Parser
+}
+
+// NewJWTVerifier returns a JWTVerifier that verifies signatures with keys.
+// cfg.Issuer and cfg.Audience are required.
+func NewJWTVerifier(keys KeySource, cfg JWTConfig) (*JWTVerifier, error) {
+	if cfg.Issuer == "" || cfg.Audience == "" {
+		return nil, fmt.Errorf("JWT issuer and audience are required")
+	}
+	if cfg.ScopesClaim == "" {
+		cfg.ScopesClaim = "scope"
+	}
+	if cfg.ProjectsClaim == "" {
+		cfg.ProjectsClaim = "projects"
+	}
+	if cfg.TopicsClaim == "" {
+		cfg.TopicsClaim = "topics"
+	}
+	if cfg.DefaultProjectClaim == "" {
+		cfg.DefaultProjectClaim = "default_project"
+	}
+	return &JWTVerifier{
+		keys: keys,
+		cfg:  cfg,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}),
+			jwt.WithIssuer(cfg.Issuer),
+			jwt.WithAudience(cfg.Audience),
+			jwt.WithExpirationRequired(),
+			jwt.WithIssuedAt(),
+			jwt.WithLeeway(cfg.Leeway),
+		),
+	}, nil
+}
+
+// Verify checks token and returns the permissions its claims grant, in the
+// form of a Key named after the token's subject.
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (*Key, error) {
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.keys.Key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sub, _ := claims.GetSubject()
+	key := &Key{Name: "jwt:" + sub}
+	for _, s := range stringsClaim(claims[v.cfg.ScopesClaim]) {
+		if s == ScopeSend || s == ScopeBroadcast || s == ScopeAdmin {
+			key.Scopes = append(key.Scopes, s)
+		}
+	}
+	key.Projects = stringsClaim(claims[v.cfg.ProjectsClaim])
+	key.Topics = stringsClaim(claims[v.cfg.TopicsClaim])
+	key.DefaultProject, _ = claims[v.cfg.DefaultProjectClaim].(string)
+	return key, nil
+}
+
+// stringsClaim reads a claim given either as an array of strings or as a
+// space-separated string. It returns nil only for a missing claim.
+func stringsClaim(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		out := []string{}
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// LooksLikeJWT reports whether token has the three dot-separated parts of a
+// compact JWT, as opposed to an opaque API key.
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,318 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSigner holds an RSA and an EC key, published as JWKS under the IDs
+// "rsa" and "ec".
+type testSigner struct {
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return &testSigner{rsaKey: rsaKey, ecKey: ecKey}
+}
+
+func (s *testSigner) jwks(t *testing.T) []byte {
+	t.Helper()
+	b64 := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	data, err := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(s.rsaKey.N), "e": b64(big.NewInt(int64(s.rsaKey.E)))},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(s.ecKey.X), "y": b64(s.ecKey.Y)},
+		{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+	}})
+	require.NoError(t, err)
+	return data
+}
+
+// sign returns a token signed with method and the key matching kid.
+func (s *testSigner) sign(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	var key any = s.rsaKey
+	if method == jwt.SigningMethodES256 {
+		key = s.ecKey
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss": "https://issuer.test",
+		"aud": "fcm-gateway",
+		"sub": "billing-service",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+}
+
+func TestJWTVerifier(t *testing.T) {
+	signer := newTestSigner(t)
+	set, err := ParseJWKS(signer.jwks(t))
+	require.NoError(t, err)
+	verifier, err := NewJWTVerifier(set, JWTConfig{Issuer: "https://issuer.test", Audience: "fcm-gateway"})
+	require.NoError(t, err)
+
+	t.Run("success - maps claims of an RS256 token onto permissions", func(t *testing.T) {
+		// --- Setup ---
+		claims := validClaims()
+		claims["scope"] = "openid broadcast send"
+		claims["topics"] = []string{"news", "sports"}
+		claims["projects"] = []string{"brand-a"}
+		claims["default_project"] = "brand-a"
+		token := signer.sign(t, jwt.SigningMethodRS256, "rsa", claims)
+
+		// --- Execute ---
+		key, err := verifier.Verify(context.Background(), token)
+
+		// --- Assert ---
+		require.NoError(t, err)
+		assert.Equal(t, &Key{
+			Name:           "jwt:billing-service",
+			Scopes:         []string{ScopeBroadcast, ScopeSend},
+			Projects:       []string{"brand-a"},
+			Topics:         []string{"news", "sports"},
+			DefaultProject: "brand-a",
+		}, key)
+	})
+
+	t.Run("success - accepts ES256 and leaves missing allow-lists open", func(t *testing.T) {
+		claims := validClaims()
+		claims["scope"] = []string{"admin"}
+		token := signer.sign(t, jwt.SigningMethodES256, "ec", claims)
+
+		key, err := verifier.Verify(context.Background(), token)
+
+		require.NoError(t, err)
+		assert.True(t, key.HasScope(ScopeBroadcast))
+		assert.True(t, key.AllowsTopic("anything"))
+		assert.True(t, key.AllowsProject("anything"))
+	})
+
+	t.Run("success - custom claim names", func(t *testing.T) {
+		custom, err := NewJWTVerifier(set, JWTConfig{Issuer: "https://issuer.test", Audience: "fcm-gateway", ScopesClaim: "permissions", TopicsClaim: "fcm_topics"})
+		require.NoError(t, err)
+		claims := validClaims()
+		claims["permissions"] = []string{"broadcast"}
+		claims["fcm_topics"] = []string{}
+		token := signer.sign(t, jwt.SigningMethodRS256, "rsa", claims)
+
+		key, err := custom.Verify(context.Background(), token)
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{ScopeBroadcast}, key.Scopes)
+		assert.False(t, key.AllowsTopic("news"), "an empty topics claim allows no topic")
+	})
+
+	hmacToken := func(t *testing.T) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims()).SignedString([]byte("secret"))
+		require.NoError(t, err)
+		return signed
+	}
+	tests := []struct {
+		name    string
+		token   func(t *testing.T) string
+		wantErr error
+	}{
+		{"error - expired", func(t *testing.T) string {
+			claims := validClaims()
+			claims["exp"] = time.Now().Add(-time.Minute).Unix()
+			return signer.sign(t, jwt.SigningMethodRS256, "rsa", claims)
+		}, jwt.ErrTokenExpired},
+		{"error - missing expiry", func(t *testing.T) string {
+			claims := validClaims()
+			delete(claims, "exp")
+			return signer.sign(t, jwt.SigningMethodRS256, "rsa", claims)
+		}, jwt.ErrTokenRequiredClaimMissing},
+		{"error - wrong issuer", func(t *testing.T) string {
+			claims := validClaims()
+			claims["iss"] = "https://evil.test"
+			return signer.sign(t, jwt.SigningMethodRS256, "rsa", claims)
+		}, jwt.ErrTokenInvalidIssuer},
+		{"error - wrong audience", func(t *testing.T) string {
+			claims := validClaims()
+			claims["aud"] = []string{"other-service"}
+			return signer.sign(t, jwt.SigningMethodRS256, "rsa", claims)
+		}, jwt.ErrTokenInvalidAudience},
+		{"error - unknown key", func(t *testing.T) string {
+			return signer.sign(t, jwt.SigningMethodRS256, "rotated", validClaims())
+		}, ErrUnknownKey},
+		{"error - signed with another key", func(t *testing.T) string {
+			other := newTestSigner(t)
+			return other.sign(t, jwt.SigningMethodRS256, "rsa", validClaims())
+		}, jwt.ErrTokenSignatureInvalid},
+		{"error - HMAC algorithm", hmacToken, jwt.ErrTokenSignatureInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifier.Verify(context.Background(), tt.token(t))
+
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRemoteJWKS(t *testing.T) {
+	t.Run("success - caches the set and refetches it for unknown keys", func(t *testing.T) {
+		// --- Setup ---
+		signer := newTestSigner(t)
+		var fetches atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fetches.Add(1)
+			_, _ = w.Write(signer.jwks(t))
+		}))
+		defer server.Close()
+		remote := NewRemoteJWKS(server.URL, time.Hour)
+		now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		remote.now = func() time.Time { return now }
+		ctx := context.Background()
+
+		// --- Execute & Assert ---
+		_, err := remote.Key(ctx, "rsa")
+		require.NoError(t, err)
+		_, err = remote.Key(ctx, "ec")
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), fetches.Load(), "keys are served from the cache")
+
+		_, err = remote.Key(ctx, "rotated")
+		assert.ErrorIs(t, err, ErrUnknownKey)
+		assert.Equal(t, int32(1), fetches.Load(), "unknown keys refetch at most once a minute")
+
+		now = now.Add(minRefreshInterval)
+		_, err = remote.Key(ctx, "rotated")
+		assert.ErrorIs(t, err, ErrUnknownKey)
+		assert.Equal(t, int32(2), fetches.Load())
+
+		now = now.Add(time.Hour)
+		_, err = remote.Key(ctx, "rsa")
+		require.NoError(t, err)
+		assert.Equal(t, int32(3), fetches.Load(), "the set is refetched once the TTL passed")
+	})
+
+	t.Run("success - keeps the cached set when a refresh fails", func(t *testing.T) {
+		signer := newTestSigner(t)
+		var fail atomic.Bool
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if fail.Load() {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write(signer.jwks(t))
+		}))
+		defer server.Close()
+		remote := NewRemoteJWKS(server.URL, time.Minute)
+		now := time.Now()
+		remote.now = func() time.Time { return now }
+		_, err := remote.Key(context.Background(), "rsa")
+		require.NoError(t, err)
+
+		fail.Store(true)
+		now = now.Add(2 * time.Minute)
+		_, err = remote.Key(context.Background(), "rsa")
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("success - concurrent calls share one fetch", func(t *testing.T) {
+		// --- Setup ---
+		signer := newTestSigner(t)
+		var fetches atomic.Int32
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fetches.Add(1)
+			<-release
+			_, _ = w.Write(signer.jwks(t))
+		}))
+		defer server.Close()
+		remote := NewRemoteJWKS(server.URL, time.Hour)
+
+		// --- Execute ---
+		errs := make(chan error, 10)
+		for range 10 {
+			go func() {
+				_, err := remote.Key(context.Background(), "rsa")
+				errs <- err
+			}()
+		}
+		require.Eventually(t, func() bool { return fetches.Load() == 1 }, time.Second, time.Millisecond)
+		close(release)
+
+		// --- Assert ---
+		for range 10 {
+			assert.NoError(t, <-errs)
+		}
+		assert.Equal(t, int32(1), fetches.Load())
+	})
+
+	t.Run("error - first fetch fails", func(t *testing.T) {
+		// --- Setup ---
+		var fetches atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fetches.Add(1)
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+		remote := NewRemoteJWKS(server.URL, time.Minute)
+		now := time.Now()
+		remote.now = func() time.Time { return now }
+
+		// --- Execute & Assert ---
+		_, err := remote.Key(context.Background(), "rsa")
+		assert.ErrorContains(t, err, "status 404")
+		_, err = remote.Key(context.Background(), "rsa")
+		assert.ErrorContains(t, err, "status 404")
+		assert.Equal(t, int32(1), fetches.Load(), "the error is cached for a minute")
+
+		now = now.Add(minRefreshInterval)
+		_, err = remote.Key(context.Background(), "rsa")
+		assert.ErrorContains(t, err, "status 404")
+		assert.Equal(t, int32(2), fetches.Load())
+	})
+}
+
+func TestParseJWKS(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"error - not JSON", "nope", "parse JWKS"},
+		{"error - no usable keys", `{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`, "no RSA or EC signing keys"},
+		{"error - unsupported curve", `{"keys":[{"kty":"EC","kid":"a","crv":"P-521","x":"AQ","y":"AQ"}]}`, "unsupported curve"},
+		{"error - point off the curve", `{"keys":[{"kty":"EC","kid":"a","crv":"P-256","x":"AQ","y":"AQ"}]}`, "not on the curve"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseJWKS([]byte(tt.data))
+
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
@@ -1,28 +1,305 @@
 package config
 
-import "github.com/spf13/viper"
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
 
 type ServerConfig struct {
 	Port    string `mapstructure:"port"`
 	Name    string `mapstructure:"name"`
 	Version string `mapstructure:"version"`
+	// RateLimit applies to each API key, or to each client IP when
+	// authentication is disabled.
+	RateLimit RateLimitConfig `mapstructure:"rate_limit"`
+	Shutdown  ShutdownConfig  `mapstructure:"shutdown"`
+	// TrustedProxies are the IPs or CIDRs of reverse proxies whose
+	// X-Forwarded-For header names the client IP. No proxy is trusted when
+	// it is empty.
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+}
+
+// ShutdownConfig controls how the server drains on SIGINT or SIGTERM.
+type ShutdownConfig struct {
+	// Delay is how long /readyz reports not ready, with new requests refused,
+	// before the listener closes, so load balancers notice first.
+	Delay time.Duration `mapstructure:"delay"`
+	// Timeout bounds how long in-flight requests, jobs and schedules may
+	// take to finish.
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// RateLimitConfig is a token bucket. A PerSecond of 0 means no limit.
+type RateLimitConfig struct {
+	PerSecond float64 `mapstructure:"per_second"`
+	// Burst defaults to PerSecond rounded up.
+	Burst int `mapstructure:"burst"`
 }
 
 type FCMConfig struct {
-	CredentialsFile string   `mapstructure:"credentials_file"`
-	Scopes          []string `mapstructure:"scopes"`
-	EndpointURL     string   `mapstructure:"endpoint_url"`
+	CredentialsFile string      `mapstructure:"credentials_file"`
+	Scopes          []string    `mapstructure:"scopes"`
+	EndpointURL     string      `mapstructure:"endpoint_url"`
+	MaxConcurrency  int         `mapstructure:"max_concurrency"`
+	Retry           RetryConfig `mapstructure:"retry"`
+	// Projects serves several Firebase projects from one gateway, keyed by
+	// the name requests select them with. When it is empty the settings
+	// above form a single project named DefaultProjectName.
+	Projects map[string]ProjectConfig `mapstructure:"projects"`
+	// DefaultProject serves requests that name no project. It may be empty
+	// when Projects has several entries, in which case every request must
+	// name one.
+	DefaultProject string `mapstructure:"default_project"`
+	// DryRun makes every send validate-only, e.g. for staging.
+	DryRun bool `mapstructure:"dry_run"`
+	// IIDURL is the base URL of the Instance ID API used for topic
+	// subscriptions.
+	IIDURL string `mapstructure:"iid_url"`
+	// RateLimit caps the messages sent to each project.
+	RateLimit RateLimitConfig `mapstructure:"rate_limit"`
+}
+
+// ProjectConfig is one Firebase project. Empty scopes, endpoint and rate
+// limit fall back to the ones of FCMConfig.
+type ProjectConfig struct {
+	CredentialsFile string          `mapstructure:"credentials_file"`
+	Scopes          []string        `mapstructure:"scopes"`
+	EndpointURL     string          `mapstructure:"endpoint_url"`
+	RateLimit       RateLimitConfig `mapstructure:"rate_limit"`
+}
+
+// DefaultProjectName names the project made of the top-level fcm settings.
+const DefaultProjectName = "default"
+
+// ProjectConfigs returns every configured project and the name of the
+// default one.
+func (c FCMConfig) ProjectConfigs() (map[string]ProjectConfig, string, error) {
+	if len(c.Projects) == 0 {
+		single := ProjectConfig{CredentialsFile: c.CredentialsFile, Scopes: c.Scopes, EndpointURL: c.EndpointURL, RateLimit: c.RateLimit}
+		return map[string]ProjectConfig{DefaultProjectName: single}, DefaultProjectName, nil
+	}
+
+	projects := make(map[string]ProjectConfig, len(c.Projects))
+	for name, p := range c.Projects {
+		if p.CredentialsFile == "" {
+			return nil, "", fmt.Errorf("fcm.projects.%s.credentials_file is required", name)
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = c.Scopes
+		}
+		if p.EndpointURL == "" {
+			p.EndpointURL = c.EndpointURL
+		}
+		if p.RateLimit.PerSecond == 0 {
+			p.RateLimit = c.RateLimit
+		}
+		projects[name] = p
+	}
+
+	def := strings.ToLower(c.DefaultProject)
+	if def == "" && len(projects) == 1 {
+		for name := range projects {
+			def = name
+		}
+	}
+	if _, ok := projects[def]; def != "" && !ok {
+		return nil, "", fmt.Errorf("fcm.default_project %q is not one of fcm.projects", c.DefaultProject)
+	}
+	return projects, def, nil
+}
+
+type RetryConfig struct {
+	MaxAttempts int           `mapstructure:"max_attempts"`
+	BaseDelay   time.Duration `mapstructure:"base_delay"`
+	MaxDelay    time.Duration `mapstructure:"max_delay"`
+	Jitter      float64       `mapstructure:"jitter"`
+}
+
+// JobsConfig controls asynchronous sends (POST /send?async=true).
+type JobsConfig struct {
+	Workers   int           `mapstructure:"workers"`
+	QueueSize int           `mapstructure:"queue_size"`
+	Retention time.Duration `mapstructure:"retention"`
+	// StoreDir keeps jobs on disk so unfinished ones resume after a restart.
+	// Jobs are kept in memory only when it is empty.
+	StoreDir string `mapstructure:"store_dir"`
+}
+
+// SchedulesConfig controls sends held until their send_at time.
+type SchedulesConfig struct {
+	// StoreDir keeps pending schedules on disk so they survive a restart.
+	// Schedules are kept in memory only when it is empty.
+	StoreDir string `mapstructure:"store_dir"`
+}
+
+// IdempotencyConfig controls Idempotency-Key handling on the send endpoints.
+type IdempotencyConfig struct {
+	// Window is how long a key is remembered after its first use.
+	Window time.Duration `mapstructure:"window"`
+	// MaxBytes caps the memory taken by keys and stored responses. The
+	// oldest keys are forgotten early to stay below it; 0 means no limit.
+	MaxBytes int64 `mapstructure:"max_bytes"`
+}
+
+// WebhooksConfig controls result callbacks to callback_url.
+type WebhooksConfig struct {
+	// Secret signs every callback. Callbacks are disabled when it is empty.
+	Secret string `mapstructure:"secret"`
+	// DefaultURL receives results of requests without a callback_url.
+	DefaultURL  string          `mapstructure:"default_url"`
+	RetryDelays []time.Duration `mapstructure:"retry_delays"`
+	Timeout     time.Duration   `mapstructure:"timeout"`
+	// AllowedHosts limits callback_url to these hosts; "*.example.com"
+	// matches subdomains. Any host is allowed when it is empty.
+	AllowedHosts []string `mapstructure:"allowed_hosts"`
+	// AllowHTTP accepts plain http callback URLs besides https ones.
+	AllowHTTP bool `mapstructure:"allow_http"`
+	// AllowPrivateNetworks lets callback_url reach loopback, private and
+	// link-local addresses.
+	AllowPrivateNetworks bool `mapstructure:"allow_private_networks"`
+}
+
+// RegistryConfig controls the built-in device token registry.
+type RegistryConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Path is the JSON file the registry is kept in. Tokens are kept in
+	// memory only when it is empty.
+	Path string `mapstructure:"path"`
+	// RemoveInvalid deletes tokens FCM rejects for good instead of flagging
+	// them.
+	RemoveInvalid bool `mapstructure:"remove_invalid"`
+}
+
+// AuthConfig controls API key and JWT authentication.
+type AuthConfig struct {
+	// Enabled requires a valid API key or JWT on every endpoint but GET /.
+	Enabled bool `mapstructure:"enabled"`
+	// KeyFile is a JSON file with more keys, in the same form as Keys.
+	KeyFile string         `mapstructure:"key_file"`
+	Keys    []APIKeyConfig `mapstructure:"keys"`
+	JWT     JWTAuthConfig  `mapstructure:"jwt"`
+}
+
+// JWTAuthConfig controls bearer JWTs, e.g. issued by an OIDC provider. JWTs
+// are accepted when JWKSFile or JWKSURL is set.
+type JWTAuthConfig struct {
+	JWKSFile string `mapstructure:"jwks_file"`
+	JWKSURL  string `mapstructure:"jwks_url"`
+	// CacheTTL is how long keys fetched from JWKSURL are used before they
+	// are fetched again.
+	CacheTTL time.Duration `mapstructure:"cache_ttl"`
+	Issuer   string        `mapstructure:"issuer"`
+	Audience string        `mapstructure:"audience"`
+	// Leeway tolerates clock skew between the issuer and the gateway.
+	Leeway time.Duration  `mapstructure:"leeway"`
+	Claims JWTClaimConfig `mapstructure:"claims"`
+}
+
+// JWTClaimConfig names the claims that carry a token's permissions.
+type JWTClaimConfig struct {
+	Scopes         string `mapstructure:"scopes"`
+	Projects       string `mapstructure:"projects"`
+	Topics         string `mapstructure:"topics"`
+	DefaultProject string `mapstructure:"default_project"`
+}
+
+// Configured reports whether JWTs are accepted.
+func (c JWTAuthConfig) Configured() bool {
+	return c.JWKSFile != "" || c.JWKSURL != ""
+}
+
+// APIKeyConfig is one API key. Only the hash of the key is configured.
+type APIKeyConfig struct {
+	Name string `mapstructure:"name"`
+	// Hash is "sha256:" followed by the hex SHA-256 of the key.
+	Hash string `mapstructure:"hash"`
+	// Scopes are any of send, broadcast and admin.
+	Scopes []string `mapstructure:"scopes"`
+	// Projects and Topics, when set, are the only Firebase projects and
+	// topics the key may use. An empty list allows none.
+	Projects       []string `mapstructure:"projects"`
+	Topics         []string `mapstructure:"topics"`
+	DefaultProject string   `mapstructure:"default_project"`
+}
+
+// MetricsConfig controls the Prometheus metrics endpoint.
+type MetricsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Path is where the metrics are served, outside of authentication.
+	Path string `mapstructure:"path"`
+}
+
+// TracingConfig controls OpenTelemetry tracing.
+type TracingConfig struct {
+	// Exporter is none, stdout or otlp.
+	Exporter string `mapstructure:"exporter"`
+	// Endpoint is the host:port of the OTLP/HTTP collector; when empty the
+	// OTEL_EXPORTER_OTLP_* environment variables apply.
+	Endpoint string `mapstructure:"endpoint"`
+	Insecure bool   `mapstructure:"insecure"`
+	// SampleRatio is the share of new traces that are recorded. Traces
+	// sampled by the caller are always recorded.
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+}
+
+// LogConfig controls the gateway's logs.
+type LogConfig struct {
+	// Level is debug, info, warn or error.
+	Level string `mapstructure:"level"`
+	// Format is text or json.
+	Format string `mapstructure:"format"`
+	// RedactTokens logs device tokens as a short hash instead of in full.
+	RedactTokens bool `mapstructure:"redact_tokens"`
 }
 
 type Config struct {
-	Server ServerConfig `mapstructure:"server"`
-	FCM    FCMConfig    `mapstructure:"fcm"`
+	Server      ServerConfig      `mapstructure:"server"`
+	FCM         FCMConfig         `mapstructure:"fcm"`
+	Jobs        JobsConfig        `mapstructure:"jobs"`
+	Schedules   SchedulesConfig   `mapstructure:"schedules"`
+	Idempotency IdempotencyConfig `mapstructure:"idempotency"`
+	Webhooks    WebhooksConfig    `mapstructure:"webhooks"`
+	Registry    RegistryConfig    `mapstructure:"registry"`
+	Auth        AuthConfig        `mapstructure:"auth"`
+	Metrics     MetricsConfig     `mapstructure:"metrics"`
+	Tracing     TracingConfig     `mapstructure:"tracing"`
+	Log         LogConfig         `mapstructure:"log"`
 }
 
 func LoadConfig(path string) (*Config, error) {
 	viper.AddConfigPath(path)
 	viper.SetConfigName(".config")
 	viper.SetConfigType("yaml")
+	viper.SetDefault("server.shutdown.timeout", "30s")
+	viper.SetDefault("fcm.max_concurrency", 10)
+	viper.SetDefault("fcm.retry.max_attempts", 3)
+	viper.SetDefault("fcm.retry.base_delay", "500ms")
+	viper.SetDefault("fcm.retry.max_delay", "10s")
+	viper.SetDefault("fcm.retry.jitter", 0.2)
+	viper.SetDefault("fcm.iid_url", "https://iid.googleapis.com")
+	viper.SetDefault("jobs.workers", 2)
+	viper.SetDefault("jobs.queue_size", 100)
+	viper.SetDefault("jobs.retention", "1h")
+	viper.SetDefault("idempotency.window", "24h")
+	viper.SetDefault("idempotency.max_bytes", 64<<20)
+	viper.SetDefault("webhooks.retry_delays", []string{"1s", "10s", "1m", "5m", "30m"})
+	viper.SetDefault("webhooks.timeout", "10s")
+	viper.SetDefault("metrics.enabled", true)
+	viper.SetDefault("metrics.path", "/metrics")
+	viper.SetDefault("tracing.exporter", "none")
+	viper.SetDefault("tracing.sample_ratio", 1.0)
+	viper.SetDefault("log.level", "info")
+	viper.SetDefault("log.format", "text")
+	viper.SetDefault("log.redact_tokens", true)
+	viper.SetDefault("auth.jwt.cache_ttl", "1h")
+	viper.SetDefault("auth.jwt.leeway", "30s")
+	viper.SetDefault("auth.jwt.claims.scopes", "scope")
+	viper.SetDefault("auth.jwt.claims.projects", "projects")
+	viper.SetDefault("auth.jwt.claims.topics", "topics")
+	viper.SetDefault("auth.jwt.claims.default_project", "default_project")
 
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, err
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
@@ -26,6 +27,8 @@ func TestLoadConfig(t *testing.T) {
 		configContent := `
 server:
   port: "8081"
+  rate_limit:
+    per_second: 2.5
 fcm:
   credentials_file: "test-credentials.json"
   scopes:
@@ -45,11 +48,124 @@ fcm:
 
 		// Assert server configuration.
 		assert.Equal(t, "8081", cfg.Server.Port)
+		assert.Equal(t, RateLimitConfig{PerSecond: 2.5}, cfg.Server.RateLimit)
+		assert.Equal(t, ShutdownConfig{Timeout: 30 * time.Second}, cfg.Server.Shutdown)
 
 		// Assert FCM configuration.
 		assert.Equal(t, "test-credentials.json", cfg.FCM.CredentialsFile)
 		assert.Equal(t, []string{"https://www.googleapis.com/auth/firebase.messaging"}, cfg.FCM.Scopes)
 		assert.Equal(t, "http://localhost:8080/fcm/send", cfg.FCM.EndpointURL)
+		assert.Equal(t, 10, cfg.FCM.MaxConcurrency, "max_concurrency should fall back to its default")
+		assert.Equal(t, 3, cfg.FCM.Retry.MaxAttempts)
+		assert.Equal(t, 500*time.Millisecond, cfg.FCM.Retry.BaseDelay)
+		assert.Equal(t, 10*time.Second, cfg.FCM.Retry.MaxDelay)
+		assert.Equal(t, "https://iid.googleapis.com", cfg.FCM.IIDURL)
+		assert.Equal(t, 24*time.Hour, cfg.Idempotency.Window)
+		assert.Equal(t, int64(64<<20), cfg.Idempotency.MaxBytes)
+		assert.Equal(t, []time.Duration{time.Second, 10 * time.Second, time.Minute, 5 * time.Minute, 30 * time.Minute}, cfg.Webhooks.RetryDelays)
+		assert.Equal(t, 10*time.Second, cfg.Webhooks.Timeout)
+		assert.False(t, cfg.Auth.JWT.Configured())
+		assert.Equal(t, MetricsConfig{Enabled: true, Path: "/metrics"}, cfg.Metrics)
+		assert.Equal(t, TracingConfig{Exporter: "none", SampleRatio: 1}, cfg.Tracing)
+		assert.Equal(t, LogConfig{Level: "info", Format: "text", RedactTokens: true}, cfg.Log)
+	})
+
+	t.Run("success - should load several projects", func(t *testing.T) {
+		// --- Setup ---
+		viper.Reset()
+		tempDir := t.TempDir()
+		configContent := `
+fcm:
+  scopes:
+    - "https://www.googleapis.com/auth/firebase.messaging"
+  endpoint_url: "https://fcm.googleapis.com/v1/projects/%s/messages:send"
+  default_project: "Brand-A"
+  rate_limit:
+    per_second: 500
+  projects:
+    brand-a:
+      credentials_file: "brand-a.json"
+    brand-b:
+      credentials_file: "brand-b.json"
+      endpoint_url: "http://localhost:9000/%s"
+      rate_limit:
+        per_second: 50
+        burst: 100
+`
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, ".config.yaml"), []byte(configContent), 0644))
+
+		// --- Execute ---
+		cfg, err := LoadConfig(tempDir)
+		require.NoError(t, err)
+		projects, def, err := cfg.FCM.ProjectConfigs()
+
+		// --- Assert ---
+		require.NoError(t, err)
+		assert.Equal(t, "brand-a", def)
+		assert.Equal(t, map[string]ProjectConfig{
+			"brand-a": {
+				CredentialsFile: "brand-a.json",
+				Scopes:          []string{"https://www.googleapis.com/auth/firebase.messaging"},
+				EndpointURL:     "https://fcm.googleapis.com/v1/projects/%s/messages:send",
+				RateLimit:       RateLimitConfig{PerSecond: 500},
+			},
+			"brand-b": {
+				CredentialsFile: "brand-b.json",
+				Scopes:          []string{"https://www.googleapis.com/auth/firebase.messaging"},
+				EndpointURL:     "http://localhost:9000/%s",
+				RateLimit:       RateLimitConfig{PerSecond: 50, Burst: 100},
+			},
+		}, projects)
+	})
+
+	t.Run("success - should load API keys", func(t *testing.T) {
+		// --- Setup ---
+		viper.Reset()
+		tempDir := t.TempDir()
+		configContent := `
+auth:
+  enabled: true
+  key_file: "keys.json"
+  keys:
+    - name: "backend"
+      hash: "sha256:abc"
+      scopes: ["send", "broadcast"]
+      projects: ["brand-a"]
+      topics: ["news"]
+      default_project: "brand-a"
+  jwt:
+    jwks_url: "https://issuer.test/.well-known/jwks.json"
+    issuer: "https://issuer.test"
+    audience: "fcm-gateway"
+    claims:
+      scopes: "permissions"
+`
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, ".config.yaml"), []byte(configContent), 0644))
+
+		// --- Execute ---
+		cfg, err := LoadConfig(tempDir)
+
+		// --- Assert ---
+		require.NoError(t, err)
+		assert.True(t, cfg.Auth.Enabled)
+		assert.Equal(t, "keys.json", cfg.Auth.KeyFile)
+		assert.Equal(t, []APIKeyConfig{{
+			Name:           "backend",
+			Hash:           "sha256:abc",
+			Scopes:         []string{"send", "broadcast"},
+			Projects:       []string{"brand-a"},
+			Topics:         []string{"news"},
+			DefaultProject: "brand-a",
+		}}, cfg.Auth.Keys)
+		assert.True(t, cfg.Auth.JWT.Configured())
+		assert.Equal(t, JWTAuthConfig{
+			JWKSURL:  "https://issuer.test/.well-known/jwks.json",
+			CacheTTL: time.Hour,
+			Issuer:   "https://issuer.test",
+			Audience: "fcm-gateway",
+			Leeway:   30 * time.Second,
+			Claims:   JWTClaimConfig{Scopes: "permissions", Projects: "projects", Topics: "topics", DefaultProject: "default_project"},
+		}, cfg.Auth.JWT)
 	})
 
 	t.Run("error - config file not found", func(t *testing.T) {
@@ -89,3 +205,49 @@ server:
 		assert.Nil(t, cfg, "Config should be nil on error")
 	})
 }
+
+func TestFCMConfig_ProjectConfigs(t *testing.T) {
+	t.Run("success - top-level settings form the default project", func(t *testing.T) {
+		cfg := FCMConfig{CredentialsFile: "creds.json", Scopes: []string{"scope"}, EndpointURL: "url"}
+
+		projects, def, err := cfg.ProjectConfigs()
+
+		require.NoError(t, err)
+		assert.Equal(t, DefaultProjectName, def)
+		assert.Equal(t, map[string]ProjectConfig{DefaultProjectName: {CredentialsFile: "creds.json", Scopes: []string{"scope"}, EndpointURL: "url"}}, projects)
+	})
+
+	t.Run("success - a single project is the default", func(t *testing.T) {
+		cfg := FCMConfig{Projects: map[string]ProjectConfig{"only": {CredentialsFile: "only.json"}}}
+
+		_, def, err := cfg.ProjectConfigs()
+
+		require.NoError(t, err)
+		assert.Equal(t, "only", def)
+	})
+
+	t.Run("success - several projects need not have a default", func(t *testing.T) {
+		cfg := FCMConfig{Projects: map[string]ProjectConfig{"a": {CredentialsFile: "a.json"}, "b": {CredentialsFile: "b.json"}}}
+
+		_, def, err := cfg.ProjectConfigs()
+
+		require.NoError(t, err)
+		assert.Empty(t, def)
+	})
+
+	tests := []struct {
+		name    string
+		cfg     FCMConfig
+		wantErr string
+	}{
+		{"error - missing credentials", FCMConfig{Projects: map[string]ProjectConfig{"a": {}}}, "fcm.projects.a.credentials_file is required"},
+		{"error - unknown default", FCMConfig{DefaultProject: "c", Projects: map[string]ProjectConfig{"a": {CredentialsFile: "a.json"}}}, `fcm.default_project "c" is not one of fcm.projects`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := tt.cfg.ProjectConfigs()
+
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
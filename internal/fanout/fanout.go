@@ -0,0 +1,36 @@
+// Package fanout runs a function over a list of items on a bounded pool of
+// goroutines.
+package fanout
+
+import "sync"
+
+// Each calls fn once for every item using at most workers goroutines and
+// returns the results in the same order as items. A workers value below 1 is
+// treated as 1.
+func Each[T, R any](items []T, workers int, fn func(item T) R) []R {
+	results := make([]R, len(items))
+	if len(items) == 0 {
+		return results
+	}
+	workers = max(1, min(workers, len(items)))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = fn(items[i])
+			}
+		}()
+	}
+
+	for i := range items {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,69 @@
+package fanout
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEach(t *testing.T) {
+	t.Run("success - results keep input order", func(t *testing.T) {
+		// --- Setup ---
+		items := []int{5, 1, 4, 2, 3}
+
+		// --- Execute ---
+		results := Each(items, 3, func(n int) int {
+			// Later items finish first so ordering cannot come from completion time.
+			time.Sleep(time.Duration(n) * time.Millisecond)
+			return n * 10
+		})
+
+		// --- Assert ---
+		assert.Equal(t, []int{50, 10, 40, 20, 30}, results)
+	})
+
+	t.Run("success - never exceeds worker limit", func(t *testing.T) {
+		// --- Setup ---
+		items := make([]int, 50)
+		var inFlight, peak atomic.Int32
+
+		// --- Execute ---
+		Each(items, 4, func(int) struct{} {
+			n := inFlight.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			inFlight.Add(-1)
+			return struct{}{}
+		})
+
+		// --- Assert ---
+		assert.LessOrEqual(t, peak.Load(), int32(4))
+		assert.Greater(t, peak.Load(), int32(1), "expected calls to run in parallel")
+	})
+
+	t.Run("success - non-positive worker count runs sequentially", func(t *testing.T) {
+		// --- Execute ---
+		results := Each([]string{"a", "b"}, 0, func(s string) string { return s + s })
+
+		// --- Assert ---
+		assert.Equal(t, []string{"aa", "bb"}, results)
+	})
+
+	t.Run("success - empty input", func(t *testing.T) {
+		// --- Execute ---
+		results := Each([]string{}, 8, func(s string) string {
+			t.Fatal("fn must not be called")
+			return s
+		})
+
+		// --- Assert ---
+		assert.Empty(t, results)
+	})
+}
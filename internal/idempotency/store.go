@@ -0,0 +1,166 @@
+// Package idempotency remembers responses by Idempotency-Key so a retried
+// request can be answered without doing the work twice.
+package idempotency
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// State is what Begin found for a key.
+type State int
+
+const (
+	// StateNew means the key was unused and is now reserved for the caller.
+	StateNew State = iota
+	// StateInProgress means another request with the key has not finished.
+	StateInProgress
+	// StateDone means a response is stored for the key.
+	StateDone
+	// StateMismatch means the key was used for a different request.
+	StateMismatch
+)
+
+// Response is a stored HTTP response.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+func (r Response) size() int64 {
+	n := len(r.Body)
+	for k, vs := range r.Header {
+		n += len(k)
+		for _, v := range vs {
+			n += len(v)
+		}
+	}
+	return int64(n)
+}
+
+type entry struct {
+	key         string
+	fingerprint string
+	done        bool
+	response    Response
+	expiresAt   time.Time
+	size        int64
+	elem        *list.Element
+}
+
+// Store keeps keys in memory for a fixed window after they were first used.
+// Once the keys and stored responses take more than maxBytes, the keys
+// closest to expiry are forgotten early.
+type Store struct {
+	window   time.Duration
+	maxBytes int64
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	// queue holds the entries in the order they were added. Every key gets
+	// the same window, so this is also the order they expire in.
+	queue *list.List
+	bytes int64
+}
+
+// NewStore returns a Store that remembers keys for window. maxBytes caps the
+// size of keys and stored responses; 0 means no limit.
+func NewStore(window time.Duration, maxBytes int64) *Store {
+	return &Store{
+		window:   window,
+		maxBytes: maxBytes,
+		now:      time.Now,
+		entries:  make(map[string]*entry),
+		queue:    list.New(),
+	}
+}
+
+// Begin looks up key. fingerprint identifies the request body, so a key
+// reused for different content is reported as StateMismatch. With StateNew
+// the caller must later call Complete or Release.
+func (s *Store) Begin(key, fingerprint string) (State, Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeLocked()
+
+	e, ok := s.entries[key]
+	switch {
+	case !ok:
+		e = &entry{
+			key:         key,
+			fingerprint: fingerprint,
+			expiresAt:   s.now().Add(s.window),
+			size:        int64(len(key) + len(fingerprint)),
+		}
+		e.elem = s.queue.PushBack(e)
+		s.entries[key] = e
+		s.bytes += e.size
+		s.evictLocked()
+		return StateNew, Response{}
+	case e.fingerprint != fingerprint:
+		return StateMismatch, Response{}
+	case !e.done:
+		return StateInProgress, Response{}
+	default:
+		return StateDone, e.response
+	}
+}
+
+// Complete stores the response for a key reserved by Begin. A response that
+// alone exceeds maxBytes is not stored and its key is freed.
+func (s *Store) Complete(key string, resp Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	size := int64(len(key)+len(e.fingerprint)) + resp.size()
+	if s.maxBytes > 0 && size > s.maxBytes {
+		s.removeLocked(e)
+		return
+	}
+	e.done = true
+	e.response = resp
+	s.bytes += size - e.size
+	e.size = size
+	s.evictLocked()
+}
+
+// Release frees a key reserved by Begin so the request can be retried.
+func (s *Store) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok && !e.done {
+		s.removeLocked(e)
+	}
+}
+
+// purgeLocked drops keys whose window has passed.
+func (s *Store) purgeLocked() {
+	now := s.now()
+	for front := s.queue.Front(); front != nil; front = s.queue.Front() {
+		e := front.Value.(*entry)
+		if now.Before(e.expiresAt) {
+			return
+		}
+		s.removeLocked(e)
+	}
+}
+
+// evictLocked drops the oldest keys until the store fits in maxBytes.
+func (s *Store) evictLocked() {
+	for s.maxBytes > 0 && s.bytes > s.maxBytes && s.queue.Len() > 0 {
+		s.removeLocked(s.queue.Front().Value.(*entry))
+	}
+}
+
+func (s *Store) removeLocked(e *entry) {
+	s.queue.Remove(e.elem)
+	delete(s.entries, e.key)
+	s.bytes -= e.size
+}
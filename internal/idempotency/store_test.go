@@ -0,0 +1,88 @@
+package idempotency
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	t.Run("success - stored response is returned for the same fingerprint", func(t *testing.T) {
+		// --- Setup ---
+		s := NewStore(time.Hour, 0)
+		resp := Response{Status: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`{}`)}
+
+		// --- Execute ---
+		first, _ := s.Begin("k", "body-1")
+		inProgress, _ := s.Begin("k", "body-1")
+		s.Complete("k", resp)
+		done, stored := s.Begin("k", "body-1")
+		mismatch, _ := s.Begin("k", "body-2")
+
+		// --- Assert ---
+		assert.Equal(t, StateNew, first)
+		assert.Equal(t, StateInProgress, inProgress)
+		assert.Equal(t, StateDone, done)
+		assert.Equal(t, resp, stored)
+		assert.Equal(t, StateMismatch, mismatch)
+	})
+
+	t.Run("success - released keys can be used again", func(t *testing.T) {
+		s := NewStore(time.Hour, 0)
+		s.Begin("k", "body-1")
+
+		s.Release("k")
+		state, _ := s.Begin("k", "body-2")
+
+		assert.Equal(t, StateNew, state)
+	})
+
+	t.Run("success - keys expire after the window", func(t *testing.T) {
+		// --- Setup ---
+		now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		s := NewStore(time.Minute, 0)
+		s.now = func() time.Time { return now }
+		s.Begin("k", "body-1")
+		s.Complete("k", Response{Status: http.StatusOK})
+
+		// --- Execute ---
+		now = now.Add(59 * time.Second)
+		beforeExpiry, _ := s.Begin("k", "body-2")
+		now = now.Add(time.Second)
+		afterExpiry, _ := s.Begin("k", "body-2")
+
+		// --- Assert ---
+		assert.Equal(t, StateMismatch, beforeExpiry)
+		assert.Equal(t, StateNew, afterExpiry)
+	})
+	t.Run("success - oldest keys are forgotten once max bytes is reached", func(t *testing.T) {
+		// --- Setup ---
+		s := NewStore(time.Hour, 30)
+		for _, key := range []string{"k1", "k2"} {
+			s.Begin(key, "fp")
+			s.Complete(key, Response{Status: http.StatusOK, Body: []byte("0123456789")})
+		}
+
+		// --- Execute ---
+		s.Begin("k3", "fp")
+
+		// --- Assert ---
+		first, _ := s.Begin("k1", "other")
+		second, _ := s.Begin("k2", "other")
+		assert.Equal(t, StateNew, first)
+		assert.Equal(t, StateMismatch, second)
+		assert.LessOrEqual(t, s.bytes, int64(30))
+	})
+
+	t.Run("success - a response larger than max bytes is not stored", func(t *testing.T) {
+		s := NewStore(time.Hour, 10)
+		s.Begin("k", "fp")
+
+		s.Complete("k", Response{Status: http.StatusOK, Body: []byte("0123456789")})
+		state, _ := s.Begin("k", "fp")
+
+		assert.Equal(t, StateNew, state)
+	})
+}
@@ -0,0 +1,17 @@
+// Package ids makes up the random IDs of jobs, schedules and callback
+// deliveries.
+package ids
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// New returns 32 random hex digits.
+func New() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
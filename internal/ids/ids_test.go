@@ -0,0 +1,20 @@
+package ids
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("success - returns distinct hex IDs", func(t *testing.T) {
+		first, err := New()
+		require.NoError(t, err)
+		second, err := New()
+		require.NoError(t, err)
+
+		assert.Regexp(t, "^[0-9a-f]{32}$", first)
+		assert.NotEqual(t, first, second)
+	})
+}
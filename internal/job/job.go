@@ -0,0 +1,102 @@
+// Package job runs large device-token sends in the background and keeps
+// their progress around so callers can poll for it.
+package job
+
+import (
+	"slices"
+	"time"
+
+	"github.com/wirsal/fcm-gateway/fcm"
+)
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+)
+
+// Job is a send of one message to a list of device tokens.
+type Job struct {
+	ID     string `json:"id"`
+	Status Status `json:"status"`
+	DryRun bool   `json:"dry_run"`
+	// Project is the Firebase project the job sends through; empty means
+	// the default project.
+	Project string `json:"project,omitempty"`
+	// CallbackURL receives the results once the job finished.
+	CallbackURL string `json:"callback_url,omitempty"`
+
+	Total        int `json:"total"`
+	Processed    int `json:"processed"`
+	SuccessCount int `json:"success_count"`
+	FailureCount int `json:"failure_count"`
+	// Results holds the outcome of every processed token, in the order the
+	// tokens were submitted.
+	Results []fcm.TokenResult `json:"results"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// ExpiresAt is when a finished job is forgotten.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	Tokens  []string    `json:"-"`
+	Message fcm.Message `json:"-"`
+	// results is indexed like Tokens; done marks the indexes already sent.
+	results []fcm.TokenResult
+	done    []bool
+}
+
+func newJob(id string, tokens []string, msg fcm.Message, dryRun bool, callbackURL string, now time.Time) *Job {
+	return &Job{
+		ID:          id,
+		Status:      StatusQueued,
+		DryRun:      dryRun,
+		CallbackURL: callbackURL,
+		Total:       len(tokens),
+		CreatedAt:   now,
+		Tokens:      tokens,
+		Message:     msg,
+		results:     make([]fcm.TokenResult, len(tokens)),
+		done:        make([]bool, len(tokens)),
+	}
+}
+
+// pending returns the indexes of tokens that have not been sent yet.
+func (j *Job) pending() []int {
+	var idx []int
+	for i, done := range j.done {
+		if !done {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+func (j *Job) record(i int, res fcm.TokenResult) {
+	j.results[i] = res
+	j.done[i] = true
+	j.Processed++
+	if res.Error != "" {
+		j.FailureCount++
+	} else {
+		j.SuccessCount++
+	}
+}
+
+// snapshot returns a copy of j that is safe to hand out while the job keeps
+// running.
+func (j *Job) snapshot() Job {
+	s := *j
+	s.Tokens = slices.Clone(j.Tokens)
+	s.results, s.done = nil, nil
+	s.Results = make([]fcm.TokenResult, 0, j.Processed)
+	for i, done := range j.done {
+		if done {
+			s.Results = append(s.Results, j.results[i])
+		}
+	}
+	return s
+}
@@ -0,0 +1,363 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/wirsal/fcm-gateway/fcm"
+	"github.com/wirsal/fcm-gateway/internal/fanout"
+	"github.com/wirsal/fcm-gateway/internal/ids"
+	"github.com/wirsal/fcm-gateway/internal/logging"
+)
+
+// ErrQueueFull is returned by Submit when no more jobs can be queued.
+var ErrQueueFull = errors.New("job queue is full")
+
+// ErrShuttingDown is returned by Submit once Shutdown was called.
+var ErrShuttingDown = errors.New("job manager is shutting down")
+
+// Sender delivers a message to one device token. *fcm.Service and
+// *fcm.Projects implement it; the latter sends through the project of the
+// job, see fcm.WithProject.
+type Sender interface {
+	SendNotification(ctx context.Context, token string, msg fcm.Message, validateOnly bool) (fcm.SendResult, error)
+}
+
+type Config struct {
+	// Workers is how many jobs run at the same time.
+	Workers int
+	// QueueSize is how many jobs may wait for a worker.
+	QueueSize int
+	// Concurrency is how many tokens of a single job are sent in parallel.
+	Concurrency int
+	// Retention is how long a finished job can still be looked up.
+	Retention time.Duration
+	// Store persists jobs so they survive a restart. Jobs are kept in memory
+	// only when it is nil.
+	Store Store
+	// OnFinish, when set, is called with every job that finished.
+	OnFinish func(Job)
+}
+
+// Manager queues jobs and runs them on a fixed pool of workers.
+type Manager struct {
+	sender Sender
+	cfg    Config
+	store  Store
+	now    func() time.Time
+
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	queue chan *Job
+	// resume holds recovered jobs until Start queues them.
+	resume []*Job
+	// cancel stops the jobs started by Start.
+	cancel context.CancelFunc
+
+	// stop is closed by Shutdown; workers pick up no jobs after that.
+	stop     chan struct{}
+	stopOnce sync.Once
+	workers  sync.WaitGroup
+}
+
+func NewManager(sender Sender, cfg Config) *Manager {
+	store := cfg.Store
+	if store == nil {
+		store = nopStore{}
+	}
+	return &Manager{
+		sender: sender,
+		cfg:    cfg,
+		store:  store,
+		now:    time.Now,
+		jobs:   make(map[string]*Job),
+		queue:  make(chan *Job, max(1, cfg.QueueSize)),
+		stop:   make(chan struct{}),
+	}
+}
+
+// Recover loads the jobs kept in the store. Finished jobs can be looked up
+// again and unfinished ones are queued by Start. It returns how many jobs
+// will be resumed and must be called before Start.
+func (m *Manager) Recover() (int, error) {
+	jobs, err := m.store.Load()
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, j := range jobs {
+		if j.FinishedAt != nil {
+			expires := j.FinishedAt.Add(m.cfg.Retention)
+			j.ExpiresAt = &expires
+		} else {
+			m.resume = append(m.resume, j)
+		}
+		m.jobs[j.ID] = j
+	}
+	m.purgeLocked()
+	return len(m.resume), nil
+}
+
+// Start launches the workers. They stop picking up jobs once ctx is done or
+// Shutdown is called.
+func (m *Manager) Start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	m.mu.Lock()
+	m.cancel = cancel
+	resume := m.resume
+	m.resume = nil
+	m.mu.Unlock()
+
+	for range max(1, m.cfg.Workers) {
+		m.workers.Add(1)
+		go func() {
+			defer m.workers.Done()
+			m.work(ctx)
+		}()
+	}
+
+	if len(resume) > 0 {
+		// Recovered jobs may not all fit in the queue, so they are fed to it
+		// as workers free up.
+		go func() {
+			for _, j := range resume {
+				select {
+				case <-ctx.Done():
+					return
+				case <-m.stop:
+					return
+				case m.queue <- j:
+				}
+			}
+		}()
+	}
+}
+
+// Submit queues a send of msg to tokens through project and returns the new
+// job. project and callbackURL may be empty; callbackURL is kept on the job
+// for OnFinish.
+func (m *Manager) Submit(project string, tokens []string, msg fcm.Message, dryRun bool, callbackURL string) (Job, error) {
+	if m.stopping() {
+		return Job{}, ErrShuttingDown
+	}
+	id, err := ids.New()
+	if err != nil {
+		return Job{}, err
+	}
+
+	j := newJob(id, tokens, msg, dryRun, callbackURL, m.now())
+	j.Project = project
+	if err := m.store.Create(j); err != nil {
+		return Job{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.purgeLocked()
+
+	select {
+	case m.queue <- j:
+	default:
+		m.deleteStored(id)
+		return Job{}, ErrQueueFull
+	}
+	m.jobs[id] = j
+	return j.snapshot(), nil
+}
+
+// Get returns the current state of a job. Jobs are forgotten once their
+// retention time has passed.
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.purgeLocked()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return j.snapshot(), true
+}
+
+// Shutdown stops the workers from picking up more jobs and waits for the
+// running ones to finish. When ctx is done first, the running jobs are
+// cancelled and Shutdown returns ctx's error. Jobs that did not finish stay
+// pending in the Store, to be resumed by Recover after a restart; without a
+// Store they finish with their unsent tokens reported as
+// fcm.ErrorCodeNotAttempted.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.stopOnce.Do(func() { close(m.stop) })
+
+	done := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		m.mu.Lock()
+		cancel := m.cancel
+		m.mu.Unlock()
+		if cancel != nil {
+			cancel()
+		}
+		<-done
+	}
+
+	m.leaveUnfinished()
+	return err
+}
+
+func (m *Manager) stopping() bool {
+	select {
+	case <-m.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// leaveUnfinished reports the jobs that had not finished at shutdown.
+func (m *Manager) leaveUnfinished() {
+	m.mu.Lock()
+	var unfinished []*Job
+	for _, j := range m.jobs {
+		if j.FinishedAt == nil {
+			unfinished = append(unfinished, j)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, j := range unfinished {
+		m.mu.Lock()
+		pending := j.pending()
+		if m.cfg.Store == nil {
+			for _, i := range pending {
+				j.record(i, fcm.NotAttempted(j.Tokens[i], ErrShuttingDown, j.DryRun))
+			}
+		}
+		m.mu.Unlock()
+
+		if m.cfg.Store != nil {
+			slog.Warn("Job left unfinished at shutdown, it resumes after a restart", "job_id", j.ID, "pending", len(pending))
+			continue
+		}
+		slog.Warn("Job cut short by shutdown", "job_id", j.ID, "not_attempted", len(pending))
+		m.finish(j)
+	}
+}
+
+func (m *Manager) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.stop:
+			return
+		case j := <-m.queue:
+			// A job taken while Shutdown was called is left to it.
+			if m.stopping() {
+				return
+			}
+			m.run(ctx, j)
+		}
+	}
+}
+
+func (m *Manager) run(ctx context.Context, j *Job) {
+	m.mu.Lock()
+	started := m.now()
+	j.Status = StatusRunning
+	j.StartedAt = &started
+	pending := j.pending()
+	m.mu.Unlock()
+
+	sendCtx := fcm.WithProject(ctx, j.Project)
+	fanout.Each(pending, m.cfg.Concurrency, func(i int) struct{} {
+		// Tokens left over at shutdown are left to Shutdown.
+		if ctx.Err() != nil {
+			return struct{}{}
+		}
+		token := j.Tokens[i]
+		if err := m.store.MarkStarted(j.ID, i, m.now()); err != nil {
+			slog.Error("Failed to persist job progress", "job_id", j.ID, "error", err)
+		}
+		res, err := m.sender.SendNotification(sendCtx, token, j.Message, j.DryRun)
+		if err != nil && ctx.Err() != nil {
+			// Whether FCM got the message is unknown; on recovery the token
+			// is reported as interrupted instead of being sent again.
+			if m.cfg.Store == nil {
+				m.mu.Lock()
+				j.record(i, fcm.TokenResult{
+					Token:        token,
+					Attempts:     res.Attempts,
+					Error:        "send was interrupted by shutdown; delivery status is unknown",
+					ErrorCode:    ErrorCodeInterrupted,
+					ValidateOnly: j.DryRun,
+				})
+				m.mu.Unlock()
+			}
+			return struct{}{}
+		}
+		if err != nil {
+			slog.Warn("Job failed to send to token", "job_id", j.ID, "token", logging.Token(token), "attempts", res.Attempts, "error", err)
+		}
+
+		result := fcm.NewTokenResult(token, res, err)
+		if err := m.store.SaveResult(j.ID, i, result, fcm.IsRetryable(err)); err != nil {
+			slog.Error("Failed to persist job progress", "job_id", j.ID, "error", err)
+		}
+		m.mu.Lock()
+		j.record(i, result)
+		m.mu.Unlock()
+		return struct{}{}
+	})
+	if ctx.Err() != nil {
+		return
+	}
+	m.finish(j)
+}
+
+// finish marks j as completed and hands it to OnFinish.
+func (m *Manager) finish(j *Job) {
+	finished := m.now()
+	if err := m.store.Finish(j.ID, finished); err != nil {
+		slog.Error("Failed to persist job completion", "job_id", j.ID, "error", err)
+	}
+	m.mu.Lock()
+	expires := finished.Add(m.cfg.Retention)
+	j.Status = StatusCompleted
+	j.FinishedAt = &finished
+	j.ExpiresAt = &expires
+	snapshot := j.snapshot()
+	m.mu.Unlock()
+
+	if m.cfg.OnFinish != nil {
+		m.cfg.OnFinish(snapshot)
+	}
+}
+
+// purgeLocked drops finished jobs whose retention has passed.
+func (m *Manager) purgeLocked() {
+	now := m.now()
+	for id, j := range m.jobs {
+		if j.ExpiresAt != nil && !now.Before(*j.ExpiresAt) {
+			delete(m.jobs, id)
+			m.deleteStored(id)
+		}
+	}
+}
+
+func (m *Manager) deleteStored(id string) {
+	if err := m.store.Delete(id); err != nil {
+		slog.Error("Failed to delete job from store", "job_id", id, "error", err)
+	}
+}
@@ -0,0 +1,319 @@
+package job
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wirsal/fcm-gateway/fcm"
+)
+
+// fakeSender fails every token listed in fail and accepts the rest.
+type fakeSender struct {
+	mu    sync.Mutex
+	fail  map[string]error
+	calls []string
+	// projects holds the project each call was sent through.
+	projects []string
+	// block, when set, holds every send until it is closed or ctx is done.
+	block chan struct{}
+}
+
+func (f *fakeSender) SendNotification(ctx context.Context, token string, msg fcm.Message, validateOnly bool) (fcm.SendResult, error) {
+	if f.block != nil {
+		select {
+		case <-f.block:
+		case <-ctx.Done():
+			return fcm.SendResult{Attempts: 1}, ctx.Err()
+		}
+	}
+	f.mu.Lock()
+	f.calls = append(f.calls, token)
+	f.projects = append(f.projects, fcm.ProjectFromContext(ctx))
+	f.mu.Unlock()
+
+	if err := f.fail[token]; err != nil {
+		return fcm.SendResult{Attempts: 1, ValidateOnly: validateOnly}, err
+	}
+	return fcm.SendResult{Name: "projects/p/messages/" + token, Attempts: 1, ValidateOnly: validateOnly}, nil
+}
+
+// waitCompleted polls until job id has finished.
+func waitCompleted(t *testing.T, m *Manager, id string) Job {
+	t.Helper()
+	var j Job
+	require.Eventually(t, func() bool {
+		var ok bool
+		j, ok = m.Get(id)
+		return ok && j.Status == StatusCompleted
+	}, 2*time.Second, 5*time.Millisecond)
+	return j
+}
+
+func TestManager(t *testing.T) {
+	t.Run("success - runs a job and reports results in token order", func(t *testing.T) {
+		// --- Setup ---
+		sender := &fakeSender{fail: map[string]error{"bad": &fcm.Error{StatusCode: 404, Code: fcm.ErrorCodeUnregistered}}}
+		m := NewManager(sender, Config{Workers: 1, QueueSize: 10, Concurrency: 3, Retention: time.Hour})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		m.Start(ctx)
+
+		// --- Execute ---
+		submitted, err := m.Submit("", []string{"a", "bad", "c", "d"}, fcm.Message{Data: map[string]string{"k": "v"}}, true, "")
+		require.NoError(t, err)
+		j := waitCompleted(t, m, submitted.ID)
+
+		// --- Assert ---
+		assert.Equal(t, StatusQueued, submitted.Status)
+		assert.Equal(t, 4, j.Total)
+		assert.Equal(t, 4, j.Processed)
+		assert.Equal(t, 3, j.SuccessCount)
+		assert.Equal(t, 1, j.FailureCount)
+		assert.True(t, j.DryRun)
+		require.Len(t, j.Results, 4)
+		for i, token := range []string{"a", "bad", "c", "d"} {
+			assert.Equal(t, token, j.Results[i].Token)
+			assert.True(t, j.Results[i].ValidateOnly)
+		}
+		assert.Equal(t, fcm.ErrorCodeUnregistered, j.Results[1].ErrorCode)
+		assert.Equal(t, "projects/p/messages/a", j.Results[0].MessageName)
+		require.NotNil(t, j.StartedAt)
+		require.NotNil(t, j.FinishedAt)
+		require.NotNil(t, j.ExpiresAt)
+		assert.Equal(t, j.FinishedAt.Add(time.Hour), *j.ExpiresAt)
+	})
+
+	t.Run("success - sends through the job's project", func(t *testing.T) {
+		// --- Setup ---
+		sender := &fakeSender{}
+		m := NewManager(sender, Config{Workers: 1, QueueSize: 10, Concurrency: 2, Retention: time.Hour})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		m.Start(ctx)
+
+		// --- Execute ---
+		submitted, err := m.Submit("brand-b", []string{"a", "b"}, fcm.Message{}, false, "")
+		require.NoError(t, err)
+		j := waitCompleted(t, m, submitted.ID)
+
+		// --- Assert ---
+		assert.Equal(t, "brand-b", j.Project)
+		sender.mu.Lock()
+		defer sender.mu.Unlock()
+		assert.Equal(t, []string{"brand-b", "brand-b"}, sender.projects)
+	})
+
+	t.Run("success - OnFinish receives the finished job", func(t *testing.T) {
+		// --- Setup ---
+		finished := make(chan Job, 1)
+		m := NewManager(&fakeSender{}, Config{Workers: 1, QueueSize: 10, OnFinish: func(j Job) { finished <- j }})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		m.Start(ctx)
+
+		// --- Execute ---
+		submitted, err := m.Submit("", []string{"a", "b"}, fcm.Message{}, false, "https://example.com/hook")
+		require.NoError(t, err)
+
+		// --- Assert ---
+		select {
+		case j := <-finished:
+			assert.Equal(t, submitted.ID, j.ID)
+			assert.Equal(t, StatusCompleted, j.Status)
+			assert.Equal(t, "https://example.com/hook", j.CallbackURL)
+			assert.Len(t, j.Results, 2)
+		case <-time.After(2 * time.Second):
+			t.Fatal("OnFinish was not called")
+		}
+	})
+
+	t.Run("success - progress is visible while the job runs", func(t *testing.T) {
+		// --- Setup ---
+		sender := &fakeSender{block: make(chan struct{})}
+		m := NewManager(sender, Config{Workers: 1, QueueSize: 10, Concurrency: 1, Retention: time.Hour})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		m.Start(ctx)
+
+		// --- Execute ---
+		submitted, err := m.Submit("", []string{"a", "b"}, fcm.Message{}, false, "")
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			j, _ := m.Get(submitted.ID)
+			return j.Status == StatusRunning
+		}, time.Second, 5*time.Millisecond)
+		running, _ := m.Get(submitted.ID)
+		close(sender.block)
+		done := waitCompleted(t, m, submitted.ID)
+
+		// --- Assert ---
+		assert.Equal(t, 0, running.Processed)
+		assert.Empty(t, running.Results)
+		assert.Nil(t, running.FinishedAt)
+		assert.Equal(t, 2, done.Processed)
+	})
+
+	t.Run("success - finished jobs are forgotten after retention", func(t *testing.T) {
+		// --- Setup ---
+		now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		var clockMu sync.Mutex
+		m := NewManager(&fakeSender{}, Config{Workers: 1, QueueSize: 10, Retention: time.Minute})
+		m.now = func() time.Time {
+			clockMu.Lock()
+			defer clockMu.Unlock()
+			return now
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		m.Start(ctx)
+
+		submitted, err := m.Submit("", []string{"a"}, fcm.Message{}, false, "")
+		require.NoError(t, err)
+		waitCompleted(t, m, submitted.ID)
+
+		// --- Execute ---
+		clockMu.Lock()
+		now = now.Add(59 * time.Second)
+		clockMu.Unlock()
+		_, beforeExpiry := m.Get(submitted.ID)
+
+		clockMu.Lock()
+		now = now.Add(time.Second)
+		clockMu.Unlock()
+		_, afterExpiry := m.Get(submitted.ID)
+
+		// --- Assert ---
+		assert.True(t, beforeExpiry)
+		assert.False(t, afterExpiry)
+	})
+
+	t.Run("error - queue full", func(t *testing.T) {
+		// --- Setup ---
+		// No workers are started, so the queue is never drained.
+		m := NewManager(&fakeSender{}, Config{QueueSize: 1})
+
+		// --- Execute ---
+		_, err1 := m.Submit("", []string{"a"}, fcm.Message{}, false, "")
+		_, err2 := m.Submit("", []string{"b"}, fcm.Message{}, false, "")
+
+		// --- Assert ---
+		assert.NoError(t, err1)
+		assert.ErrorIs(t, err2, ErrQueueFull)
+	})
+
+	t.Run("error - unknown job", func(t *testing.T) {
+		m := NewManager(&fakeSender{}, Config{})
+
+		_, ok := m.Get("does-not-exist")
+
+		assert.False(t, ok)
+	})
+}
+
+func TestManager_Shutdown(t *testing.T) {
+	// waitRunning polls until job id has started.
+	waitRunning := func(t *testing.T, m *Manager, id string) {
+		t.Helper()
+		require.Eventually(t, func() bool {
+			j, _ := m.Get(id)
+			return j.Status == StatusRunning
+		}, time.Second, 5*time.Millisecond)
+	}
+
+	t.Run("success - waits for running jobs to finish", func(t *testing.T) {
+		// --- Setup ---
+		sender := &fakeSender{block: make(chan struct{})}
+		m := NewManager(sender, Config{Workers: 1, QueueSize: 10, Concurrency: 1, Retention: time.Hour})
+		m.Start(context.Background())
+		submitted, err := m.Submit("", []string{"a", "b"}, fcm.Message{}, false, "")
+		require.NoError(t, err)
+		waitRunning(t, m, submitted.ID)
+
+		// --- Execute ---
+		done := make(chan error, 1)
+		go func() { done <- m.Shutdown(context.Background()) }()
+		require.Eventually(t, m.stopping, time.Second, time.Millisecond)
+		_, errSubmit := m.Submit("", []string{"c"}, fcm.Message{}, false, "")
+		close(sender.block)
+
+		// --- Assert ---
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Shutdown did not return")
+		}
+		assert.ErrorIs(t, errSubmit, ErrShuttingDown)
+		j, _ := m.Get(submitted.ID)
+		assert.Equal(t, StatusCompleted, j.Status)
+		assert.Equal(t, 2, j.SuccessCount)
+	})
+
+	t.Run("success - without a store, tokens cut off are reported", func(t *testing.T) {
+		// --- Setup ---
+		finished := make(chan Job, 2)
+		sender := &fakeSender{block: make(chan struct{})}
+		m := NewManager(sender, Config{Workers: 1, QueueSize: 10, Concurrency: 1, Retention: time.Hour, OnFinish: func(j Job) { finished <- j }})
+		m.Start(context.Background())
+		running, err := m.Submit("", []string{"a", "b"}, fcm.Message{}, false, "")
+		require.NoError(t, err)
+		queued, err := m.Submit("", []string{"c"}, fcm.Message{}, true, "")
+		require.NoError(t, err)
+		waitRunning(t, m, running.ID)
+
+		// --- Execute ---
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		err = m.Shutdown(ctx)
+
+		// --- Assert ---
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Len(t, finished, 2)
+
+		j, _ := m.Get(running.ID)
+		assert.Equal(t, StatusCompleted, j.Status)
+		require.Len(t, j.Results, 2)
+		assert.Equal(t, ErrorCodeInterrupted, j.Results[0].ErrorCode, "a was in flight")
+		assert.Equal(t, fcm.ErrorCodeNotAttempted, j.Results[1].ErrorCode)
+
+		j, _ = m.Get(queued.ID)
+		assert.Equal(t, StatusCompleted, j.Status)
+		require.Len(t, j.Results, 1)
+		assert.Equal(t, fcm.ErrorCodeNotAttempted, j.Results[0].ErrorCode)
+		assert.True(t, j.Results[0].ValidateOnly)
+		assert.Empty(t, sender.calls, "nothing reached FCM")
+	})
+
+	t.Run("success - with a store, unfinished jobs resume after a restart", func(t *testing.T) {
+		// --- Setup ---
+		store, err := NewFileStore(t.TempDir())
+		require.NoError(t, err)
+		sender := &fakeSender{block: make(chan struct{})}
+		m := NewManager(sender, Config{Workers: 1, QueueSize: 10, Concurrency: 1, Retention: time.Hour, Store: store})
+		m.Start(context.Background())
+		submitted, err := m.Submit("", []string{"a", "b"}, fcm.Message{}, false, "")
+		require.NoError(t, err)
+		waitRunning(t, m, submitted.ID)
+
+		// --- Execute ---
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		err = m.Shutdown(ctx)
+		restarted := NewManager(&fakeSender{}, Config{Workers: 1, QueueSize: 10, Retention: time.Hour, Store: store})
+		resumed, errRecover := restarted.Recover()
+		require.NoError(t, errRecover)
+		restarted.Start(context.Background())
+		j := waitCompleted(t, restarted, submitted.ID)
+
+		// --- Assert ---
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 1, resumed)
+		require.Len(t, j.Results, 2)
+		assert.Equal(t, ErrorCodeInterrupted, j.Results[0].ErrorCode, "a was in flight")
+		assert.Empty(t, j.Results[1].Error, "b is sent after the restart")
+	})
+}
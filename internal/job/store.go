@@ -0,0 +1,292 @@
+package job
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wirsal/fcm-gateway/fcm"
+)
+
+// ErrorCodeInterrupted marks a token whose send was in flight when the
+// gateway stopped. FCM may or may not have delivered it, so it is not sent
+// again.
+const ErrorCodeInterrupted = "INTERRUPTED"
+
+// Store records job progress so unfinished jobs can be resumed after a
+// restart. Every method must be safe for concurrent use.
+type Store interface {
+	// Create records a new job with its tokens and message.
+	Create(j *Job) error
+	// MarkStarted records that token i is about to be sent.
+	MarkStarted(id string, i int, at time.Time) error
+	// SaveResult records the outcome of token i. retryable tells whether a
+	// failed token may be sent again after a restart.
+	SaveResult(id string, i int, res fcm.TokenResult, retryable bool) error
+	// Finish records that every token of the job was processed.
+	Finish(id string, at time.Time) error
+	// Delete forgets a job.
+	Delete(id string) error
+	// Load returns every recorded job. Unfinished jobs come back queued with
+	// only the tokens that still have to be sent left pending.
+	Load() ([]*Job, error)
+}
+
+// nopStore is used when no Store is configured; jobs then live in memory only.
+type nopStore struct{}
+
+func (nopStore) Create(*Job) error                                   { return nil }
+func (nopStore) MarkStarted(string, int, time.Time) error            { return nil }
+func (nopStore) SaveResult(string, int, fcm.TokenResult, bool) error { return nil }
+func (nopStore) Finish(string, time.Time) error                      { return nil }
+func (nopStore) Delete(string) error                                 { return nil }
+func (nopStore) Load() ([]*Job, error)                               { return nil, nil }
+
+type eventType string
+
+const (
+	eventCreated  eventType = "created"
+	eventStarted  eventType = "started"
+	eventResult   eventType = "result"
+	eventFinished eventType = "finished"
+)
+
+// event is one line of a job's log in a FileStore.
+type event struct {
+	Type eventType `json:"type"`
+	At   time.Time `json:"at,omitzero"`
+
+	// Set on created.
+	ID          string       `json:"id,omitempty"`
+	Tokens      []string     `json:"tokens,omitempty"`
+	Message     *fcm.Message `json:"message,omitempty"`
+	DryRun      bool         `json:"dry_run,omitempty"`
+	Project     string       `json:"project,omitempty"`
+	CallbackURL string       `json:"callback_url,omitempty"`
+
+	// Set on started and result.
+	Index     int              `json:"index"`
+	Result    *fcm.TokenResult `json:"result,omitempty"`
+	Retryable bool             `json:"retryable,omitempty"`
+}
+
+// FileStore keeps one append-only JSON Lines file per job in a directory.
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileStore stores jobs in dir, creating it if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create job store dir: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id+".jsonl")
+}
+
+func (s *FileStore) Create(j *Job) error {
+	msg := j.Message
+	return s.append(j.ID, true, os.O_CREATE|os.O_EXCL, event{
+		Type:        eventCreated,
+		At:          j.CreatedAt,
+		ID:          j.ID,
+		Tokens:      j.Tokens,
+		Message:     &msg,
+		DryRun:      j.DryRun,
+		Project:     j.Project,
+		CallbackURL: j.CallbackURL,
+	})
+}
+
+func (s *FileStore) MarkStarted(id string, i int, at time.Time) error {
+	return s.append(id, true, 0, event{Type: eventStarted, At: at, Index: i})
+}
+
+func (s *FileStore) SaveResult(id string, i int, res fcm.TokenResult, retryable bool) error {
+	return s.append(id, false, 0, event{Type: eventResult, Index: i, Result: &res, Retryable: retryable})
+}
+
+func (s *FileStore) Finish(id string, at time.Time) error {
+	return s.append(id, true, 0, event{Type: eventFinished, At: at})
+}
+
+func (s *FileStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// append writes e to the job's log. Job creation, completion and every
+// started send are synced to disk, so a token whose send may have reached FCM
+// is never sent again after a crash, not even after a power loss. Results are
+// not synced: losing one at worst turns it into an interrupted send. The sync
+// runs outside s.mu so parallel sends of a job do not wait for each other's.
+func (s *FileStore) append(id string, durable bool, flag int, e event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	f, err := s.write(id, flag, line)
+	if err != nil {
+		return err
+	}
+	if durable {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// write appends line to the job's log and returns the still open file.
+func (s *FileStore) write(id string, flag int, line []byte) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path(id), os.O_WRONLY|os.O_APPEND|flag, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *FileStore) Load() ([]*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*Job
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".jsonl") {
+			continue
+		}
+		j, err := s.load(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("load job %s: %w", entry.Name(), err)
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+func (s *FileStore) load(path string) (*Job, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []event
+	var complete int64
+	r := bufio.NewReader(bytes.NewReader(data))
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				// A line without a newline was cut short by a crash. Cut it
+				// off, or the next event of the resumed job would be
+				// appended to it and make the log unreadable.
+				if err := os.Truncate(path, complete); err != nil {
+					return nil, err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var e event
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+		complete += int64(len(line))
+	}
+	return replay(events)
+}
+
+// tokenState is what a job's log says about one token.
+type tokenState struct {
+	inFlight  bool
+	res       *fcm.TokenResult
+	retryable bool
+}
+
+// replay rebuilds a job from its log.
+func replay(events []event) (*Job, error) {
+	if len(events) == 0 || events[0].Type != eventCreated || events[0].Message == nil {
+		return nil, errors.New("log does not start with a created event")
+	}
+	created := events[0]
+	j := newJob(created.ID, created.Tokens, *created.Message, created.DryRun, created.CallbackURL, created.At)
+	j.Project = created.Project
+
+	states := make([]tokenState, len(j.Tokens))
+	for _, e := range events[1:] {
+		if e.Type != eventFinished && (e.Index < 0 || e.Index >= len(j.Tokens)) {
+			return nil, fmt.Errorf("token index %d out of range", e.Index)
+		}
+		switch e.Type {
+		case eventStarted:
+			states[e.Index].inFlight = true
+			if j.StartedAt == nil {
+				at := e.At
+				j.StartedAt = &at
+			}
+		case eventResult:
+			states[e.Index] = tokenState{res: e.Result, retryable: e.Retryable}
+		case eventFinished:
+			at := e.At
+			j.FinishedAt = &at
+		}
+	}
+
+	finished := j.FinishedAt != nil
+	for i, st := range states {
+		switch {
+		case st.inFlight:
+			j.record(i, fcm.TokenResult{
+				Token:        j.Tokens[i],
+				Error:        "send was interrupted by a restart; delivery status is unknown",
+				ErrorCode:    ErrorCodeInterrupted,
+				ValidateOnly: j.DryRun,
+			})
+		case st.res == nil:
+			// Never sent.
+		case !finished && st.res.Error != "" && st.retryable:
+			// Left pending so the token is sent again.
+		default:
+			j.record(i, *st.res)
+		}
+	}
+
+	if finished {
+		j.Status = StatusCompleted
+	} else {
+		j.Status = StatusQueued
+	}
+	return j, nil
+}
@@ -0,0 +1,236 @@
+package job
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wirsal/fcm-gateway/fcm"
+)
+
+// seedUnfinished stores a job that stopped halfway: "ok" succeeded, "retry"
+// failed with a retryable error, "gone" failed for good, "inflight" was being
+// sent and "todo" was never started.
+func seedUnfinished(t *testing.T, store *FileStore) *Job {
+	t.Helper()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	j := newJob("job1", []string{"ok", "retry", "gone", "inflight", "todo"}, fcm.Message{Data: map[string]string{"k": "v"}}, false, "", now)
+	require.NoError(t, store.Create(j))
+
+	for i := range 4 {
+		require.NoError(t, store.MarkStarted(j.ID, i, now))
+	}
+	require.NoError(t, store.SaveResult(j.ID, 0, fcm.TokenResult{Token: "ok", MessageName: "projects/p/messages/1", Attempts: 1}, false))
+	require.NoError(t, store.SaveResult(j.ID, 1, fcm.TokenResult{Token: "retry", Attempts: 3, Error: "unavailable", ErrorCode: fcm.ErrorCodeUnavailable}, true))
+	require.NoError(t, store.SaveResult(j.ID, 2, fcm.TokenResult{Token: "gone", Attempts: 1, Error: "unregistered", ErrorCode: fcm.ErrorCodeUnregistered}, false))
+	return j
+}
+
+func TestFileStore(t *testing.T) {
+	t.Run("success - finished job comes back completed", func(t *testing.T) {
+		// --- Setup ---
+		store, err := NewFileStore(t.TempDir())
+		require.NoError(t, err)
+		badge := 0
+		msg := fcm.Message{
+			Notification: &fcm.Notification{Title: "t"},
+			Apns:         &fcm.ApnsConfig{Payload: &fcm.ApnsPayload{Aps: fcm.ApnsAps{Badge: &badge}, CustomData: map[string]any{"x": "y"}}},
+		}
+		created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		j := newJob("job1", []string{"a", "b"}, msg, true, "https://example.com/hook", created)
+		j.Project = "brand-b"
+		require.NoError(t, store.Create(j))
+		for i, token := range j.Tokens {
+			require.NoError(t, store.MarkStarted(j.ID, i, created))
+			require.NoError(t, store.SaveResult(j.ID, i, fcm.TokenResult{Token: token, Attempts: 1, ValidateOnly: true}, false))
+		}
+		require.NoError(t, store.Finish(j.ID, created.Add(time.Second)))
+
+		// --- Execute ---
+		jobs, err := store.Load()
+
+		// --- Assert ---
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		got := jobs[0]
+		assert.Equal(t, StatusCompleted, got.Status)
+		assert.True(t, got.DryRun)
+		assert.Equal(t, msg, got.Message)
+		assert.Equal(t, "https://example.com/hook", got.CallbackURL)
+		assert.Equal(t, "brand-b", got.Project)
+		assert.Equal(t, 2, got.SuccessCount)
+		assert.Empty(t, got.pending())
+		require.NotNil(t, got.FinishedAt)
+		assert.Equal(t, created.Add(time.Second), *got.FinishedAt)
+	})
+
+	t.Run("success - unfinished job only resends retryable and unsent tokens", func(t *testing.T) {
+		// --- Setup ---
+		store, err := NewFileStore(t.TempDir())
+		require.NoError(t, err)
+		seedUnfinished(t, store)
+
+		// --- Execute ---
+		jobs, err := store.Load()
+
+		// --- Assert ---
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		got := jobs[0]
+		assert.Equal(t, StatusQueued, got.Status)
+		assert.Nil(t, got.FinishedAt)
+		assert.Equal(t, []int{1, 4}, got.pending())
+		assert.Equal(t, 3, got.Processed)
+		assert.Equal(t, 1, got.SuccessCount)
+		assert.Equal(t, 2, got.FailureCount)
+		assert.Equal(t, ErrorCodeInterrupted, got.results[3].ErrorCode)
+		assert.Equal(t, fcm.ErrorCodeUnregistered, got.results[2].ErrorCode)
+	})
+
+	t.Run("success - token resent after recovery and cut off again is interrupted", func(t *testing.T) {
+		// --- Setup ---
+		store, err := NewFileStore(t.TempDir())
+		require.NoError(t, err)
+		j := seedUnfinished(t, store)
+		require.NoError(t, store.MarkStarted(j.ID, 1, time.Now()))
+
+		// --- Execute ---
+		jobs, err := store.Load()
+
+		// --- Assert ---
+		require.NoError(t, err)
+		assert.Equal(t, []int{4}, jobs[0].pending())
+		assert.Equal(t, ErrorCodeInterrupted, jobs[0].results[1].ErrorCode)
+	})
+
+	t.Run("success - ignores a truncated last line", func(t *testing.T) {
+		// --- Setup ---
+		dir := t.TempDir()
+		store, err := NewFileStore(dir)
+		require.NoError(t, err)
+		j := seedUnfinished(t, store)
+		f, err := os.OpenFile(filepath.Join(dir, j.ID+".jsonl"), os.O_WRONLY|os.O_APPEND, 0)
+		require.NoError(t, err)
+		_, err = f.WriteString(`{"type":"result","index":4,"res`)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		// --- Execute ---
+		jobs, err := store.Load()
+
+		// --- Assert ---
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 4}, jobs[0].pending())
+	})
+
+	t.Run("success - a truncated last line does not break the next restart", func(t *testing.T) {
+		// --- Setup ---
+		dir := t.TempDir()
+		store, err := NewFileStore(dir)
+		require.NoError(t, err)
+		j := seedUnfinished(t, store)
+		f, err := os.OpenFile(filepath.Join(dir, j.ID+".jsonl"), os.O_WRONLY|os.O_APPEND, 0)
+		require.NoError(t, err)
+		_, err = f.WriteString(`{"type":"result","index":4,"res`)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		_, err = store.Load()
+		require.NoError(t, err)
+
+		// --- Execute ---
+		require.NoError(t, store.MarkStarted(j.ID, 4, time.Now()))
+		require.NoError(t, store.SaveResult(j.ID, 4, fcm.TokenResult{Token: "todo", Attempts: 1}, false))
+		jobs, err := store.Load()
+
+		// --- Assert ---
+		require.NoError(t, err)
+		assert.Equal(t, []int{1}, jobs[0].pending())
+	})
+
+	t.Run("success - deleted jobs are not loaded", func(t *testing.T) {
+		store, err := NewFileStore(t.TempDir())
+		require.NoError(t, err)
+		j := seedUnfinished(t, store)
+
+		require.NoError(t, store.Delete(j.ID))
+		jobs, err := store.Load()
+
+		require.NoError(t, err)
+		assert.Empty(t, jobs)
+	})
+
+	t.Run("error - corrupt log", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := NewFileStore(dir)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.jsonl"), []byte("{\"type\":\"started\",\"index\":0}\n"), 0o600))
+
+		_, err = store.Load()
+
+		assert.Error(t, err)
+	})
+}
+
+func TestManager_Recover(t *testing.T) {
+	t.Run("success - resumes unfinished jobs without resending settled tokens", func(t *testing.T) {
+		// --- Setup ---
+		store, err := NewFileStore(t.TempDir())
+		require.NoError(t, err)
+		j := seedUnfinished(t, store)
+		sender := &fakeSender{}
+		m := NewManager(sender, Config{Workers: 1, QueueSize: 1, Concurrency: 2, Retention: time.Hour, Store: store})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// --- Execute ---
+		resumed, err := m.Recover()
+		require.NoError(t, err)
+		m.Start(ctx)
+		got := waitCompleted(t, m, j.ID)
+
+		// --- Assert ---
+		assert.Equal(t, 1, resumed)
+		assert.ElementsMatch(t, []string{"retry", "todo"}, sender.calls)
+		assert.Equal(t, 5, got.Processed)
+		assert.Equal(t, 3, got.SuccessCount)
+		assert.Equal(t, 2, got.FailureCount)
+
+		// The completed job survives another restart as is.
+		restarted := NewManager(&fakeSender{}, Config{Retention: time.Hour, Store: store})
+		resumed, err = restarted.Recover()
+		require.NoError(t, err)
+		assert.Equal(t, 0, resumed)
+		again, ok := restarted.Get(j.ID)
+		require.True(t, ok)
+		assert.Equal(t, StatusCompleted, again.Status)
+		assert.Equal(t, got.Results, again.Results)
+	})
+
+	t.Run("success - submitted jobs are persisted but rejected ones are not", func(t *testing.T) {
+		// --- Setup ---
+		dir := t.TempDir()
+		store, err := NewFileStore(dir)
+		require.NoError(t, err)
+		now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		m := NewManager(&fakeSender{}, Config{QueueSize: 1, Retention: time.Minute, Store: store})
+		m.now = func() time.Time { return now }
+
+		// --- Execute ---
+		// No workers run, so the job stays queued on disk.
+		submitted, err := m.Submit("", []string{"a"}, fcm.Message{}, false, "")
+		require.NoError(t, err)
+		_, errFull := m.Submit("", []string{"b"}, fcm.Message{}, false, "")
+		jobs, err := store.Load()
+
+		// --- Assert ---
+		require.NoError(t, err)
+		assert.ErrorIs(t, errFull, ErrQueueFull)
+		require.Len(t, jobs, 1)
+		assert.Equal(t, submitted.ID, jobs[0].ID)
+		assert.Equal(t, []int{0}, jobs[0].pending())
+	})
+}
@@ -0,0 +1,120 @@
+// Package logging builds the gateway's slog logger. Log lines written with
+// a request's context carry its request ID and trace ID, and device tokens
+// logged as Token are redacted.
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Formats Config.Format can name.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Config controls the logger built by New.
+type Config struct {
+	// Level is debug, info, warn or error. Empty means info.
+	Level string
+	// Format is one of the Format constants. Empty means text.
+	Format string
+}
+
+// New returns a logger writing to w.
+func New(w io.Writer, cfg Config) (*slog.Logger, error) {
+	var level slog.Level
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q: %w", cfg.Level, err)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q, use %s or %s", cfg.Format, FormatText, FormatJSON)
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns ctx carrying the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID of ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler adds the request ID and trace ID of the context to every
+// record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+var redactTokens atomic.Bool
+
+func init() {
+	redactTokens.Store(true)
+}
+
+// SetRedactTokens controls whether Token values are logged redacted, which
+// is the default, or in full.
+func SetRedactTokens(redact bool) {
+	redactTokens.Store(redact)
+}
+
+// Token is a device token. It logs as a short hash so the same token can be
+// followed across log lines without writing it out.
+type Token string
+
+func (t Token) LogValue() slog.Value {
+	if !redactTokens.Load() {
+		return slog.StringValue(string(t))
+	}
+	return slog.StringValue(Redact(string(t)))
+}
+
+// Redact returns "sha256:" followed by the first 12 hex digits of the
+// SHA-256 of token.
+func Redact(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("success - JSON lines carry the request and trace IDs", func(t *testing.T) {
+		// --- Setup ---
+		var buf bytes.Buffer
+		logger, err := New(&buf, Config{Level: "info", Format: "json"})
+		require.NoError(t, err)
+		traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+		require.NoError(t, err)
+		spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+		require.NoError(t, err)
+		ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+		ctx = WithRequestID(ctx, "req-1")
+
+		// --- Execute ---
+		logger.With("component", "test").InfoContext(ctx, "Send failed", "token", Token("device-token"))
+		logger.DebugContext(ctx, "Hidden below the level")
+
+		// --- Assert ---
+		var line map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &line), buf.String())
+		assert.Equal(t, "Send failed", line["msg"])
+		assert.Equal(t, "req-1", line["request_id"])
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", line["trace_id"])
+		assert.Equal(t, "test", line["component"])
+		assert.Equal(t, Redact("device-token"), line["token"])
+		assert.NotContains(t, buf.String(), "device-token")
+	})
+
+	t.Run("success - text format without context", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger, err := New(&buf, Config{Level: "DEBUG"})
+		require.NoError(t, err)
+
+		logger.Debug("Starting")
+
+		assert.Contains(t, buf.String(), "level=DEBUG msg=Starting")
+		assert.NotContains(t, buf.String(), "request_id")
+	})
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{"error - unknown level", Config{Level: "verbose"}, `invalid log level "verbose"`},
+		{"error - unknown format", Config{Format: "xml"}, `invalid log format "xml"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(&bytes.Buffer{}, tt.cfg)
+
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestToken(t *testing.T) {
+	t.Run("success - redacted by default to a stable hash prefix", func(t *testing.T) {
+		value := Token("device-token").LogValue()
+
+		assert.Equal(t, Redact("device-token"), value.String())
+		assert.Len(t, value.String(), len("sha256:")+12)
+		assert.NotEqual(t, Redact("other-token"), value.String())
+	})
+
+	t.Run("success - logged in full when redaction is off", func(t *testing.T) {
+		SetRedactTokens(false)
+		t.Cleanup(func() { SetRedactTokens(true) })
+
+		assert.Equal(t, slog.StringValue("device-token"), Token("device-token").LogValue())
+	})
+}
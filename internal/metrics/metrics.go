@@ -0,0 +1,187 @@
+// Package metrics collects what the gateway does and serves it in the
+// Prometheus text format.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/wirsal/fcm-gateway/fcm"
+	"github.com/wirsal/fcm-gateway/internal/ratelimit"
+)
+
+const namespace = "fcm_gateway"
+
+// Outcomes of messages and token refreshes.
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+// httpBuckets reach further than the default buckets since a fan-out to
+// many tokens can take a while.
+var httpBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics holds the gateway's metrics in a registry of its own. It
+// implements fcm.Observer.
+type Metrics struct {
+	registry       *prometheus.Registry
+	messages       *prometheus.CounterVec
+	roundTrips     *prometheus.HistogramVec
+	tokenRefreshes *prometheus.CounterVec
+	httpRequests   *prometheus.HistogramVec
+}
+
+// New returns Metrics with the Go runtime and process metrics registered.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_total",
+			Help:      "Messages sent to FCM, by target type, outcome and FCM error code.",
+		}, []string{"project", "target", "outcome", "error_code"}),
+		roundTrips: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "fcm_request_duration_seconds",
+			Help:      "Duration of HTTP calls to FCM and the Instance ID API, by response status (0 for network failures).",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"project", "api", "status"}),
+		tokenRefreshes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "oauth_token_refreshes_total",
+			Help:      "OAuth2 access tokens fetched for calls to Google, by outcome.",
+		}, []string{"project", "outcome"}),
+		httpRequests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of requests to the gateway, by route and status.",
+			Buckets:   httpBuckets,
+		}, []string{"method", "route", "status"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.messages,
+		m.roundTrips,
+		m.tokenRefreshes,
+		m.httpRequests,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) MessageSent(projectID, target, errorCode string) {
+	outcome := outcomeSuccess
+	if errorCode != "" {
+		outcome = outcomeFailure
+	}
+	m.messages.WithLabelValues(projectID, target, outcome, errorCode).Inc()
+}
+
+func (m *Metrics) RoundTrip(projectID, api string, status int, d time.Duration) {
+	m.roundTrips.WithLabelValues(projectID, api, strconv.Itoa(status)).Observe(d.Seconds())
+}
+
+func (m *Metrics) TokenRefreshed(projectID string, err error) {
+	outcome := outcomeSuccess
+	if err != nil {
+		outcome = outcomeFailure
+	}
+	m.tokenRefreshes.WithLabelValues(projectID, outcome).Inc()
+}
+
+// ObserveHTTP records a request to the gateway. route is the route pattern,
+// e.g. /jobs/:id, so that IDs do not end up in labels.
+func (m *Metrics) ObserveHTTP(method, route string, status int, d time.Duration) {
+	m.httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Observe(d.Seconds())
+}
+
+// WatchProjects exports the in-flight messages and outbound rate limit of
+// every project in projects.
+func (m *Metrics) WatchProjects(projects *fcm.Projects) {
+	m.registry.MustRegister(&projectCollector{projects: projects})
+}
+
+// WatchRateLimiter exports the state of the per-client rate limiter.
+func (m *Metrics) WatchRateLimiter(limiter *ratelimit.Limiter) {
+	m.registry.MustRegister(&rateLimitCollector{limiter: limiter})
+}
+
+var (
+	inFlightDesc = prometheus.NewDesc(namespace+"_fcm_in_flight_messages",
+		"Messages being sent to FCM right now, across every fan-out.", []string{"project"}, nil)
+	throttledDesc = prometheus.NewDesc(namespace+"_fcm_throttled_total",
+		"Messages that waited for the outbound rate limit.", []string{"project"}, nil)
+	throttledSecondsDesc = prometheus.NewDesc(namespace+"_fcm_throttled_seconds_total",
+		"Total time messages waited for the outbound rate limit.", []string{"project"}, nil)
+	sendLimitDesc = prometheus.NewDesc(namespace+"_fcm_rate_limit_per_second",
+		"Configured outbound messages per second, 0 when unlimited.", []string{"project"}, nil)
+)
+
+// projectCollector reads the state of every project when scraped.
+type projectCollector struct {
+	projects *fcm.Projects
+}
+
+func (c *projectCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- inFlightDesc
+	ch <- throttledDesc
+	ch <- throttledSecondsDesc
+	ch <- sendLimitDesc
+}
+
+func (c *projectCollector) Collect(ch chan<- prometheus.Metric) {
+	seen := make(map[string]bool)
+	for _, name := range c.projects.Names() {
+		_, s, err := c.projects.Resolve(name)
+		if err != nil {
+			continue
+		}
+		// Two names may share a Firebase project, and so its series.
+		project := s.ProjectID()
+		if seen[project] {
+			continue
+		}
+		seen[project] = true
+		stats := s.RateLimitStats()
+		ch <- prometheus.MustNewConstMetric(inFlightDesc, prometheus.GaugeValue, float64(s.InFlight()), project)
+		ch <- prometheus.MustNewConstMetric(throttledDesc, prometheus.CounterValue, float64(stats.Throttled), project)
+		ch <- prometheus.MustNewConstMetric(throttledSecondsDesc, prometheus.CounterValue, stats.ThrottledFor.Seconds(), project)
+		ch <- prometheus.MustNewConstMetric(sendLimitDesc, prometheus.GaugeValue, stats.Limit, project)
+	}
+}
+
+var (
+	rateLimitedDesc = prometheus.NewDesc(namespace+"_http_rate_limited_total",
+		"Requests rejected with 429 by the per-client rate limit.", nil, nil)
+	rateAllowedDesc = prometheus.NewDesc(namespace+"_http_rate_allowed_total",
+		"Requests let through by the per-client rate limit.", nil, nil)
+	rateClientsDesc = prometheus.NewDesc(namespace+"_http_rate_limit_clients",
+		"Clients currently tracked by the per-client rate limit.", nil, nil)
+)
+
+type rateLimitCollector struct {
+	limiter *ratelimit.Limiter
+}
+
+func (c *rateLimitCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- rateLimitedDesc
+	ch <- rateAllowedDesc
+	ch <- rateClientsDesc
+}
+
+func (c *rateLimitCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.limiter.Stats()
+	ch <- prometheus.MustNewConstMetric(rateLimitedDesc, prometheus.CounterValue, float64(stats.Rejected))
+	ch <- prometheus.MustNewConstMetric(rateAllowedDesc, prometheus.CounterValue, float64(stats.Allowed))
+	ch <- prometheus.MustNewConstMetric(rateClientsDesc, prometheus.GaugeValue, float64(stats.Clients))
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wirsal/fcm-gateway/internal/ratelimit"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("success - counts messages by outcome and error code", func(t *testing.T) {
+		// --- Setup ---
+		m := New()
+
+		// --- Execute ---
+		m.MessageSent("p", "token", "")
+		m.MessageSent("p", "token", "")
+		m.MessageSent("p", "token", "UNREGISTERED")
+		m.MessageSent("p", "condition", "")
+
+		// --- Assert ---
+		assert.Equal(t, 2.0, testutil.ToFloat64(m.messages.WithLabelValues("p", "token", "success", "")))
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.messages.WithLabelValues("p", "token", "failure", "UNREGISTERED")))
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.messages.WithLabelValues("p", "condition", "success", "")))
+	})
+
+	t.Run("success - counts token refreshes and their failures", func(t *testing.T) {
+		m := New()
+
+		m.TokenRefreshed("p", nil)
+		m.TokenRefreshed("p", errors.New("invalid_grant"))
+
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.tokenRefreshes.WithLabelValues("p", "success")))
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.tokenRefreshes.WithLabelValues("p", "failure")))
+	})
+
+	t.Run("success - serves the text format", func(t *testing.T) {
+		// --- Setup ---
+		m := New()
+		limiter := ratelimit.New(1, 1)
+		limiter.Allow("a")
+		limiter.Allow("a")
+		m.WatchRateLimiter(limiter)
+		m.RoundTrip("p", "messages", http.StatusOK, 120*time.Millisecond)
+		m.ObserveHTTP(http.MethodPost, "/send", http.StatusOK, time.Second)
+
+		// --- Execute ---
+		rec := httptest.NewRecorder()
+		m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		// --- Assert ---
+		require.Equal(t, http.StatusOK, rec.Code)
+		body := rec.Body.String()
+		for _, line := range []string{
+			`fcm_gateway_fcm_request_duration_seconds_count{api="messages",project="p",status="200"} 1`,
+			`fcm_gateway_http_request_duration_seconds_bucket{method="POST",route="/send",status="200",le="1"} 1`,
+			`fcm_gateway_http_rate_limited_total 1`,
+			`fcm_gateway_http_rate_allowed_total 1`,
+			`fcm_gateway_http_rate_limit_clients 1`,
+			`go_goroutines`,
+		} {
+			assert.True(t, strings.Contains(body, line), "missing %s", line)
+		}
+	})
+}
@@ -0,0 +1,102 @@
+// Package ratelimit throttles callers with a token bucket per client.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// purgeInterval is how often clients whose bucket has refilled are dropped.
+const purgeInterval = time.Minute
+
+// Stats is a snapshot of a Limiter.
+type Stats struct {
+	// Clients is the number of clients with a partly used bucket.
+	Clients  int
+	Allowed  uint64
+	Rejected uint64
+}
+
+// Limiter allows each client perSecond requests on average, with bursts of
+// up to burst requests.
+type Limiter struct {
+	limit rate.Limit
+	burst int
+	// idle is how long a client must be silent for its bucket to be full
+	// again, after which it is forgotten.
+	idle time.Duration
+	now  func() time.Time
+
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastPurge time.Time
+	allowed   uint64
+	rejected  uint64
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// New returns a Limiter. perSecond must be positive; a burst below 1
+// defaults to perSecond rounded up.
+func New(perSecond float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = max(1, int(math.Ceil(perSecond)))
+	}
+	return &Limiter{
+		limit:   rate.Limit(perSecond),
+		burst:   burst,
+		idle:    time.Duration(float64(burst) / perSecond * float64(time.Second)),
+		now:     time.Now,
+		clients: make(map[string]*client),
+	}
+}
+
+// Allow takes a token from the bucket of key. When the bucket is empty it
+// returns false and how long until the next token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.purgeLocked(now)
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+
+	r := c.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		l.rejected++
+		return false, delay
+	}
+	l.allowed++
+	return true, 0
+}
+
+// Stats returns the current state of l.
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Stats{Clients: len(l.clients), Allowed: l.allowed, Rejected: l.rejected}
+}
+
+func (l *Limiter) purgeLocked(now time.Time) {
+	if now.Sub(l.lastPurge) < purgeInterval {
+		return
+	}
+	l.lastPurge = now
+	for key, c := range l.clients {
+		if now.Sub(c.lastSeen) >= l.idle {
+			delete(l.clients, key)
+		}
+	}
+}
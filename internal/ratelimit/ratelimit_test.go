@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Run("success - each client has its own bucket", func(t *testing.T) {
+		// --- Setup ---
+		now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		l := New(2, 2)
+		l.now = func() time.Time { return now }
+
+		// --- Execute ---
+		first, _ := l.Allow("a")
+		second, _ := l.Allow("a")
+		third, retryAfter := l.Allow("a")
+		other, _ := l.Allow("b")
+		now = now.Add(retryAfter)
+		refilled, _ := l.Allow("a")
+
+		// --- Assert ---
+		assert.True(t, first)
+		assert.True(t, second)
+		assert.False(t, third)
+		assert.Equal(t, 500*time.Millisecond, retryAfter)
+		assert.True(t, other)
+		assert.True(t, refilled)
+		assert.Equal(t, Stats{Clients: 2, Allowed: 4, Rejected: 1}, l.Stats())
+	})
+
+	t.Run("success - rejected requests do not use up tokens", func(t *testing.T) {
+		now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		l := New(1, 1)
+		l.now = func() time.Time { return now }
+		l.Allow("a")
+
+		for range 5 {
+			l.Allow("a")
+		}
+		now = now.Add(time.Second)
+		ok, _ := l.Allow("a")
+
+		assert.True(t, ok)
+	})
+
+	t.Run("success - idle clients are forgotten", func(t *testing.T) {
+		now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		l := New(10, 0)
+		l.now = func() time.Time { return now }
+		l.Allow("a")
+		l.Allow("b")
+
+		now = now.Add(purgeInterval)
+		l.Allow("c")
+
+		assert.Equal(t, 1, l.Stats().Clients)
+		assert.Equal(t, 10, l.burst, "burst defaults to the rate")
+	})
+}
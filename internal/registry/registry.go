@@ -0,0 +1,201 @@
+// Package registry keeps the device tokens of each user so callers do not
+// have to, and drops tokens that FCM no longer accepts.
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/wirsal/fcm-gateway/fcm"
+	"github.com/wirsal/fcm-gateway/internal/logging"
+)
+
+// ErrInvalidDevice is returned by Register for incomplete registrations.
+var ErrInvalidDevice = errors.New("invalid device")
+
+const (
+	PlatformAndroid = "android"
+	PlatformIOS     = "ios"
+	PlatformWeb     = "web"
+)
+
+// Device is a registered device token.
+type Device struct {
+	Token      string `json:"token"`
+	UserID     string `json:"user_id"`
+	Platform   string `json:"platform"`
+	AppVersion string `json:"app_version,omitempty"`
+	// Project is the gateway project the token was issued for. Devices
+	// stored without one belong to the default project.
+	Project   string    `json:"project"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// InvalidAt is set when FCM reported the token as permanently invalid.
+	// Such tokens are skipped when sending.
+	InvalidAt *time.Time `json:"invalid_at,omitempty"`
+	// InvalidReason is the FCM error code that invalidated the token.
+	InvalidReason string `json:"invalid_reason,omitempty"`
+}
+
+// Filter narrows List. Empty fields match everything.
+type Filter struct {
+	UserID   string
+	Platform string
+	// Project is applied by Registry.List rather than by the Store, since
+	// devices stored without a project belong to the default project.
+	Project string
+	// IncludeInvalid also returns tokens flagged as invalid.
+	IncludeInvalid bool
+}
+
+func (f Filter) match(d Device) bool {
+	return (f.UserID == "" || d.UserID == f.UserID) &&
+		(f.Platform == "" || d.Platform == f.Platform) &&
+		(f.IncludeInvalid || d.InvalidAt == nil)
+}
+
+// Store persists devices by token. Every method must be safe for concurrent
+// use.
+type Store interface {
+	// Put creates or replaces the device with d.Token.
+	Put(d Device) error
+	Get(token string) (Device, bool, error)
+	// List returns the matching devices ordered by creation time.
+	List(f Filter) ([]Device, error)
+	// Delete reports whether the token was registered.
+	Delete(token string) (bool, error)
+}
+
+// Registry validates registrations and reacts to invalid tokens.
+type Registry struct {
+	store Store
+	// removeInvalid deletes invalid tokens instead of flagging them.
+	removeInvalid  bool
+	defaultProject string
+	now            func() time.Time
+}
+
+// New returns a Registry on store. Tokens FCM reports as invalid are deleted
+// when removeInvalid is set and flagged otherwise. Devices registered without
+// a project belong to defaultProject.
+func New(store Store, removeInvalid bool, defaultProject string) *Registry {
+	return &Registry{store: store, removeInvalid: removeInvalid, defaultProject: strings.ToLower(defaultProject), now: time.Now}
+}
+
+// Register stores d, replacing an earlier registration of the same token.
+// Registering a token again clears its invalid flag.
+func (r *Registry) Register(d Device) (device Device, created bool, err error) {
+	d.Token = strings.TrimSpace(d.Token)
+	d.UserID = strings.TrimSpace(d.UserID)
+	d.Platform = strings.ToLower(strings.TrimSpace(d.Platform))
+	d.Project = strings.ToLower(strings.TrimSpace(d.Project))
+	if d.Project == "" {
+		d.Project = r.defaultProject
+	}
+	switch {
+	case d.Token == "":
+		return Device{}, false, fmt.Errorf("%w: token is required", ErrInvalidDevice)
+	case d.UserID == "":
+		return Device{}, false, fmt.Errorf("%w: user_id is required", ErrInvalidDevice)
+	case d.Platform != PlatformAndroid && d.Platform != PlatformIOS && d.Platform != PlatformWeb:
+		return Device{}, false, fmt.Errorf("%w: platform must be android, ios or web", ErrInvalidDevice)
+	}
+
+	existing, ok, err := r.store.Get(d.Token)
+	if err != nil {
+		return Device{}, false, err
+	}
+	now := r.now()
+	d.CreatedAt, d.UpdatedAt = now, now
+	if ok {
+		d.CreatedAt = existing.CreatedAt
+	}
+	d.InvalidAt, d.InvalidReason = nil, ""
+	if err := r.store.Put(d); err != nil {
+		return Device{}, false, err
+	}
+	return d, !ok, nil
+}
+
+func (r *Registry) Get(token string) (Device, bool, error) {
+	d, ok, err := r.store.Get(token)
+	return r.withProject(d), ok, err
+}
+
+func (r *Registry) List(f Filter) ([]Device, error) {
+	project := strings.ToLower(f.Project)
+	f.Project = ""
+	devices, err := r.store.List(f)
+	if err != nil {
+		return nil, err
+	}
+	matching := devices[:0]
+	for _, d := range devices {
+		d = r.withProject(d)
+		if project == "" || d.Project == project {
+			matching = append(matching, d)
+		}
+	}
+	return matching, nil
+}
+
+// withProject fills in the project of devices stored without one.
+func (r *Registry) withProject(d Device) Device {
+	if d.Project == "" {
+		d.Project = r.defaultProject
+	}
+	return d
+}
+
+func (r *Registry) Delete(token string) (bool, error) {
+	return r.store.Delete(token)
+}
+
+// InvalidTokenHandler returns the handler for fcm.WithInvalidTokenHandler of
+// the service of project.
+func (r *Registry) InvalidTokenHandler(project string) func(token string, fcmErr *fcm.Error) {
+	project = strings.ToLower(project)
+	return func(token string, fcmErr *fcm.Error) {
+		r.TokenInvalid(project, token, fcmErr)
+	}
+}
+
+// TokenInvalid flags or removes token after FCM rejected it for good when
+// sent through project. Errors that do not name the token as invalid, such as
+// an IAM PERMISSION_DENIED, are ignored so a misconfigured project cannot
+// prune every token. So are unregistered tokens and tokens of other projects:
+// FCM rejects those with SENDER_ID_MISMATCH although they are fine for the
+// project they were issued for.
+func (r *Registry) TokenInvalid(project, token string, fcmErr *fcm.Error) {
+	if !fcmErr.TokenInvalid() {
+		return
+	}
+	d, ok, err := r.Get(token)
+	if err != nil {
+		slog.Error("Failed to look up invalid token", "token", logging.Token(token), "error", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	if d.Project != strings.ToLower(project) {
+		slog.Warn("Ignoring invalid token of another project", "token", logging.Token(token), "project", project, "device_project", d.Project, "error_code", fcmErr.Code)
+		return
+	}
+
+	if r.removeInvalid {
+		_, err = r.store.Delete(token)
+	} else if d.InvalidAt == nil {
+		now := r.now()
+		d.InvalidAt = &now
+		d.InvalidReason = fcmErr.Code
+		d.UpdatedAt = now
+		err = r.store.Put(d)
+	}
+	if err != nil {
+		slog.Error("Failed to prune invalid token", "token", logging.Token(token), "user_id", d.UserID, "error", err)
+	}
+}
@@ -0,0 +1,290 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wirsal/fcm-gateway/fcm"
+)
+
+func newTestRegistry(t *testing.T, removeInvalid bool) *Registry {
+	t.Helper()
+	store, err := NewLocalStore("")
+	require.NoError(t, err)
+	r := New(store, removeInvalid, "default")
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	return r
+}
+
+func TestRegistry_Register(t *testing.T) {
+	t.Run("success - registers and updates a token", func(t *testing.T) {
+		// --- Setup ---
+		r := newTestRegistry(t, false)
+
+		// --- Execute ---
+		first, created, err := r.Register(Device{Token: "tok", UserID: "u1", Platform: "Android", AppVersion: "1.0"})
+		require.NoError(t, err)
+		second, createdAgain, err := r.Register(Device{Token: "tok", UserID: "u1", Platform: "android", AppVersion: "1.1"})
+		require.NoError(t, err)
+
+		// --- Assert ---
+		assert.True(t, created)
+		assert.False(t, createdAgain)
+		assert.Equal(t, PlatformAndroid, first.Platform)
+		assert.Equal(t, "1.1", second.AppVersion)
+		assert.Equal(t, first.CreatedAt, second.CreatedAt)
+		assert.True(t, second.UpdatedAt.After(first.UpdatedAt))
+	})
+
+	tests := []struct {
+		name    string
+		device  Device
+		wantErr string
+	}{
+		{"error - missing token", Device{UserID: "u1", Platform: "ios"}, "token is required"},
+		{"error - missing user", Device{Token: "tok", Platform: "ios"}, "user_id is required"},
+		{"error - unknown platform", Device{Token: "tok", UserID: "u1", Platform: "symbian"}, "platform must be"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRegistry(t, false)
+
+			_, _, err := r.Register(tt.device)
+
+			assert.ErrorIs(t, err, ErrInvalidDevice)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRegistry_TokenInvalid(t *testing.T) {
+	unregistered := fcmError(http.StatusNotFound, fcm.ErrorCodeUnregistered)
+
+	t.Run("success - flags invalid tokens and hides them from List", func(t *testing.T) {
+		// --- Setup ---
+		r := newTestRegistry(t, false)
+		_, _, err := r.Register(Device{Token: "dead", UserID: "u1", Platform: "ios"})
+		require.NoError(t, err)
+		_, _, err = r.Register(Device{Token: "alive", UserID: "u1", Platform: "web"})
+		require.NoError(t, err)
+
+		// --- Execute ---
+		r.TokenInvalid("default", "dead", unregistered)
+		r.TokenInvalid("default", "never-registered", unregistered)
+
+		// --- Assert ---
+		active, err := r.List(Filter{UserID: "u1"})
+		require.NoError(t, err)
+		require.Len(t, active, 1)
+		assert.Equal(t, "alive", active[0].Token)
+
+		all, err := r.List(Filter{UserID: "u1", IncludeInvalid: true})
+		require.NoError(t, err)
+		require.Len(t, all, 2)
+		dead, ok, err := r.Get("dead")
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.NotNil(t, dead.InvalidAt)
+		assert.Equal(t, fcm.ErrorCodeUnregistered, dead.InvalidReason)
+
+		// Registering the token again revives it.
+		revived, _, err := r.Register(Device{Token: "dead", UserID: "u1", Platform: "ios"})
+		require.NoError(t, err)
+		assert.Nil(t, revived.InvalidAt)
+		assert.Empty(t, revived.InvalidReason)
+	})
+
+	t.Run("success - errors that do not name the token keep it", func(t *testing.T) {
+		// --- Setup ---
+		r := newTestRegistry(t, true)
+		_, _, err := r.Register(Device{Token: "a", UserID: "u1", Platform: "ios"})
+		require.NoError(t, err)
+		denied := &fcm.Error{StatusCode: http.StatusForbidden, Code: fcm.ErrorCodeSenderIDMismatch, Status: "PERMISSION_DENIED"}
+
+		// --- Execute ---
+		r.TokenInvalid("default", "a", denied)
+
+		// --- Assert ---
+		d, ok, err := r.Get("a")
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Nil(t, d.InvalidAt)
+	})
+
+	t.Run("success - removes invalid tokens when configured to", func(t *testing.T) {
+		r := newTestRegistry(t, true)
+		_, _, err := r.Register(Device{Token: "dead", UserID: "u1", Platform: "ios"})
+		require.NoError(t, err)
+
+		r.TokenInvalid("default", "dead", unregistered)
+
+		_, ok, err := r.Get("dead")
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestRegistry_Projects(t *testing.T) {
+	t.Run("success - devices are listed by project", func(t *testing.T) {
+		// --- Setup ---
+		r := newTestRegistry(t, false)
+		_, _, err := r.Register(Device{Token: "a", UserID: "u1", Platform: "ios"})
+		require.NoError(t, err)
+		_, _, err = r.Register(Device{Token: "b", UserID: "u1", Platform: "ios", Project: "Brand-B"})
+		require.NoError(t, err)
+		// Stored before devices had a project.
+		require.NoError(t, r.store.Put(Device{Token: "legacy", UserID: "u1", Platform: "web"}))
+
+		// --- Execute ---
+		defaults, err := r.List(Filter{UserID: "u1", Project: "default"})
+		require.NoError(t, err)
+		brandB, err := r.List(Filter{UserID: "u1", Project: "brand-b"})
+		require.NoError(t, err)
+		all, err := r.List(Filter{UserID: "u1"})
+		require.NoError(t, err)
+
+		// --- Assert ---
+		require.Len(t, defaults, 2)
+		assert.Equal(t, "legacy", defaults[0].Token, "its zero creation time sorts first")
+		assert.Equal(t, "default", defaults[0].Project)
+		assert.Equal(t, "a", defaults[1].Token)
+		require.Len(t, brandB, 1)
+		assert.Equal(t, "b", brandB[0].Token)
+		assert.Equal(t, "brand-b", brandB[0].Project)
+		assert.Len(t, all, 3)
+	})
+
+	t.Run("success - tokens rejected by another project are kept", func(t *testing.T) {
+		// --- Setup ---
+		r := newTestRegistry(t, true)
+		_, _, err := r.Register(Device{Token: "a", UserID: "u1", Platform: "ios"})
+		require.NoError(t, err)
+		mismatch := fcmError(http.StatusForbidden, fcm.ErrorCodeSenderIDMismatch)
+
+		// --- Execute ---
+		r.InvalidTokenHandler("brand-b")("a", mismatch)
+
+		// --- Assert ---
+		d, ok, err := r.Get("a")
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Nil(t, d.InvalidAt)
+	})
+}
+
+func TestLocalStore(t *testing.T) {
+	t.Run("success - devices survive reopening the file", func(t *testing.T) {
+		// --- Setup ---
+		path := filepath.Join(t.TempDir(), "tokens.json")
+		store, err := NewLocalStore(path)
+		require.NoError(t, err)
+		created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		require.NoError(t, store.Put(Device{Token: "a", UserID: "u1", Platform: "android", CreatedAt: created}))
+		require.NoError(t, store.Put(Device{Token: "b", UserID: "u2", Platform: "ios", CreatedAt: created.Add(time.Second)}))
+		require.NoError(t, store.Put(Device{Token: "c", UserID: "u1", Platform: "web", CreatedAt: created.Add(2 * time.Second)}))
+		deleted, err := store.Delete("c")
+		require.NoError(t, err)
+
+		// --- Execute ---
+		reopened, err := NewLocalStore(path)
+		require.NoError(t, err)
+		all, err := reopened.List(Filter{})
+		require.NoError(t, err)
+		android, err := reopened.List(Filter{Platform: "android"})
+		require.NoError(t, err)
+
+		// --- Assert ---
+		assert.True(t, deleted)
+		require.Len(t, all, 2)
+		assert.Equal(t, "a", all[0].Token)
+		assert.Equal(t, "b", all[1].Token)
+		require.Len(t, android, 1)
+		assert.Equal(t, "u1", android[0].UserID)
+	})
+
+	t.Run("success - the change log is folded into the snapshot", func(t *testing.T) {
+		// --- Setup ---
+		path := filepath.Join(t.TempDir(), "tokens.json")
+		store, err := NewLocalStore(path)
+		require.NoError(t, err)
+		created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		// --- Execute ---
+		for i := range compactAfter + 1 {
+			require.NoError(t, store.Put(Device{Token: fmt.Sprintf("t%04d", i), UserID: "u1", CreatedAt: created}))
+		}
+		reopened, err := NewLocalStore(path)
+		require.NoError(t, err)
+		all, err := reopened.List(Filter{})
+		require.NoError(t, err)
+
+		// --- Assert ---
+		snapshot, err := os.ReadFile(path)
+		require.NoError(t, err)
+		var saved []Device
+		require.NoError(t, json.Unmarshal(snapshot, &saved))
+		assert.Len(t, saved, compactAfter)
+		logged, err := os.ReadFile(path + ".log")
+		require.NoError(t, err)
+		assert.Equal(t, 1, bytes.Count(logged, []byte("\n")), "only the change after the compaction is logged")
+		assert.Len(t, all, compactAfter+1)
+	})
+
+	t.Run("success - a truncated last log line is cut off", func(t *testing.T) {
+		// --- Setup ---
+		path := filepath.Join(t.TempDir(), "tokens.json")
+		store, err := NewLocalStore(path)
+		require.NoError(t, err)
+		require.NoError(t, store.Put(Device{Token: "a", UserID: "u1"}))
+		f, err := os.OpenFile(path+".log", os.O_WRONLY|os.O_APPEND, 0)
+		require.NoError(t, err)
+		_, err = f.WriteString(`{"device":{"token":"b"`)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		// --- Execute ---
+		reopened, err := NewLocalStore(path)
+		require.NoError(t, err)
+		require.NoError(t, reopened.Put(Device{Token: "c", UserID: "u2"}))
+		again, err := NewLocalStore(path)
+		require.NoError(t, err)
+		all, err := again.List(Filter{})
+
+		// --- Assert ---
+		require.NoError(t, err)
+		require.Len(t, all, 2)
+		assert.Equal(t, "a", all[0].Token)
+		assert.Equal(t, "c", all[1].Token)
+	})
+
+	t.Run("success - deleting an unknown token reports false", func(t *testing.T) {
+		store, err := NewLocalStore("")
+		require.NoError(t, err)
+
+		deleted, err := store.Delete("nope")
+
+		require.NoError(t, err)
+		assert.False(t, deleted)
+	})
+}
+
+// fcmError returns the error FCM reports with an FcmError detail of code.
+func fcmError(status int, code string) *fcm.Error {
+	return &fcm.Error{
+		StatusCode: status,
+		Code:       code,
+		Details:    []fcm.ErrorDetail{{Type: "type.googleapis.com/google.firebase.fcm.v1.FcmError", ErrorCode: code}},
+	}
+}
@@ -0,0 +1,262 @@
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// compactAfter is the number of changes the log of a LocalStore holds at
+// least before they are folded into the snapshot file.
+const compactAfter = 1000
+
+// LocalStore is the built-in Store. It keeps every device in memory and, when
+// given a path, persists it so the registry survives restarts: each change is
+// appended to a log next to the JSON snapshot at path, and the log is folded
+// into the snapshot once it holds more changes than compactAfter and than
+// there are devices. A change thus costs one small write, and the rewrites of
+// the whole snapshot stay in proportion to the changes made.
+type LocalStore struct {
+	path string
+
+	mu      sync.RWMutex
+	devices map[string]Device
+	// logged counts the changes in the log.
+	logged int
+}
+
+// logEntry is one line of the change log: a device put, or a token deleted.
+type logEntry struct {
+	Device *Device `json:"device,omitempty"`
+	Delete string  `json:"delete,omitempty"`
+}
+
+// NewLocalStore loads the devices saved at path. An empty path keeps devices
+// in memory only.
+func NewLocalStore(path string) (*LocalStore, error) {
+	s := &LocalStore{path: path, devices: make(map[string]Device)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read token registry: %w", err)
+	}
+	if err == nil {
+		var devices []Device
+		if err := json.Unmarshal(data, &devices); err != nil {
+			return nil, fmt.Errorf("parse token registry %s: %w", path, err)
+		}
+		for _, d := range devices {
+			s.devices[d.Token] = d
+		}
+	}
+	if err := s.replayLog(); err != nil {
+		return nil, fmt.Errorf("parse token registry %s: %w", s.logPath(), err)
+	}
+	return s, nil
+}
+
+func (s *LocalStore) logPath() string {
+	return s.path + ".log"
+}
+
+// replayLog applies the changes logged since the snapshot was written.
+func (s *LocalStore) replayLog() error {
+	data, err := os.ReadFile(s.logPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var complete int64
+	r := bufio.NewReader(bytes.NewReader(data))
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				// A line without a newline was cut short by a crash. Cut
+				// it off, or the next change would be appended to it.
+				if err := os.Truncate(s.logPath(), complete); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var e logEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return err
+		}
+		if e.Device != nil {
+			s.devices[e.Device.Token] = *e.Device
+		} else {
+			delete(s.devices, e.Delete)
+		}
+		s.logged++
+		complete += int64(len(line))
+	}
+}
+
+func (s *LocalStore) Put(d Device) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.appendLocked(logEntry{Device: &d}); err != nil {
+		return err
+	}
+	s.devices[d.Token] = d
+	s.compactLocked()
+	return nil
+}
+
+func (s *LocalStore) Get(token string) (Device, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, ok := s.devices[token]
+	return d, ok, nil
+}
+
+func (s *LocalStore) List(f Filter) ([]Device, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := []Device{}
+	for _, d := range s.devices {
+		if f.match(d) {
+			list = append(list, d)
+		}
+	}
+	sortDevices(list)
+	return list, nil
+}
+
+func (s *LocalStore) Delete(token string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.devices[token]; !ok {
+		return false, nil
+	}
+	if err := s.appendLocked(logEntry{Delete: token}); err != nil {
+		return false, err
+	}
+	delete(s.devices, token)
+	s.compactLocked()
+	return true, nil
+}
+
+// appendLocked writes a change to the log and syncs it.
+func (s *LocalStore) appendLocked(e logEntry) error {
+	if s.path == "" {
+		return nil
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.logPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("save token registry: %w", err)
+	}
+	_, err = f.Write(append(line, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("save token registry: %w", err)
+	}
+	s.logged++
+	return nil
+}
+
+// compactLocked folds the log into the snapshot once it is long enough. The
+// change is already durable in the log, so a failure is only logged and the
+// compaction tried again with the next change.
+func (s *LocalStore) compactLocked() {
+	if s.path == "" || s.logged < compactAfter || s.logged < len(s.devices) {
+		return
+	}
+	if err := s.saveLocked(); err != nil {
+		slog.Error("Failed to compact token registry", "path", s.path, "error", err)
+		return
+	}
+	// A crash before the truncation replays changes the snapshot already
+	// holds, which yields the same devices.
+	if err := os.Truncate(s.logPath(), 0); err != nil {
+		slog.Error("Failed to compact token registry", "path", s.path, "error", err)
+		return
+	}
+	s.logged = 0
+}
+
+// saveLocked rewrites the snapshot through a temporary file so a crash never
+// leaves it half written.
+func (s *LocalStore) saveLocked() error {
+	devices := make([]Device, 0, len(s.devices))
+	for _, d := range s.devices {
+		devices = append(devices, d)
+	}
+	sortDevices(devices)
+	data, err := json.Marshal(devices)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("save token registry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("save token registry: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("save token registry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("save token registry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("save token registry: %w", err)
+	}
+	// The rename must be durable before the log is truncated.
+	dir, err := os.Open(filepath.Dir(s.path))
+	if err != nil {
+		return fmt.Errorf("save token registry: %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("save token registry: %w", err)
+	}
+	return nil
+}
+
+func sortDevices(devices []Device) {
+	slices.SortFunc(devices, func(a, b Device) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Token, b.Token)
+	})
+}
@@ -0,0 +1,16 @@
+package schedule
+
+import "time"
+
+// Clock tells the scheduler what time it is and wakes it up when a schedule
+// is due. Tests replace it to control time.
+type Clock interface {
+	Now() time.Time
+	// After sends on the returned channel once d has passed.
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
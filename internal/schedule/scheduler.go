@@ -0,0 +1,388 @@
+// Package schedule holds messages until the time they should be sent.
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/wirsal/fcm-gateway/fcm"
+	"github.com/wirsal/fcm-gateway/internal/fanout"
+	"github.com/wirsal/fcm-gateway/internal/ids"
+	"github.com/wirsal/fcm-gateway/internal/logging"
+)
+
+// ErrNotInFuture is returned by Add when a schedule is already due.
+var ErrNotInFuture = errors.New("send_at must be in the future")
+
+// ErrShuttingDown is returned by Add once Shutdown was called, and reported
+// for the schedules Shutdown gave up on.
+var ErrShuttingDown = errors.New("scheduler is shutting down")
+
+// Sender delivers messages. *fcm.Service and *fcm.Projects implement it; the
+// latter sends through the project of the schedule, see fcm.WithProject.
+type Sender interface {
+	SendNotification(ctx context.Context, token string, msg fcm.Message, validateOnly bool) (fcm.SendResult, error)
+	BroadcastNotification(ctx context.Context, condition string, msg fcm.Message, validateOnly bool) (fcm.SendResult, error)
+}
+
+// Schedule is a message waiting to be sent either to a list of device tokens
+// or to a topic condition.
+type Schedule struct {
+	ID        string      `json:"id"`
+	Tokens    []string    `json:"tokens,omitempty"`
+	Condition string      `json:"condition,omitempty"`
+	Message   fcm.Message `json:"message"`
+	DryRun    bool        `json:"dry_run"`
+	SendAt    time.Time   `json:"send_at"`
+	CreatedAt time.Time   `json:"created_at"`
+	// Project is the Firebase project the schedule sends through; empty
+	// means the default project.
+	Project string `json:"project,omitempty"`
+	// CallbackURL receives the results once the schedule was sent.
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// Outcome is what happened when a schedule was sent.
+type Outcome struct {
+	// Results is set for schedules sent to device tokens.
+	Results []fcm.TokenResult
+	// Result and Err are set for schedules sent to a condition.
+	Result fcm.SendResult
+	Err    error
+}
+
+type Config struct {
+	// Concurrency is how many tokens of a schedule are sent in parallel.
+	Concurrency int
+	// Clock defaults to the system clock.
+	Clock Clock
+	// OnSent, when set, is called after each schedule was sent, and for
+	// each schedule dropped by Shutdown.
+	OnSent func(Schedule, Outcome)
+	// Store keeps pending schedules across restarts. Schedules are kept in
+	// memory only when it is nil.
+	Store Store
+}
+
+// Scheduler keeps pending schedules, in memory and in its Store, and sends
+// each one when its SendAt time comes.
+type Scheduler struct {
+	sender Sender
+	cfg    Config
+	clock  Clock
+	store  Store
+
+	mu      sync.Mutex
+	pending map[string]Schedule
+	// wake tells the loop that the earliest schedule may have changed.
+	wake chan struct{}
+	// cancel stops the sends started by Start.
+	cancel context.CancelFunc
+
+	// stop is closed by Shutdown; no schedule is sent after that.
+	stop     chan struct{}
+	stopOnce sync.Once
+	loops    sync.WaitGroup
+	sends    sync.WaitGroup
+}
+
+func NewScheduler(sender Sender, cfg Config) *Scheduler {
+	clock := cfg.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	store := cfg.Store
+	if store == nil {
+		store = nopStore{}
+	}
+	return &Scheduler{
+		sender:  sender,
+		cfg:     cfg,
+		clock:   clock,
+		store:   store,
+		pending: make(map[string]Schedule),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Recover loads the schedules of the Store and returns how many there are.
+// Call it before Start. Schedules whose time passed while the gateway was
+// down are sent as soon as Start is called.
+func (s *Scheduler) Recover() (int, error) {
+	schedules, err := s.store.Load()
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sch := range schedules {
+		s.pending[sch.ID] = sch
+	}
+	return len(schedules), nil
+}
+
+// Start runs the scheduler until ctx is done or Shutdown is called.
+// Schedules still pending then are not sent.
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
+	s.cancel = cancel
+	s.mu.Unlock()
+
+	s.loops.Add(1)
+	go func() {
+		defer s.loops.Done()
+		s.loop(ctx)
+	}()
+}
+
+// Shutdown stops sending schedules and waits for the sends under way. When
+// ctx is done first, those sends are cancelled, their remaining tokens are
+// reported as fcm.ErrorCodeNotAttempted, and Shutdown returns ctx's error.
+// Schedules that were still pending stay in the Store for the next start;
+// without a Store they are dropped and handed to OnSent with
+// ErrShuttingDown.
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	// The loop starts no sends once it returned, so sends can be waited for.
+	s.loops.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		s.sends.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.mu.Lock()
+		cancel := s.cancel
+		s.mu.Unlock()
+		if cancel != nil {
+			cancel()
+		}
+		<-done
+	}
+
+	s.mu.Lock()
+	dropped := make([]Schedule, 0, len(s.pending))
+	for id, sch := range s.pending {
+		dropped = append(dropped, sch)
+		delete(s.pending, id)
+	}
+	s.mu.Unlock()
+	if s.cfg.Store != nil {
+		if len(dropped) > 0 {
+			slog.Info("Pending schedules kept for the next start", "schedules", len(dropped))
+		}
+		return err
+	}
+	for _, sch := range dropped {
+		slog.Warn("Schedule dropped by shutdown", "schedule_id", sch.ID, "send_at", sch.SendAt)
+		if s.cfg.OnSent != nil {
+			s.cfg.OnSent(sch, notAttempted(sch, ErrShuttingDown))
+		}
+	}
+	return err
+}
+
+// notAttempted is the outcome of sch when it was not sent because of err.
+func notAttempted(sch Schedule, err error) Outcome {
+	if sch.Condition != "" {
+		return Outcome{Err: err}
+	}
+	results := make([]fcm.TokenResult, len(sch.Tokens))
+	for i, token := range sch.Tokens {
+		results[i] = fcm.NotAttempted(token, err, sch.DryRun)
+	}
+	return Outcome{Results: results}
+}
+
+// Add stores sch with a new ID and returns it. The schedule is saved to the
+// Store before Add returns.
+func (s *Scheduler) Add(sch Schedule) (Schedule, error) {
+	select {
+	case <-s.stop:
+		return Schedule{}, ErrShuttingDown
+	default:
+	}
+	now := s.clock.Now()
+	if !sch.SendAt.After(now) {
+		return Schedule{}, ErrNotInFuture
+	}
+	id, err := ids.New()
+	if err != nil {
+		return Schedule{}, err
+	}
+	sch.ID = id
+	sch.CreatedAt = now
+	if err := s.store.Save(sch); err != nil {
+		return Schedule{}, fmt.Errorf("save schedule: %w", err)
+	}
+
+	s.mu.Lock()
+	s.pending[id] = sch
+	s.mu.Unlock()
+	s.notify()
+	return sch, nil
+}
+
+// List returns the pending schedules, earliest first.
+func (s *Scheduler) List() []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Schedule, 0, len(s.pending))
+	for _, sch := range s.pending {
+		list = append(list, sch)
+	}
+	slices.SortFunc(list, func(a, b Schedule) int {
+		if c := a.SendAt.Compare(b.SendAt); c != 0 {
+			return c
+		}
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return list
+}
+
+// Get returns a pending schedule.
+func (s *Scheduler) Get(id string) (Schedule, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sch, ok := s.pending[id]
+	return sch, ok
+}
+
+// Cancel removes a pending schedule. It reports false when there is no such
+// schedule, including when it was already sent.
+func (s *Scheduler) Cancel(id string) bool {
+	s.mu.Lock()
+	_, ok := s.pending[id]
+	delete(s.pending, id)
+	s.mu.Unlock()
+	if ok {
+		s.forget(id)
+		s.notify()
+	}
+	return ok
+}
+
+// forget removes a schedule that will not be sent from now on from the
+// Store.
+func (s *Scheduler) forget(id string) {
+	if err := s.store.Delete(id); err != nil {
+		slog.Error("Failed to delete stored schedule", "schedule_id", id, "error", err)
+	}
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context) {
+	for {
+		var due <-chan time.Time
+		if next, ok := s.next(); ok {
+			due = s.clock.After(next.Sub(s.clock.Now()))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stop:
+			return
+		case <-s.wake:
+		case <-due:
+			for _, sch := range s.takeDue() {
+				// Forgotten before the send starts: a schedule cut off by
+				// a crash is not sent twice.
+				s.forget(sch.ID)
+				s.sends.Add(1)
+				go func() {
+					defer s.sends.Done()
+					s.dispatch(ctx, sch)
+				}()
+			}
+		}
+	}
+}
+
+// next returns the earliest SendAt among the pending schedules.
+func (s *Scheduler) next() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	for _, sch := range s.pending {
+		if next.IsZero() || sch.SendAt.Before(next) {
+			next = sch.SendAt
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// takeDue removes and returns the schedules whose time has come.
+func (s *Scheduler) takeDue() []Schedule {
+	now := s.clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []Schedule
+	for id, sch := range s.pending {
+		if !sch.SendAt.After(now) {
+			due = append(due, sch)
+			delete(s.pending, id)
+		}
+	}
+	return due
+}
+
+func (s *Scheduler) dispatch(ctx context.Context, sch Schedule) {
+	out := s.send(ctx, sch)
+	if s.cfg.OnSent != nil {
+		s.cfg.OnSent(sch, out)
+	}
+}
+
+func (s *Scheduler) send(ctx context.Context, sch Schedule) Outcome {
+	ctx = fcm.WithProject(ctx, sch.Project)
+	if sch.Condition != "" {
+		res, err := s.sender.BroadcastNotification(ctx, sch.Condition, sch.Message, sch.DryRun)
+		if err != nil {
+			slog.WarnContext(ctx, "Scheduled broadcast failed", "schedule_id", sch.ID, "condition", sch.Condition, "attempts", res.Attempts, "error", err)
+		} else {
+			slog.InfoContext(ctx, "Scheduled broadcast sent", "schedule_id", sch.ID, "message_name", res.Name)
+		}
+		return Outcome{Result: res, Err: err}
+	}
+
+	results := fanout.Each(sch.Tokens, s.cfg.Concurrency, func(token string) fcm.TokenResult {
+		if err := ctx.Err(); err != nil {
+			return fcm.NotAttempted(token, err, sch.DryRun)
+		}
+		res, err := s.sender.SendNotification(ctx, token, sch.Message, sch.DryRun)
+		if err != nil {
+			slog.WarnContext(ctx, "Scheduled send to token failed", "schedule_id", sch.ID, "token", logging.Token(token), "attempts", res.Attempts, "error", err)
+		}
+		return fcm.NewTokenResult(token, res, err)
+	})
+	failed := 0
+	for _, r := range results {
+		if r.Error != "" {
+			failed++
+		}
+	}
+	slog.InfoContext(ctx, "Scheduled send finished", "schedule_id", sch.ID, "sent", len(results)-failed, "tokens", len(results))
+	return Outcome{Results: results}
+}
@@ -0,0 +1,271 @@
+package schedule
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wirsal/fcm-gateway/fcm"
+)
+
+// fakeClock only moves when Advance is called.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, waiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	var left []waiter
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			left = append(left, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = left
+}
+
+type fakeSender struct {
+	mu         sync.Mutex
+	tokens     []string
+	conditions []string
+	// projects holds the project each call was sent through.
+	projects []string
+}
+
+func (f *fakeSender) SendNotification(ctx context.Context, token string, msg fcm.Message, validateOnly bool) (fcm.SendResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens = append(f.tokens, token)
+	f.projects = append(f.projects, fcm.ProjectFromContext(ctx))
+	return fcm.SendResult{Name: "projects/p/messages/1", Attempts: 1}, nil
+}
+
+func (f *fakeSender) BroadcastNotification(ctx context.Context, condition string, msg fcm.Message, validateOnly bool) (fcm.SendResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.conditions = append(f.conditions, condition)
+	f.projects = append(f.projects, fcm.ProjectFromContext(ctx))
+	return fcm.SendResult{Name: "projects/p/messages/2", Attempts: 1}, nil
+}
+
+func (f *fakeSender) sent() ([]string, []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.tokens...), append([]string(nil), f.conditions...)
+}
+
+func TestScheduler(t *testing.T) {
+	t.Run("success - sends schedules once they are due", func(t *testing.T) {
+		// --- Setup ---
+		clock := newFakeClock()
+		sender := &fakeSender{}
+		s := NewScheduler(sender, Config{Concurrency: 2, Clock: clock})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s.Start(ctx)
+
+		start := clock.Now()
+		tokens, err := s.Add(Schedule{Tokens: []string{"a", "b"}, SendAt: start.Add(time.Hour)})
+		require.NoError(t, err)
+		_, err = s.Add(Schedule{Condition: "'news' in topics", Project: "brand-b", SendAt: start.Add(2 * time.Hour)})
+		require.NoError(t, err)
+
+		// --- Execute ---
+		clock.Advance(59 * time.Minute)
+		beforeDue := s.List()
+		clock.Advance(time.Minute)
+
+		// --- Assert ---
+		assert.Len(t, beforeDue, 2)
+		assert.Equal(t, tokens.ID, beforeDue[0].ID)
+		assert.Equal(t, start, tokens.CreatedAt)
+		require.Eventually(t, func() bool {
+			sent, _ := sender.sent()
+			return len(sent) == 2
+		}, time.Second, 5*time.Millisecond)
+		sentTokens, conditions := sender.sent()
+		assert.ElementsMatch(t, []string{"a", "b"}, sentTokens)
+		assert.Empty(t, conditions)
+		require.Len(t, s.List(), 1)
+
+		clock.Advance(time.Hour)
+		require.Eventually(t, func() bool {
+			_, conditions := sender.sent()
+			return len(conditions) == 1
+		}, time.Second, 5*time.Millisecond)
+		assert.Empty(t, s.List())
+		sender.mu.Lock()
+		defer sender.mu.Unlock()
+		assert.Equal(t, []string{"", "", "brand-b"}, sender.projects)
+	})
+
+	t.Run("success - reports the outcome of a sent schedule", func(t *testing.T) {
+		// --- Setup ---
+		clock := newFakeClock()
+		outcomes := make(chan Outcome, 1)
+		s := NewScheduler(&fakeSender{}, Config{Clock: clock, OnSent: func(sch Schedule, out Outcome) {
+			assert.Equal(t, "https://example.com/hook", sch.CallbackURL)
+			outcomes <- out
+		}})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s.Start(ctx)
+		_, err := s.Add(Schedule{Tokens: []string{"a"}, SendAt: clock.Now().Add(time.Minute), CallbackURL: "https://example.com/hook"})
+		require.NoError(t, err)
+
+		// --- Execute ---
+		clock.Advance(time.Minute)
+
+		// --- Assert ---
+		select {
+		case out := <-outcomes:
+			require.Len(t, out.Results, 1)
+			assert.Equal(t, "a", out.Results[0].Token)
+			assert.Equal(t, "projects/p/messages/1", out.Results[0].MessageName)
+		case <-time.After(time.Second):
+			t.Fatal("OnSent was not called")
+		}
+	})
+
+	t.Run("success - an earlier schedule added later is not held back", func(t *testing.T) {
+		// --- Setup ---
+		clock := newFakeClock()
+		sender := &fakeSender{}
+		s := NewScheduler(sender, Config{Clock: clock})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s.Start(ctx)
+
+		_, err := s.Add(Schedule{Tokens: []string{"late"}, SendAt: clock.Now().Add(time.Hour)})
+		require.NoError(t, err)
+		_, err = s.Add(Schedule{Tokens: []string{"early"}, SendAt: clock.Now().Add(time.Minute)})
+		require.NoError(t, err)
+
+		// --- Execute ---
+		clock.Advance(time.Minute)
+
+		// --- Assert ---
+		require.Eventually(t, func() bool {
+			sent, _ := sender.sent()
+			return len(sent) == 1
+		}, time.Second, 5*time.Millisecond)
+		sent, _ := sender.sent()
+		assert.Equal(t, []string{"early"}, sent)
+		require.Len(t, s.List(), 1)
+		assert.Equal(t, []string{"late"}, s.List()[0].Tokens)
+	})
+
+	t.Run("success - cancelled schedules are never sent", func(t *testing.T) {
+		// --- Setup ---
+		clock := newFakeClock()
+		sender := &fakeSender{}
+		s := NewScheduler(sender, Config{Clock: clock})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s.Start(ctx)
+
+		sch, err := s.Add(Schedule{Tokens: []string{"a"}, SendAt: clock.Now().Add(time.Minute)})
+		require.NoError(t, err)
+
+		// --- Execute ---
+		cancelled := s.Cancel(sch.ID)
+		cancelledAgain := s.Cancel(sch.ID)
+		clock.Advance(time.Hour)
+
+		// --- Assert ---
+		assert.True(t, cancelled)
+		assert.False(t, cancelledAgain)
+		assert.Empty(t, s.List())
+		assert.Never(t, func() bool {
+			sent, _ := sender.sent()
+			return len(sent) > 0
+		}, 50*time.Millisecond, 5*time.Millisecond)
+	})
+
+	t.Run("error - send_at not in the future", func(t *testing.T) {
+		clock := newFakeClock()
+		s := NewScheduler(&fakeSender{}, Config{Clock: clock})
+
+		_, err := s.Add(Schedule{Tokens: []string{"a"}, SendAt: clock.Now()})
+
+		assert.ErrorIs(t, err, ErrNotInFuture)
+		assert.Empty(t, s.List())
+	})
+}
+
+func TestScheduler_Shutdown(t *testing.T) {
+	t.Run("success - pending schedules are reported as not attempted", func(t *testing.T) {
+		// --- Setup ---
+		clock := newFakeClock()
+		sender := &fakeSender{}
+		var mu sync.Mutex
+		outcomes := make(map[string]Outcome)
+		s := NewScheduler(sender, Config{Clock: clock, OnSent: func(sch Schedule, out Outcome) {
+			mu.Lock()
+			defer mu.Unlock()
+			outcomes[sch.ID] = out
+		}})
+		s.Start(context.Background())
+		tokens, err := s.Add(Schedule{Tokens: []string{"a", "b"}, DryRun: true, SendAt: clock.Now().Add(time.Hour)})
+		require.NoError(t, err)
+		condition, err := s.Add(Schedule{Condition: "'news' in topics", SendAt: clock.Now().Add(time.Hour)})
+		require.NoError(t, err)
+
+		// --- Execute ---
+		err = s.Shutdown(context.Background())
+		_, errAdd := s.Add(Schedule{Tokens: []string{"c"}, SendAt: clock.Now().Add(time.Hour)})
+
+		// --- Assert ---
+		require.NoError(t, err)
+		assert.ErrorIs(t, errAdd, ErrShuttingDown)
+		assert.Empty(t, s.List())
+		sentTokens, sentConditions := sender.sent()
+		assert.Empty(t, sentTokens)
+		assert.Empty(t, sentConditions)
+
+		mu.Lock()
+		defer mu.Unlock()
+		require.Len(t, outcomes[tokens.ID].Results, 2)
+		for _, res := range outcomes[tokens.ID].Results {
+			assert.Equal(t, fcm.ErrorCodeNotAttempted, res.ErrorCode)
+			assert.True(t, res.ValidateOnly)
+		}
+		assert.ErrorIs(t, outcomes[condition.ID].Err, ErrShuttingDown)
+	})
+}
@@ -0,0 +1,126 @@
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Store keeps pending schedules so they survive a restart. Every method must
+// be safe for concurrent use.
+type Store interface {
+	// Save records a pending schedule.
+	Save(sch Schedule) error
+	// Delete forgets a schedule once it was cancelled or its send started.
+	Delete(id string) error
+	// Load returns every recorded schedule.
+	Load() ([]Schedule, error)
+}
+
+// nopStore is used when no Store is configured; schedules then live in
+// memory only.
+type nopStore struct{}
+
+func (nopStore) Save(Schedule) error       { return nil }
+func (nopStore) Delete(string) error       { return nil }
+func (nopStore) Load() ([]Schedule, error) { return nil, nil }
+
+// FileStore keeps each schedule as a JSON file in a directory. A file is
+// written to a temporary name, synced and renamed, so a crash leaves either
+// the whole schedule or none of it.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore stores schedules in dir, creating it if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create schedule store dir: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *FileStore) Save(sch Schedule) error {
+	data, err := json.Marshal(sch)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.dir, sch.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), s.path(sch.ID)); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return syncDir(s.dir)
+}
+
+func (s *FileStore) Delete(id string) error {
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Load returns the recorded schedules and removes temporary files left by a
+// crash during Save.
+func (s *FileStore) Load() ([]Schedule, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var schedules []Schedule
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case entry.IsDir():
+		case strings.HasSuffix(name, ".tmp"):
+			if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+				return nil, err
+			}
+		case strings.HasSuffix(name, ".json"):
+			data, err := os.ReadFile(filepath.Join(s.dir, name))
+			if err != nil {
+				return nil, err
+			}
+			var sch Schedule
+			if err := json.Unmarshal(data, &sch); err != nil {
+				return nil, fmt.Errorf("load schedule %s: %w", name, err)
+			}
+			schedules = append(schedules, sch)
+		}
+	}
+	return schedules, nil
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
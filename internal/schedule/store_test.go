@@ -0,0 +1,130 @@
+package schedule
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wirsal/fcm-gateway/fcm"
+)
+
+func TestFileStore(t *testing.T) {
+	t.Run("success - saved schedules are loaded until deleted", func(t *testing.T) {
+		// --- Setup ---
+		dir := t.TempDir()
+		store, err := NewFileStore(dir)
+		require.NoError(t, err)
+		sendAt := time.Date(2099, 1, 1, 9, 0, 0, 0, time.FixedZone("WIB", 7*3600))
+		sch := Schedule{
+			ID:          "sch-1",
+			Tokens:      []string{"a", "b"},
+			Message:     fcm.Message{Data: map[string]string{"k": "v"}},
+			SendAt:      sendAt,
+			Project:     "brand-b",
+			CallbackURL: "https://hooks.example.com/fcm",
+		}
+		require.NoError(t, store.Save(sch))
+		require.NoError(t, store.Save(Schedule{ID: "sch-2", Condition: "'news' in topics", SendAt: sendAt}))
+		// Left behind by a crash during Save.
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "sch-3.123.tmp"), []byte(`{"id":`), 0o600))
+
+		// --- Execute ---
+		require.NoError(t, store.Delete("sch-2"))
+		require.NoError(t, store.Delete("unknown"))
+		loaded, err := store.Load()
+
+		// --- Assert ---
+		require.NoError(t, err)
+		require.Len(t, loaded, 1)
+		assert.Equal(t, sch.Tokens, loaded[0].Tokens)
+		assert.Equal(t, sch.Message, loaded[0].Message)
+		assert.True(t, sendAt.Equal(loaded[0].SendAt))
+		assert.Equal(t, "brand-b", loaded[0].Project)
+		assert.Equal(t, sch.CallbackURL, loaded[0].CallbackURL)
+		assert.NoFileExists(t, filepath.Join(dir, "sch-3.123.tmp"))
+	})
+
+	t.Run("error - corrupt schedule", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := NewFileStore(dir)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.json"), []byte("{"), 0o600))
+
+		_, err = store.Load()
+
+		assert.ErrorContains(t, err, "load schedule bad.json")
+	})
+}
+
+func TestScheduler_Recover(t *testing.T) {
+	t.Run("success - pending schedules survive a restart", func(t *testing.T) {
+		// --- Setup ---
+		store, err := NewFileStore(t.TempDir())
+		require.NoError(t, err)
+		clock := newFakeClock()
+		var dropped []Schedule
+		first := NewScheduler(&fakeSender{}, Config{Clock: clock, Store: store, OnSent: func(sch Schedule, _ Outcome) {
+			dropped = append(dropped, sch)
+		}})
+		first.Start(context.Background())
+		kept, err := first.Add(Schedule{Tokens: []string{"a"}, SendAt: clock.Now().Add(time.Hour)})
+		require.NoError(t, err)
+		cancelled, err := first.Add(Schedule{Tokens: []string{"b"}, SendAt: clock.Now().Add(time.Hour)})
+		require.NoError(t, err)
+		require.True(t, first.Cancel(cancelled.ID))
+		require.NoError(t, first.Shutdown(context.Background()))
+
+		// --- Execute ---
+		sender := &fakeSender{}
+		second := NewScheduler(sender, Config{Clock: clock, Store: store})
+		restored, err := second.Recover()
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		second.Start(ctx)
+		pending := second.List()
+		clock.Advance(time.Hour)
+
+		// --- Assert ---
+		assert.Empty(t, dropped, "schedules kept in the store are not reported as dropped")
+		assert.Equal(t, 1, restored)
+		require.Len(t, pending, 1)
+		assert.Equal(t, kept.ID, pending[0].ID)
+		require.Eventually(t, func() bool {
+			sent, _ := sender.sent()
+			return len(sent) == 1
+		}, time.Second, 5*time.Millisecond)
+		sent, _ := sender.sent()
+		assert.Equal(t, []string{"a"}, sent)
+		loaded, err := store.Load()
+		require.NoError(t, err)
+		assert.Empty(t, loaded, "sent schedules are removed from the store")
+	})
+
+	t.Run("success - schedules due during the downtime are sent on start", func(t *testing.T) {
+		// --- Setup ---
+		store, err := NewFileStore(t.TempDir())
+		require.NoError(t, err)
+		clock := newFakeClock()
+		require.NoError(t, store.Save(Schedule{ID: "late", Tokens: []string{"a"}, SendAt: clock.Now().Add(-time.Minute)}))
+		sender := &fakeSender{}
+		s := NewScheduler(sender, Config{Clock: clock, Store: store})
+
+		// --- Execute ---
+		_, err = s.Recover()
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s.Start(ctx)
+
+		// --- Assert ---
+		require.Eventually(t, func() bool {
+			sent, _ := sender.sent()
+			return len(sent) == 1
+		}, time.Second, 5*time.Millisecond)
+	})
+}
@@ -0,0 +1,99 @@
+// Package tracing sets up OpenTelemetry tracing for the gateway.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Exporters Config.Exporter can name.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// stdout receives the spans of ExporterStdout.
+var stdout io.Writer = os.Stdout
+
+// Config selects where spans go.
+type Config struct {
+	// Exporter is one of the Exporter constants. Empty means none.
+	Exporter string
+	// Endpoint is the host:port of the OTLP/HTTP collector. When empty the
+	// OTEL_EXPORTER_OTLP_* environment variables apply.
+	Endpoint string
+	// Insecure sends OTLP over plain HTTP.
+	Insecure bool
+	// SampleRatio is the share of traces started here that are recorded.
+	// Traces sampled by the caller are always recorded.
+	SampleRatio float64
+	ServiceName string
+	Version     string
+}
+
+// Setup returns the TracerProvider for cfg, and a function that flushes
+// pending spans on shutdown. With no exporter it returns a no-op provider.
+// It also installs the W3C trace context propagator globally.
+func Setup(ctx context.Context, cfg Config) (trace.TracerProvider, func(context.Context) error, error) {
+	otel.SetTextMapPropagator(Propagator())
+
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case "", ExporterNone:
+		return noop.NewTracerProvider(), func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		var err error
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(stdout))
+		if err != nil {
+			return nil, nil, fmt.Errorf("create stdout span exporter: %w", err)
+		}
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		var err error
+		exporter, err = otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("create OTLP span exporter: %w", err)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown tracing exporter %q, use %s, %s or %s", cfg.Exporter, ExporterNone, ExporterStdout, ExporterOTLP)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(cfg.ServiceName),
+		semconv.ServiceVersion(cfg.Version),
+	))
+	if err != nil {
+		return nil, nil, fmt.Errorf("create tracing resource: %w", err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	return tp, tp.Shutdown, nil
+}
+
+// Propagator reads and writes W3C traceparent, tracestate and baggage
+// headers.
+func Propagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestSetup(t *testing.T) {
+	t.Run("success - no exporter traces nothing", func(t *testing.T) {
+		tp, shutdown, err := Setup(context.Background(), Config{})
+
+		require.NoError(t, err)
+		assert.IsType(t, noop.TracerProvider{}, tp)
+		assert.NoError(t, shutdown(context.Background()))
+	})
+
+	t.Run("success - stdout exporter writes spans on shutdown", func(t *testing.T) {
+		// --- Setup ---
+		var buf bytes.Buffer
+		stdout = &buf
+		t.Cleanup(func() { stdout = os.Stdout })
+
+		// --- Execute ---
+		tp, shutdown, err := Setup(context.Background(), Config{Exporter: ExporterStdout, SampleRatio: 1, ServiceName: "fcm-gateway", Version: "1.2.3"})
+		require.NoError(t, err)
+		_, span := tp.Tracer("test").Start(context.Background(), "hello")
+		span.End()
+		require.NoError(t, shutdown(context.Background()))
+
+		// --- Assert ---
+		assert.Contains(t, buf.String(), `"Name":"hello"`)
+		assert.Contains(t, buf.String(), `"Value":"fcm-gateway"`)
+	})
+
+	t.Run("success - OTLP exporter is created without connecting", func(t *testing.T) {
+		tp, shutdown, err := Setup(context.Background(), Config{Exporter: ExporterOTLP, Endpoint: "localhost:4318", Insecure: true, SampleRatio: 0.5})
+
+		require.NoError(t, err)
+		assert.IsType(t, &sdktrace.TracerProvider{}, tp)
+		assert.NoError(t, shutdown(context.Background()))
+	})
+
+	t.Run("error - unknown exporter", func(t *testing.T) {
+		_, _, err := Setup(context.Background(), Config{Exporter: "zipkin"})
+
+		assert.ErrorContains(t, err, `unknown tracing exporter "zipkin"`)
+	})
+}
@@ -0,0 +1,21 @@
+// Package wait holds the pause between retries shared by the FCM client and
+// the callback notifier.
+package wait
+
+import (
+	"context"
+	"time"
+)
+
+// Sleep waits for d, or returns ctx.Err() as soon as ctx is done.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,29 @@
+package wait
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSleep(t *testing.T) {
+	t.Run("success - waits for the duration", func(t *testing.T) {
+		start := time.Now()
+
+		err := Sleep(context.Background(), 10*time.Millisecond)
+
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+	})
+
+	t.Run("error - returns when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := Sleep(ctx, time.Hour)
+
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
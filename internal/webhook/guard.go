@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrURLNotAllowed is returned for callback URLs the Notifier may not call.
+var ErrURLNotAllowed = errors.New("callback URL not allowed")
+
+// nonPublicPrefixes are the ranges not covered by the net.IP predicates that
+// must not be reachable from a caller-supplied callback URL.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// CheckURL reports whether callbackURL may receive callbacks: it must use
+// https, or http when AllowHTTP is set, name a host of AllowedHosts and,
+// unless AllowPrivateNetworks is set, not be a non-public IP address. Host
+// names are checked again against their addresses when a callback is sent.
+func (n *Notifier) CheckURL(callbackURL string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && (u.Scheme != "http" || !n.cfg.AllowHTTP)) {
+		if n.cfg.AllowHTTP {
+			return fmt.Errorf("%w: must be an absolute http or https URL: %q", ErrURLNotAllowed, callbackURL)
+		}
+		return fmt.Errorf("%w: must be an absolute https URL: %q", ErrURLNotAllowed, callbackURL)
+	}
+	host := strings.ToLower(u.Hostname())
+	if len(n.cfg.AllowedHosts) > 0 && !slices.ContainsFunc(n.cfg.AllowedHosts, func(allowed string) bool {
+		return matchHost(strings.ToLower(allowed), host)
+	}) {
+		return fmt.Errorf("%w: host %s is not an allowed callback host", ErrURLNotAllowed, host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !n.cfg.AllowPrivateNetworks && !isPublic(ip) {
+		return fmt.Errorf("%w: %s is not a public address", ErrURLNotAllowed, host)
+	}
+	return nil
+}
+
+// matchHost reports whether host is allowed, or a subdomain of it when allowed
+// starts with "*.".
+func matchHost(allowed, host string) bool {
+	if suffix, ok := strings.CutPrefix(allowed, "*"); ok && strings.HasPrefix(suffix, ".") {
+		return strings.HasSuffix(host, suffix)
+	}
+	return allowed == host
+}
+
+func isPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	return !slices.ContainsFunc(nonPublicPrefixes, func(p netip.Prefix) bool { return p.Contains(addr) })
+}
+
+// callbackClient returns the client for caller-supplied callback URLs. Unless
+// AllowPrivateNetworks is set it refuses to connect to non-public addresses,
+// whatever the host name resolved to, and bypasses HTTP proxies so the check
+// applies to the callback host itself. Redirects are not followed.
+func callbackClient(cfg Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !cfg.AllowPrivateNetworks {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control: func(_, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !isPublic(ip) {
+					return fmt.Errorf("%w: %s is not a public address", ErrURLNotAllowed, host)
+				}
+				return nil
+			},
+		}
+		transport.DialContext = dialer.DialContext
+		transport.Proxy = nil
+	}
+	return &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,318 @@
+// Package webhook reports send results back to the calling service by
+// POSTing signed JSON to a callback URL.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wirsal/fcm-gateway/fcm"
+	"github.com/wirsal/fcm-gateway/internal/ids"
+	"github.com/wirsal/fcm-gateway/internal/job"
+	"github.com/wirsal/fcm-gateway/internal/schedule"
+	"github.com/wirsal/fcm-gateway/internal/wait"
+)
+
+const (
+	// SignatureHeader carries "t=<unix seconds>,v1=<hex HMAC-SHA256>" where
+	// the HMAC covers "<unix seconds>.<body>".
+	SignatureHeader = "X-Gateway-Signature"
+	// EventIDHeader carries Event.ID so receivers can drop duplicates.
+	EventIDHeader = "X-Gateway-Event-Id"
+)
+
+// Event types.
+const (
+	TypeSendCompleted      = "send.completed"
+	TypeUsersCompleted     = "users.completed"
+	TypeBroadcastCompleted = "broadcast.completed"
+	TypeTopicCompleted     = "topic.completed"
+	TypeJobCompleted       = "job.completed"
+	TypeScheduleCompleted  = "schedule.completed"
+)
+
+// Event is the JSON body of a callback.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+
+	JobID      string `json:"job_id,omitempty"`
+	ScheduleID string `json:"schedule_id,omitempty"`
+	Topic      string `json:"topic,omitempty"`
+	Condition  string `json:"condition,omitempty"`
+	DryRun     bool   `json:"dry_run"`
+
+	SuccessCount int `json:"success_count"`
+	FailureCount int `json:"failure_count"`
+	// Results holds the outcome of every device token of a token send.
+	Results []fcm.TokenResult `json:"results,omitempty"`
+	// MessageName, Error and ErrorCode describe a topic or condition send.
+	MessageName string `json:"message_name,omitempty"`
+	Error       string `json:"error,omitempty"`
+	ErrorCode   string `json:"error_code,omitempty"`
+}
+
+// TokensEvent describes a send to a list of device tokens.
+func TokensEvent(typ string, results []fcm.TokenResult, dryRun bool) Event {
+	ev := Event{Type: typ, DryRun: dryRun, Results: results}
+	for _, r := range results {
+		if r.Error != "" {
+			ev.FailureCount++
+		} else {
+			ev.SuccessCount++
+		}
+	}
+	return ev
+}
+
+// TargetEvent describes a send to a topic or condition.
+func TargetEvent(typ string, res fcm.SendResult, err error) Event {
+	ev := Event{Type: typ, DryRun: res.ValidateOnly, MessageName: res.Name}
+	if err != nil {
+		ev.FailureCount = 1
+		ev.Error = err.Error()
+		ev.ErrorCode = fcm.ErrorCode(err)
+	} else {
+		ev.SuccessCount = 1
+	}
+	return ev
+}
+
+// JobEvent describes a finished job.
+func JobEvent(j job.Job) Event {
+	ev := TokensEvent(TypeJobCompleted, j.Results, j.DryRun)
+	ev.JobID = j.ID
+	return ev
+}
+
+// ScheduleEvent describes a schedule that was sent.
+func ScheduleEvent(sch schedule.Schedule, out schedule.Outcome) Event {
+	var ev Event
+	if sch.Condition != "" {
+		ev = TargetEvent(TypeScheduleCompleted, out.Result, out.Err)
+		ev.Condition = sch.Condition
+	} else {
+		ev = TokensEvent(TypeScheduleCompleted, out.Results, sch.DryRun)
+	}
+	ev.ScheduleID = sch.ID
+	ev.DryRun = sch.DryRun || ev.DryRun
+	return ev
+}
+
+// Sign returns the SignatureHeader value for body sent at t.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type Config struct {
+	// Secret is the HMAC-SHA256 key used to sign every callback.
+	Secret string
+	// DefaultURL receives the results of requests that did not set their own
+	// callback URL. Results are not reported when it is empty.
+	DefaultURL string
+	// RetryDelays are the waits before each retry of a failed delivery, so a
+	// callback is attempted len(RetryDelays)+1 times.
+	RetryDelays []time.Duration
+	// Timeout bounds a single delivery attempt.
+	Timeout time.Duration
+	// AllowedHosts limits callback URLs to these hosts; an entry starting
+	// with "*." matches the subdomains of the rest. Every host is allowed
+	// when it is empty. DefaultURL is exempt.
+	AllowedHosts []string
+	// AllowHTTP accepts plain http callback URLs besides https ones.
+	AllowHTTP bool
+	// AllowPrivateNetworks lets callback URLs reach loopback, private and
+	// link-local addresses. DefaultURL may always reach them.
+	AllowPrivateNetworks bool
+}
+
+// Notifier delivers events in the background. A nil *Notifier drops every
+// event.
+type Notifier struct {
+	cfg Config
+	// httpClient calls DefaultURL, callbackClient every other URL.
+	httpClient     *http.Client
+	callbackClient *http.Client
+	now            func() time.Time
+	sleep          func(ctx context.Context, d time.Duration) error
+
+	// ctx is cancelled when Shutdown gives up on the deliveries under way.
+	ctx    context.Context
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	closed bool
+	sends  sync.WaitGroup
+}
+
+func NewNotifier(cfg Config) *Notifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Notifier{
+		cfg:            cfg,
+		httpClient:     &http.Client{Timeout: cfg.Timeout},
+		callbackClient: callbackClient(cfg),
+		now:            time.Now,
+		sleep:          wait.Sleep,
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+}
+
+// Notify sends ev to callbackURL, or to the default URL when callbackURL is
+// empty, without waiting for the delivery.
+func (n *Notifier) Notify(callbackURL string, ev Event) {
+	if n == nil {
+		return
+	}
+	if callbackURL == "" {
+		callbackURL = n.cfg.DefaultURL
+	}
+	if callbackURL == "" {
+		return
+	}
+	ev, err := n.stamp(ev)
+	if err != nil {
+		slog.Warn("Dropping webhook event", "type", ev.Type, "error", err)
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		slog.Warn("Dropping webhook event after shutdown", "type", ev.Type, "event_id", ev.ID, "url", callbackURL)
+		return
+	}
+	n.sends.Add(1)
+	go func() {
+		defer n.sends.Done()
+		if err := n.Deliver(n.ctx, callbackURL, ev); err != nil {
+			slog.Warn("Giving up on webhook event", "type", ev.Type, "event_id", ev.ID, "url", callbackURL, "error", err)
+		}
+	}()
+}
+
+// Shutdown drops events notified from now on and waits for the deliveries
+// under way, retries included. When ctx is done first they are cancelled
+// and Shutdown returns ctx's error.
+func (n *Notifier) Shutdown(ctx context.Context) error {
+	if n == nil {
+		return nil
+	}
+	n.mu.Lock()
+	n.closed = true
+	n.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.sends.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		n.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// JobFinished reports j to its callback URL. It fits job.Config.OnFinish.
+func (n *Notifier) JobFinished(j job.Job) {
+	n.Notify(j.CallbackURL, JobEvent(j))
+}
+
+// ScheduleSent reports a sent schedule to its callback URL. It fits
+// schedule.Config.OnSent.
+func (n *Notifier) ScheduleSent(sch schedule.Schedule, out schedule.Outcome) {
+	n.Notify(sch.CallbackURL, ScheduleEvent(sch, out))
+}
+
+// Deliver POSTs ev to callbackURL, retrying on network errors, 429 and 5xx
+// responses according to the retry schedule. URLs other than DefaultURL must
+// pass CheckURL.
+func (n *Notifier) Deliver(ctx context.Context, callbackURL string, ev Event) error {
+	client := n.httpClient
+	if callbackURL != n.cfg.DefaultURL {
+		if err := n.CheckURL(callbackURL); err != nil {
+			return err
+		}
+		client = n.callbackClient
+	}
+	ev, err := n.stamp(ev)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		retryable, err := n.post(ctx, client, callbackURL, ev.ID, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= len(n.cfg.RetryDelays) {
+			return fmt.Errorf("after %d attempts: %w", attempt+1, err)
+		}
+		if sleepErr := n.sleep(ctx, n.cfg.RetryDelays[attempt]); sleepErr != nil {
+			return fmt.Errorf("%w (retry aborted: %v)", err, sleepErr)
+		}
+	}
+}
+
+// stamp fills in the ID and creation time of ev unless already set.
+func (n *Notifier) stamp(ev Event) (Event, error) {
+	if ev.ID == "" {
+		id, err := ids.New()
+		if err != nil {
+			return ev, err
+		}
+		ev.ID = id
+	}
+	if ev.CreatedAt.IsZero() {
+		ev.CreatedAt = n.now()
+	}
+	return ev, nil
+}
+
+func (n *Notifier) post(ctx context.Context, client *http.Client, callbackURL, eventID string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventIDHeader, eventID)
+	// Signed per attempt so the timestamp stays fresh across retries.
+	req.Header.Set(SignatureHeader, Sign(n.cfg.Secret, n.now(), body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil && !errors.Is(err, ErrURLNotAllowed), err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return false, nil
+	}
+	retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retryable, fmt.Errorf("callback returned %s", resp.Status)
+}
@@ -0,0 +1,332 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wirsal/fcm-gateway/fcm"
+	"github.com/wirsal/fcm-gateway/internal/job"
+	"github.com/wirsal/fcm-gateway/internal/schedule"
+)
+
+// newTestNotifier returns a Notifier with a fixed clock that records its
+// retry waits instead of sleeping. It may call the http://127.0.0.1 URLs of
+// test servers.
+func newTestNotifier(cfg Config) (*Notifier, *[]time.Duration) {
+	cfg.AllowHTTP = true
+	cfg.AllowPrivateNetworks = true
+	n := NewNotifier(cfg)
+	n.now = func() time.Time { return time.Unix(1700000000, 0) }
+	var slept []time.Duration
+	n.sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+	return n, &slept
+}
+
+func TestNotifier_Deliver(t *testing.T) {
+	t.Run("success - posts the event signed with HMAC-SHA256", func(t *testing.T) {
+		// --- Setup ---
+		var gotBody []byte
+		var gotHeader http.Header
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotBody, _ = io.ReadAll(r.Body)
+			gotHeader = r.Header.Clone()
+		}))
+		defer server.Close()
+		n, _ := newTestNotifier(Config{Secret: "s3cret"})
+		ev := TokensEvent(TypeSendCompleted, []fcm.TokenResult{
+			{Token: "a", MessageName: "projects/p/messages/1", Attempts: 1},
+			{Token: "b", Attempts: 1, Error: "gone", ErrorCode: fcm.ErrorCodeUnregistered},
+		}, false)
+
+		// --- Execute ---
+		err := n.Deliver(context.Background(), server.URL, ev)
+
+		// --- Assert ---
+		require.NoError(t, err)
+		assert.Equal(t, "application/json", gotHeader.Get("Content-Type"))
+		assert.Equal(t, Sign("s3cret", time.Unix(1700000000, 0), gotBody), gotHeader.Get(SignatureHeader))
+		assert.Regexp(t, `^t=1700000000,v1=[0-9a-f]{64}$`, gotHeader.Get(SignatureHeader))
+
+		var got Event
+		require.NoError(t, json.Unmarshal(gotBody, &got))
+		assert.Equal(t, gotHeader.Get(EventIDHeader), got.ID)
+		assert.NotEmpty(t, got.ID)
+		assert.Equal(t, TypeSendCompleted, got.Type)
+		assert.Equal(t, 1, got.SuccessCount)
+		assert.Equal(t, 1, got.FailureCount)
+		assert.Equal(t, "projects/p/messages/1", got.Results[0].MessageName)
+		assert.Equal(t, fcm.ErrorCodeUnregistered, got.Results[1].ErrorCode)
+	})
+
+	t.Run("success - retries 5xx responses on the retry schedule", func(t *testing.T) {
+		// --- Setup ---
+		var mu sync.Mutex
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls++
+			if calls < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer server.Close()
+		n, slept := newTestNotifier(Config{Secret: "s", RetryDelays: []time.Duration{time.Second, time.Minute, time.Hour}})
+
+		// --- Execute ---
+		err := n.Deliver(context.Background(), server.URL, Event{Type: TypeTopicCompleted})
+
+		// --- Assert ---
+		require.NoError(t, err)
+		assert.Equal(t, 3, calls)
+		assert.Equal(t, []time.Duration{time.Second, time.Minute}, *slept)
+	})
+
+	t.Run("error - gives up when the retry schedule is exhausted", func(t *testing.T) {
+		// --- Setup ---
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+		n, _ := newTestNotifier(Config{Secret: "s", RetryDelays: []time.Duration{time.Second}})
+
+		// --- Execute ---
+		err := n.Deliver(context.Background(), server.URL, Event{Type: TypeTopicCompleted})
+
+		// --- Assert ---
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "after 2 attempts")
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("error - does not retry 4xx responses", func(t *testing.T) {
+		// --- Setup ---
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer server.Close()
+		n, slept := newTestNotifier(Config{Secret: "s", RetryDelays: []time.Duration{time.Second}})
+
+		// --- Execute ---
+		err := n.Deliver(context.Background(), server.URL, Event{Type: TypeTopicCompleted})
+
+		// --- Assert ---
+		require.Error(t, err)
+		assert.Equal(t, 1, calls)
+		assert.Empty(t, *slept)
+	})
+}
+
+func TestNotifier_Notify(t *testing.T) {
+	t.Run("success - falls back to the default URL", func(t *testing.T) {
+		// --- Setup ---
+		received := make(chan Event, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var ev Event
+			_ = json.NewDecoder(r.Body).Decode(&ev)
+			received <- ev
+		}))
+		defer server.Close()
+		n, _ := newTestNotifier(Config{Secret: "s", DefaultURL: server.URL})
+
+		// --- Execute ---
+		n.JobFinished(job.Job{ID: "job-1", Results: []fcm.TokenResult{{Token: "a"}}})
+
+		// --- Assert ---
+		select {
+		case ev := <-received:
+			assert.Equal(t, TypeJobCompleted, ev.Type)
+			assert.Equal(t, "job-1", ev.JobID)
+			assert.Equal(t, 1, ev.SuccessCount)
+		case <-time.After(2 * time.Second):
+			t.Fatal("callback was not delivered")
+		}
+	})
+
+	t.Run("success - nil notifier and missing URL drop the event", func(t *testing.T) {
+		var nilNotifier *Notifier
+		nilNotifier.Notify("http://127.0.0.1:1", Event{})
+
+		n, _ := newTestNotifier(Config{Secret: "s"})
+		n.Notify("", Event{})
+	})
+}
+
+func TestNotifier_Shutdown(t *testing.T) {
+	t.Run("success - waits for deliveries and drops later events", func(t *testing.T) {
+		// --- Setup ---
+		var mu sync.Mutex
+		calls := 0
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+			<-release
+		}))
+		defer server.Close()
+		n, _ := newTestNotifier(Config{Secret: "s", DefaultURL: server.URL})
+		n.Notify("", Event{Type: TypeJobCompleted})
+		require.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return calls == 1
+		}, time.Second, 5*time.Millisecond)
+
+		// --- Execute ---
+		done := make(chan error, 1)
+		go func() { done <- n.Shutdown(context.Background()) }()
+		require.Eventually(t, func() bool {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			return n.closed
+		}, time.Second, time.Millisecond)
+		n.Notify("", Event{Type: TypeJobCompleted})
+		close(release)
+
+		// --- Assert ---
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Shutdown did not return")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("error - cancels retries still waiting when ctx is done", func(t *testing.T) {
+		// --- Setup ---
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+		n := NewNotifier(Config{Secret: "s", DefaultURL: server.URL, RetryDelays: []time.Duration{time.Hour}, Timeout: time.Second})
+		n.Notify("", Event{Type: TypeJobCompleted})
+
+		// --- Execute ---
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := n.Shutdown(ctx)
+
+		// --- Assert ---
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("success - nil notifier", func(t *testing.T) {
+		var n *Notifier
+
+		assert.NoError(t, n.Shutdown(context.Background()))
+	})
+}
+
+func TestNotifier_CheckURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		url     string
+		wantErr string
+	}{
+		{"success - public https URL", Config{}, "https://hooks.example.com/fcm", ""},
+		{"success - allowed host", Config{AllowedHosts: []string{"hooks.example.com"}}, "https://hooks.example.com/fcm", ""},
+		{"success - allowed subdomain", Config{AllowedHosts: []string{"*.example.com"}}, "https://a.hooks.example.com/fcm", ""},
+		{"success - http when allowed", Config{AllowHTTP: true}, "http://hooks.example.com/fcm", ""},
+		{"success - private address when allowed", Config{AllowPrivateNetworks: true}, "https://10.0.0.5/fcm", ""},
+		{"error - http", Config{}, "http://hooks.example.com/fcm", "must be an absolute https URL"},
+		{"error - relative URL", Config{AllowHTTP: true}, "/fcm", "must be an absolute http or https URL"},
+		{"error - host not allowed", Config{AllowedHosts: []string{"*.example.com"}}, "https://example.org/fcm", "host example.org is not an allowed callback host"},
+		{"error - loopback", Config{}, "https://127.0.0.1/fcm", "127.0.0.1 is not a public address"},
+		{"error - link-local metadata", Config{}, "https://169.254.169.254/latest/meta-data", "169.254.169.254 is not a public address"},
+		{"error - private IPv6", Config{}, "https://[fd00::1]/fcm", "fd00::1 is not a public address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewNotifier(tt.cfg).CheckURL(tt.url)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrURLNotAllowed)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestNotifier_callbackClient(t *testing.T) {
+	t.Run("error - host names resolving to loopback are refused", func(t *testing.T) {
+		// --- Setup ---
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+		}))
+		defer server.Close()
+		n := NewNotifier(Config{Secret: "s", AllowHTTP: true, RetryDelays: []time.Duration{time.Millisecond}})
+		callbackURL := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+		// --- Execute ---
+		err := n.Deliver(context.Background(), callbackURL, Event{Type: TypeSendCompleted})
+
+		// --- Assert ---
+		assert.ErrorIs(t, err, ErrURLNotAllowed)
+		assert.ErrorContains(t, err, "after 1 attempts")
+		assert.Zero(t, calls.Load())
+	})
+
+	t.Run("success - default URL may be private", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+		n := NewNotifier(Config{Secret: "s", DefaultURL: server.URL})
+
+		err := n.Deliver(context.Background(), server.URL, Event{Type: TypeSendCompleted})
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("error - redirects are not followed", func(t *testing.T) {
+		var calls atomic.Int32
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+		}))
+		defer target.Close()
+		server := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+		defer server.Close()
+		n, _ := newTestNotifier(Config{Secret: "s"})
+
+		err := n.Deliver(context.Background(), server.URL, Event{Type: TypeSendCompleted})
+
+		assert.ErrorContains(t, err, "307")
+		assert.Zero(t, calls.Load())
+	})
+}
+
+func TestScheduleEvent(t *testing.T) {
+	ev := ScheduleEvent(
+		schedule.Schedule{ID: "sch-1", Condition: "'news' in topics"},
+		schedule.Outcome{Result: fcm.SendResult{Attempts: 3}, Err: &fcm.Error{StatusCode: 503, Code: fcm.ErrorCodeUnavailable, Message: "down"}},
+	)
+
+	assert.Equal(t, TypeScheduleCompleted, ev.Type)
+	assert.Equal(t, "sch-1", ev.ScheduleID)
+	assert.Equal(t, "'news' in topics", ev.Condition)
+	assert.Equal(t, 1, ev.FailureCount)
+	assert.Equal(t, fcm.ErrorCodeUnavailable, ev.ErrorCode)
+}